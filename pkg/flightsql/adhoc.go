@@ -0,0 +1,119 @@
+package flightsql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/apache/arrow/go/v12/arrow"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"google.golang.org/grpc/metadata"
+)
+
+// adhocFilter is an ad hoc filter selected on a dashboard, applied to every
+// query of the datasource on it.
+type adhocFilter struct {
+	Key      string `json:"key"`
+	Operator string `json:"operator"`
+	Value    string `json:"value"`
+}
+
+// adhocOperators are the operators of Grafana's ad hoc filters that can be
+// applied, mapped to the SQL operators they are applied with.
+var adhocOperators = map[string]string{
+	"=":  "=",
+	"!=": "!=",
+	"<":  "<",
+	">":  ">",
+	"<=": "<=",
+	">=": ">=",
+}
+
+// applyAdhocFilters restricts the rows of a query to those matching filters.
+// The query is wrapped in a subquery rather than edited so that its own
+// WHERE, GROUP BY and LIMIT clauses are left intact, and the filters apply
+// to the columns it returns. Values are rendered as string literals, since
+// Grafana represents all filter values as strings, except that those of the
+// columns in numeric are rendered as numbers if they are numbers.
+func applyAdhocFilters(sql string, filters []adhocFilter, numeric map[string]bool) (string, error) {
+	if len(filters) == 0 {
+		return sql, nil
+	}
+	conds := make([]string, len(filters))
+	for i, f := range filters {
+		if f.Key == "" {
+			return "", fmt.Errorf("ad hoc filter key is required")
+		}
+		op, ok := adhocOperators[f.Operator]
+		if !ok {
+			return "", fmt.Errorf("unsupported ad hoc filter operator: %s", f.Operator)
+		}
+		value, err := json.Marshal(f.Value)
+		if err != nil {
+			return "", err
+		}
+		if numeric[f.Key] {
+			value = variableParameter(value)
+		}
+		cond, err := builderFilter{Column: f.Key, Operator: op, Value: value}.sql()
+		if err != nil {
+			return "", err
+		}
+		conds[i] = cond
+	}
+	// The query is on its own lines so that a trailing comment doesn't
+	// comment out the rest.
+	sql = strings.TrimRight(strings.TrimSpace(sql), ";")
+	return fmt.Sprintf("SELECT * FROM (\n%s\n) AS adhoc_filtered WHERE %s", sql, strings.Join(conds, " AND ")), nil
+}
+
+// adhocQuery applies the ad hoc filters of query to its SQL, with the values
+// of the numeric columns of the ad hoc filter table rendered as numbers.
+func (d *FlightSQLDatasource) adhocQuery(ctx context.Context, query *sqlQuery) error {
+	if len(query.AdhocFilters) == 0 {
+		return nil
+	}
+	sql, err := applyAdhocFilters(query.RawSQL, query.AdhocFilters, d.numericColumns(ctx, *query))
+	if err != nil {
+		return err
+	}
+	query.RawSQL = sql
+	query.AdhocFilters = nil
+	return nil
+}
+
+// numericColumns returns the names of the numeric columns of the ad hoc
+// filter table, or nil if none is configured or its schema can't be read, in
+// which case all filter values are compared as strings.
+func (d *FlightSQLDatasource) numericColumns(ctx context.Context, query sqlQuery) map[string]bool {
+	table := d.cfg.AdhocFilterTable
+	if table == "" {
+		return nil
+	}
+	if d.adhocColumns != nil {
+		if columns, ok := d.adhocColumns.Get(table); ok {
+			return columns
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	ctx = metadata.NewOutgoingContext(ctx, d.queryMetadata(query))
+	schema, err := d.tableSchema(ctx, table)
+	if err != nil {
+		log.DefaultLogger.Warn("Failed to read the ad hoc filter table's schema", "table", table, "error", err)
+		return nil
+	}
+	columns := make(map[string]bool)
+	for _, f := range schema.Fields() {
+		if t := f.Type.ID(); arrow.IsInteger(t) || arrow.IsFloating(t) || arrow.IsDecimal(t) {
+			columns[f.Name] = true
+		}
+	}
+	if d.adhocColumns != nil {
+		d.adhocColumns.Set(table, columns)
+	}
+	return columns
+}
@@ -0,0 +1,67 @@
+package flightsql
+
+import (
+	"context"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyAdhocFilters(t *testing.T) {
+	sql, err := applyAdhocFilters("select * from cpu", nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, "select * from cpu", sql)
+
+	numeric := map[string]bool{"usage": true}
+	sql, err = applyAdhocFilters("select * from cpu; -- all hosts", []adhocFilter{
+		{Key: "host", Operator: "=", Value: "a'b"},
+		{Key: "usage", Operator: ">", Value: "10"},
+		{Key: "rack", Operator: "=", Value: "42"},
+	}, numeric)
+	require.NoError(t, err)
+	require.Equal(t, "SELECT * FROM (\nselect * from cpu; -- all hosts\n) AS adhoc_filtered WHERE \"host\" = 'a''b' AND \"usage\" > 10 AND \"rack\" = '42'", sql)
+
+	_, err = applyAdhocFilters("select * from cpu", []adhocFilter{{Key: "host", Operator: "=~", Value: "a.*"}}, nil)
+	require.EqualError(t, err, "unsupported ad hoc filter operator: =~")
+
+	_, err = applyAdhocFilters("select * from cpu", []adhocFilter{{Operator: "=", Value: "a"}}, nil)
+	require.Error(t, err)
+}
+
+func TestDecodeQueryRequest_AdhocFilters(t *testing.T) {
+	query, err := decodeQueryRequest(backend.DataQuery{
+		JSON: []byte(`{"queryText": "select * from cpu", "adhocFilters": [{"key": "host", "operator": "!=", "value": "a"}]}`),
+	})
+	require.NoError(t, err)
+	require.Equal(t, []adhocFilter{{Key: "host", Operator: "!=", Value: "a"}}, query.AdhocFilters)
+
+	query, err = decodeQueryRequest(backend.DataQuery{
+		JSON: []byte(`{"queryText": "delete from cpu", "statement": true, "adhocFilters": [{"key": "host", "operator": "=", "value": "a"}]}`),
+	})
+	require.NoError(t, err)
+	require.Equal(t, "delete from cpu", query.RawSQL)
+	require.Empty(t, query.AdhocFilters)
+}
+
+func TestIntegration_QueryData_AdhocFilters(t *testing.T) {
+	ds := newIntegrationDatasource(t)
+	ds.cfg.AdhocFilterTable = "intTable"
+
+	// The value column is numeric, so its filter value is compared as a
+	// number, while keyName's is compared as a string.
+	resp, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{{
+			RefID: "A",
+			JSON: []byte(`{"refId": "A", "format": "table", "queryText": "select * from intTable", "adhocFilters": [
+				{"key": "value", "operator": ">=", "value": "0"},
+				{"key": "keyName", "operator": "!=", "value": "one"}
+			]}`),
+		}},
+	})
+	require.NoError(t, err)
+	res := resp.Responses["A"]
+	require.NoError(t, res.Error)
+	require.Len(t, res.Frames, 1)
+	require.Equal(t, []*string{ptr("zero")}, extractFieldValues[*string](t, res.Frames[0].Fields[1]))
+}
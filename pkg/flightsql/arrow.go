@@ -1,26 +1,44 @@
 package flightsql
 
 import (
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"runtime/debug"
+	"strings"
 	"time"
 
 	"github.com/apache/arrow/go/v12/arrow"
 	"github.com/apache/arrow/go/v12/arrow/array"
+	"github.com/apache/arrow/go/v12/arrow/decimal128"
+	"github.com/apache/arrow/go/v12/arrow/decimal256"
+	"github.com/apache/arrow/go/v12/arrow/flight"
+	"github.com/apache/arrow/go/v12/arrow/float16"
+	"github.com/apache/arrow/go/v12/arrow/memory"
 	"github.com/apache/arrow/go/v12/arrow/scalar"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
 	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
 	"google.golang.org/grpc/metadata"
 )
 
-// TODO(brett): Make this configurable. This is an arbitrary value right
-// now. Grafana used to have a 1M row rowLimit established in open-source. I'll
-// let users hit that for now until we decide how to proceed.
-const rowLimit = 1_000_000
+// defaultRowLimit is used when the datasource doesn't configure a row limit.
+// Grafana used to have a 1M row limit established in open-source.
+const defaultRowLimit = 1_000_000
+
+// defaultMemoryBudget is used when the datasource doesn't configure a memory
+// budget. Results are held in memory until the whole response is sent, so
+// without a budget a wide enough result could exhaust the plugin's memory
+// before reaching the row limit.
+const defaultMemoryBudget = 1 << 30
+
+// budgetCheckRows is the number of rows copied between checks of the memory
+// budget.
+const budgetCheckRows = 1024
 
 type recordReader interface {
 	Next() bool
@@ -30,130 +48,431 @@ type recordReader interface {
 }
 
 // newQueryDataResponse builds a [backend.DataResponse] from a stream of
-// [arrow.Record]s.
+// [arrow.Record]s, returning it along with statistics about reading them.
 //
-// The backend.DataResponse contains a single [data.Frame].
-func newQueryDataResponse(reader recordReader, query sqlutil.Query, headers metadata.MD) backend.DataResponse {
+// The backend.DataResponse contains a single [data.Frame] unless the query
+// bounds the number of rows per frame, in which case table results are split
+// across as many frames as needed. Splitting bounds the size of each frame,
+// not of the response, whose frames are all held until it is sent; the
+// response is bounded by the row limit and the memory budget. If reading
+// fails part way the rows read so far are returned with a notice.
+func newQueryDataResponse(reader recordReader, query sqlQuery, headers metadata.MD) (backend.DataResponse, readStats) {
 	var resp backend.DataResponse
-	frame, err := frameForRecords(reader)
+
+	opts := readOptions{
+		maxRows:  query.MaxRows,
+		maxBytes: query.MaxBytes,
+		convert: convertOptions{
+			decimalAsString: query.DecimalAsString,
+			binaryEncoding:  query.BinaryEncoding,
+			location:        query.Location,
+		},
+	}
+	// Partitioned results are split by their values rather than their size.
+	if query.Format == sqlutil.FormatOptionTable && len(query.PartitionBy) == 0 {
+		opts.maxFrameRows = query.MaxFrameRows
+	}
+	var stats readStats
+	opts.stats = &stats
+	var frames data.Frames
+	err := readFrames(reader, opts, func(frame *data.Frame) error {
+		frames = append(frames, frame)
+		return nil
+	})
 	if err != nil {
-		resp.Error = err
+		// Rows read before the stream failed are still returned, with a
+		// notice, unless reading was aborted deliberately.
+		if stats.rows == 0 || errors.Is(err, errMemoryBudgetExceeded) {
+			resp.Error = err
+		} else {
+			frames[len(frames)-1].AppendNotices(data.Notice{
+				Severity: data.NoticeSeverityWarning,
+				Text:     fmt.Sprintf("Results are incomplete because reading them failed after %d rows: %s", stats.rows, err),
+			})
+		}
 	}
-	if frame.Rows() == 0 {
+	if frames[0].Rows() == 0 {
 		resp.Frames = data.Frames{}
-		return resp
+		return resp, stats
 	}
 
-	frame.Meta.Custom = map[string]any{
-		"headers": headers,
+	fields := schemaFields(reader.Schema())
+	for _, frame := range frames {
+		frame.Meta.Custom = map[string]any{
+			"headers": headers,
+			"schema":  fields,
+		}
+		frame.Meta.ExecutedQueryString = query.RawSQL
+		frame.Meta.DataTopic = data.DataTopic(query.RawSQL)
+		frame.Meta.Stats = stats.queryStats()
 	}
-	frame.Meta.ExecutedQueryString = query.RawSQL
-	frame.Meta.DataTopic = data.DataTopic(query.RawSQL)
 
+	frame := frames[0]
 	switch query.Format {
 	case sqlutil.FormatOptionTimeSeries:
-		if _, idx := frame.FieldByName("time"); idx == -1 {
+		idx := findTimeField(frame)
+		if idx == -1 {
 			resp.Error = fmt.Errorf("no time column found")
-			return resp
+			return resp, stats
 		}
 
-		if frame.TimeSeriesSchema().Type == data.TimeSeriesTypeLong {
+		frame = promoteTimeField(frame, idx)
+		partitions := []*partition{{frame: frame}}
+		if len(query.PartitionBy) > 0 {
+			for _, name := range query.PartitionBy {
+				if name == frame.Fields[0].Name {
+					resp.Error = fmt.Errorf("time series can't be partitioned by their time column")
+					return resp, stats
+				}
+			}
 			var err error
-			frame, err = data.LongToWide(frame, nil)
+			if partitions, err = partitionFrame(frame, query.PartitionBy); err != nil {
+				resp.Error = err
+				return resp, stats
+			}
+		}
+		frames = make(data.Frames, 0, len(partitions))
+		for _, p := range partitions {
+			frame, err := longToWide(p.frame, query.Labels, query.FillMissing)
 			if err != nil {
 				resp.Error = err
-				return resp
+				return resp, stats
+			}
+			addLabels(frame, p.labels)
+			if query.FillMissing != nil {
+				frame = fillGaps(frame, query.TimeRange, query.FillInterval, query.FillMissing)
 			}
+			frame.Meta.Type = data.FrameTypeTimeSeriesWide
+			frame.Meta.PreferredVisualization = data.VisTypeGraph
+			frames = append(frames, frame)
 		}
 	case sqlutil.FormatOptionTable:
-		// No changes to the output. Send it as is.
+		if len(query.PartitionBy) > 0 {
+			partitions, err := partitionFrame(frame, query.PartitionBy)
+			if err != nil {
+				resp.Error = err
+				return resp, stats
+			}
+			frames = make(data.Frames, len(partitions))
+			for i, p := range partitions {
+				addLabels(p.frame, p.labels)
+				frames[i] = p.frame
+			}
+		}
+		for _, frame := range frames {
+			frame.Meta.Type = data.FrameTypeTable
+			frame.Meta.PreferredVisualization = data.VisTypeTable
+		}
+		if query.Variable {
+			var err error
+			frame, err = variableFrame(frames)
+			if err != nil {
+				resp.Error = err
+				return resp, stats
+			}
+			frames = data.Frames{frame}
+		}
 	case sqlutil.FormatOptionLogs:
-		// TODO(brett): We need to find out what this actually is and if its
-		// worth supporting. Pass through as "table" for now.
+		var err error
+		frame, err = logsFrame(frame, query.Logs)
+		if err != nil {
+			resp.Error = err
+			return resp, stats
+		}
+		frames = data.Frames{frame}
 	default:
 		resp.Error = fmt.Errorf("unsupported format")
 	}
 
-	resp.Frames = data.Frames{frame}
-	return resp
+	applyColumnRules(frames, query.ColumnRules)
+	resp.Frames = frames
+	return resp, stats
+}
+
+// readOptions controls how [readFrames] reads records.
+type readOptions struct {
+	// maxRows is the number of rows after which the results are truncated.
+	// Zero means defaultRowLimit.
+	maxRows int64
+	// maxFrameRows is the maximum number of rows in each frame. Zero places
+	// every row in a single frame.
+	maxFrameRows int64
+	// maxBytes is the size of the rows that may be read into frames before
+	// reading is aborted with an error. Zero means defaultMemoryBudget.
+	maxBytes int64
+	// convert controls how the records are converted to frames.
+	convert convertOptions
+	// stats, if not nil, collects statistics about the read.
+	stats *readStats
 }
 
-// frameForRecords creates a [data.Frame] from a stream of [arrow.Record]s.
-func frameForRecords(reader recordReader) (*data.Frame, error) {
-	var (
-		frame = newFrame(reader.Schema())
-		rows  int64
-	)
+// convertOptions controls how Arrow columns are converted to frame fields.
+type convertOptions struct {
+	// decimalAsString converts decimals to their exact string representation
+	// rather than to float64.
+	decimalAsString bool
+	// binaryEncoding is the encoding of binary values as strings, either
+	// "base64" or "hex". The default is base64.
+	binaryEncoding string
+	// location is the time zone of timestamps without one. Nil means UTC.
+	location *time.Location
+}
+
+// errMemoryBudgetExceeded is returned when the results of a query exceed the
+// memory budget.
+var errMemoryBudgetExceeded = errors.New("query exceeded the memory budget")
+
+// schemaResponse returns an empty frame with the fields of a serialized
+// schema, for results with no endpoints to read them from.
+func schemaResponse(serialized []byte, query sqlQuery) backend.DataResponse {
+	frame := data.NewFrame("")
+	var custom map[string]any
+	if len(serialized) > 0 {
+		schema, err := flight.DeserializeSchema(serialized, memory.DefaultAllocator)
+		if err != nil {
+			return backend.ErrDataResponse(backend.StatusInternal, fmt.Sprintf("schema: %s", err))
+		}
+		frame = newFrame(schema, convertOptions{
+			decimalAsString: query.DecimalAsString,
+			binaryEncoding:  query.BinaryEncoding,
+			location:        query.Location,
+		})
+		custom = map[string]any{"schema": schemaFields(schema)}
+	}
+	frame.SetMeta(&data.FrameMeta{
+		ExecutedQueryString:    query.RawSQL,
+		Type:                   data.FrameTypeTable,
+		PreferredVisualization: data.VisTypeTable,
+		Custom:                 custom,
+	})
+	return backend.DataResponse{Frames: data.Frames{frame}}
+}
+
+// readFrames reads a stream of [arrow.Record]s into [data.Frame]s, passing
+// each frame to emit. emit is called at least once, and the last frame is
+// emitted even if reading fails part way. Once opts.maxRows is reached reading
+// stops and a notice is attached to the last frame.
+//
+// The frames don't refer to the records' buffers, so each record can be
+// released by the reader as soon as the next one is read.
+func readFrames(reader recordReader, opts readOptions, emit func(*data.Frame) error) error {
+	maxRows := opts.maxRows
+	if maxRows <= 0 {
+		maxRows = defaultRowLimit
+	}
+
+	frame := newFrame(reader.Schema(), opts.convert)
+	finish := func(err error) error {
+		if emitErr := emit(frame); emitErr != nil {
+			return emitErr
+		}
+		return err
+	}
+
+	stats := opts.stats
+	if stats == nil {
+		stats = &readStats{}
+	}
+	maxBytes := opts.maxBytes
+	if maxBytes <= 0 {
+		maxBytes = defaultMemoryBudget
+	}
+
+	var rows, bytes int64
 	for reader.Next() {
 		record := reader.Record()
-		for i, col := range record.Columns() {
-			if err := copyData(frame.Fields[i], col); err != nil {
-				return frame, err
+		stats.batches++
+		for offset := int64(0); offset < record.NumRows(); {
+			if rows == maxRows {
+				frame.AppendNotices(data.Notice{
+					Severity: data.NoticeSeverityWarning,
+					Text:     fmt.Sprintf("Results have been truncated at %d rows because the row limit was reached", maxRows),
+				})
+				return finish(nil)
+			}
+			// Full frames are only emitted once there are more rows to read
+			// so that the last frame is never empty.
+			if opts.maxFrameRows > 0 && int64(frame.Rows()) == opts.maxFrameRows {
+				if err := emit(frame); err != nil {
+					return err
+				}
+				frame = newFrame(reader.Schema(), opts.convert)
+			}
+			// Large records are copied in chunks so that the budget is
+			// checked before all of a record is copied.
+			n := record.NumRows() - offset
+			if n > budgetCheckRows {
+				n = budgetCheckRows
+			}
+			if opts.maxFrameRows > 0 && n > opts.maxFrameRows-int64(frame.Rows()) {
+				n = opts.maxFrameRows - int64(frame.Rows())
+			}
+			if n > maxRows-rows {
+				n = maxRows - rows
+			}
+			start := time.Now()
+			before := frame.Rows()
+			err := copyRecord(frame, record, offset, offset+n, opts.convert)
+			stats.convert += time.Since(start)
+			if err != nil {
+				return finish(err)
+			}
+			bytes += rowsSize(frame, before, frame.Rows())
+			stats.bytes = bytes
+			offset += n
+			rows += n
+			stats.rows = rows
+			if bytes > maxBytes {
+				return finish(fmt.Errorf("%w of %d bytes", errMemoryBudgetExceeded, maxBytes))
 			}
 		}
 
-		rows += record.NumRows()
-		if rows > rowLimit {
-			frame.AppendNotices(data.Notice{
-				Severity: data.NoticeSeverityWarning,
-				Text:     fmt.Sprintf("Results have been limited to %v because the SQL row limit was reached", rowLimit),
-			})
-			return frame, nil
+		if err := reader.Err(); err != nil && !errors.Is(err, io.EOF) {
+			return finish(err)
 		}
+	}
+	if err := reader.Err(); err != nil && !errors.Is(err, io.EOF) {
+		return finish(err)
+	}
+	return finish(nil)
+}
 
-		if err := reader.Err(); err != nil && !errors.Is(err, io.EOF) {
-			return frame, err
+// Sizes used to estimate the memory held by frame fields.
+const (
+	pointerSize      = 8
+	sliceHeaderSize  = 24
+	stringHeaderSize = 16
+	timeSize         = 24
+)
+
+// rowsSize estimates the number of bytes held by the rows [i, j) of frame.
+// The rows are measured once copied rather than as the records they were
+// read from, whose buffers may be shared with other records or hold more than
+// the rows they are sliced to.
+func rowsSize(frame *data.Frame, i, j int) int64 {
+	var size int64
+	for _, f := range frame.Fields {
+		size += fieldRowsSize(f, i, j)
+	}
+	return size
+}
+
+// fieldRowsSize estimates the number of bytes held by the rows [i, j) of
+// field. Nullable values are counted as a pointer and the value it points
+// to.
+func fieldRowsSize(field *data.Field, i, j int) int64 {
+	var size int64
+	if field.Nullable() {
+		size += int64(j-i) * pointerSize
+	}
+	switch field.Type().NonNullableType() {
+	case data.FieldTypeString:
+		for k := i; k < j; k++ {
+			if s, ok := field.ConcreteAt(k); ok {
+				size += stringHeaderSize + int64(len(s.(string)))
+			}
+		}
+		return size
+	case data.FieldTypeJSON:
+		for k := i; k < j; k++ {
+			if v, ok := field.ConcreteAt(k); ok {
+				size += sliceHeaderSize + int64(len(v.(json.RawMessage)))
+			}
 		}
+		return size
+	case data.FieldTypeInt8, data.FieldTypeUint8, data.FieldTypeBool:
+		return size + int64(j-i)
+	case data.FieldTypeInt16, data.FieldTypeUint16, data.FieldTypeEnum:
+		return size + int64(j-i)*2
+	case data.FieldTypeInt32, data.FieldTypeUint32, data.FieldTypeFloat32:
+		return size + int64(j-i)*4
+	case data.FieldTypeTime:
+		return size + int64(j-i)*timeSize
+	default:
+		return size + int64(j-i)*8
+	}
+}
+
+// copyRecord copies the rows [i, j) of record into frame.
+func copyRecord(frame *data.Frame, record arrow.Record, i, j int64, opts convertOptions) error {
+	if i != 0 || j != record.NumRows() {
+		record = record.NewSlice(i, j)
+		defer record.Release()
+	}
+	for n, col := range record.Columns() {
+		// Servers don't always mark columns that contain nulls as
+		// nullable, so fields are made nullable when nulls show up rather
+		// than having them read as zero values.
+		if col.NullN() > 0 && !frame.Fields[n].Nullable() {
+			frame.Fields[n] = nullableField(frame.Fields[n])
+		}
+		if err := copyData(frame.Fields[n], col, opts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nullableField returns a nullable copy of field.
+func nullableField(field *data.Field) *data.Field {
+	nullable := data.NewFieldFromFieldType(field.Type().NullableType(), field.Len())
+	nullable.Name = field.Name
+	nullable.Labels = field.Labels
+	nullable.Config = field.Config
+	for i := 0; i < field.Len(); i++ {
+		nullable.SetConcrete(i, field.At(i))
 	}
-	return frame, nil
+	return nullable
+}
+
+// schemaField describes a field of an [arrow.Schema].
+type schemaField struct {
+	Name     string            `json:"name"`
+	Type     string            `json:"type"`
+	Nullable bool              `json:"nullable"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// schemaFields describes the fields of an [arrow.Schema].
+func schemaFields(schema *arrow.Schema) []schemaField {
+	fields := make([]schemaField, len(schema.Fields()))
+	for i, f := range schema.Fields() {
+		fields[i] = schemaField{
+			Name:     f.Name,
+			Type:     f.Type.String(),
+			Nullable: f.Nullable,
+		}
+		if f.HasMetadata() {
+			fields[i].Metadata = make(map[string]string, f.Metadata.Len())
+			for j, k := range f.Metadata.Keys() {
+				fields[i].Metadata[k] = f.Metadata.Values()[j]
+			}
+		}
+	}
+	return fields
 }
 
 // newFrame builds a new Data Frame from an Arrow Schema.
-func newFrame(schema *arrow.Schema) *data.Frame {
+func newFrame(schema *arrow.Schema, opts convertOptions) *data.Frame {
 	fields := schema.Fields()
 	df := &data.Frame{
 		Fields: make([]*data.Field, len(fields)),
 		Meta:   &data.FrameMeta{},
 	}
 	for i, f := range fields {
-		df.Fields[i] = newField(f)
+		df.Fields[i] = newField(f, opts)
 	}
 	return df
 }
 
-func newField(f arrow.Field) *data.Field {
-	switch f.Type.ID() {
-	case arrow.STRING:
-		return newDataField[string](f)
-	case arrow.FLOAT32:
-		return newDataField[float32](f)
-	case arrow.FLOAT64:
-		return newDataField[float64](f)
-	case arrow.UINT8:
-		return newDataField[uint8](f)
-	case arrow.UINT16:
-		return newDataField[uint16](f)
-	case arrow.UINT32:
-		return newDataField[uint32](f)
-	case arrow.UINT64:
-		return newDataField[uint64](f)
-	case arrow.INT8:
-		return newDataField[int8](f)
-	case arrow.INT16:
-		return newDataField[int16](f)
-	case arrow.INT32:
-		return newDataField[int32](f)
-	case arrow.INT64:
-		return newDataField[int64](f)
-	case arrow.BOOL:
-		return newDataField[bool](f)
-	case arrow.TIMESTAMP:
-		return newDataField[time.Time](f)
-	case arrow.DURATION:
-		return newDataField[int64](f)
-	default:
-		return newDataField[json.RawMessage](f)
+// newField returns an empty frame field for an Arrow field.
+func newField(f arrow.Field, opts convertOptions) *data.Field {
+	if dt, ok := f.Type.(*arrow.DictionaryType); ok {
+		// Dictionary encoded columns are decoded to their values.
+		f.Type = dt.ValueType
+		return newField(f, opts)
 	}
+	return converterFor(f.Type).newField(f, opts)
 }
 
 func newDataField[T any](f arrow.Field) *data.Field {
@@ -166,87 +485,251 @@ func newDataField[T any](f arrow.Field) *data.Field {
 }
 
 // copyData copies the contents of an Arrow column into a Data Frame field.
-func copyData(field *data.Field, col arrow.Array) error {
+func copyData(field *data.Field, col arrow.Array, opts convertOptions) error {
 	defer func() {
 		if r := recover(); r != nil {
-			logErrorf("Panic: %s %s", r, string(debug.Stack()))
+			log.DefaultLogger.Error("Panic", "error", r, "stack", string(debug.Stack()))
 		}
 	}()
 
-	data := col.Data()
-
-	switch col.DataType().ID() {
-	case arrow.TIMESTAMP:
-		v := array.NewTimestampData(data)
-		for i := 0; i < v.Len(); i++ {
-			if field.Nullable() {
-				if v.IsNull(i) {
-					var t *time.Time
-					field.Append(t)
-					continue
-				}
-				t := v.Value(i).ToTime(arrow.Nanosecond)
-				field.Append(&t)
-				continue
-			}
-			field.Append(v.Value(i).ToTime(arrow.Nanosecond))
-		}
-	case arrow.DENSE_UNION:
-		v := array.NewDenseUnionData(data)
-		for i := 0; i < v.Len(); i++ {
-			sc, err := scalar.GetScalar(v, i)
-			if err != nil {
-				return err
-			}
-			value := sc.(*scalar.DenseUnion).ChildValue()
-
-			var data any
-			switch value.DataType().ID() {
-			case arrow.STRING:
-				data = value.(*scalar.String).String()
-			case arrow.BOOL:
-				data = value.(*scalar.Boolean).Value
-			case arrow.INT32:
-				data = value.(*scalar.Int32).Value
-			case arrow.INT64:
-				data = value.(*scalar.Int64).Value
-			case arrow.LIST:
-				data = value.(*scalar.List).Value
+	if col.DataType().ID() == arrow.DICTIONARY {
+		dict := array.NewDictionaryData(col.Data())
+		defer dict.Release()
+		return copyDictionary(field, dict, opts)
+	}
+	return converterFor(col.DataType()).copy(field, col, opts)
+}
+
+// converter converts Arrow columns of a type to Data Frame fields, much like
+// sqlutil.Converter does for database/sql columns.
+type converter struct {
+	// newField returns an empty field for an Arrow field of the type.
+	newField func(f arrow.Field, opts convertOptions) *data.Field
+	// copy appends the values of col to a field returned by newField.
+	copy func(field *data.Field, col arrow.Array, opts convertOptions) error
+}
+
+// converters holds the converter for each supported Arrow type. Support for
+// further types is added by adding converters here, which tests may also
+// override.
+var converters = map[arrow.Type]converter{
+	// Strings are cloned since Arrow's share the record's buffers, which
+	// frames would otherwise keep from being freed or reused.
+	arrow.STRING:  convertedConverter(array.NewStringData, strings.Clone),
+	arrow.BOOL:    basicConverter[bool](array.NewBooleanData),
+	arrow.UINT8:   basicConverter[uint8](array.NewUint8Data),
+	arrow.UINT16:  basicConverter[uint16](array.NewUint16Data),
+	arrow.UINT32:  basicConverter[uint32](array.NewUint32Data),
+	arrow.INT8:    basicConverter[int8](array.NewInt8Data),
+	arrow.INT16:   basicConverter[int16](array.NewInt16Data),
+	arrow.INT32:   basicConverter[int32](array.NewInt32Data),
+	arrow.INT64:   basicConverter[int64](array.NewInt64Data),
+	arrow.FLOAT32: basicConverter[float32](array.NewFloat32Data),
+	arrow.FLOAT64: basicConverter[float64](array.NewFloat64Data),
+	arrow.FLOAT16: convertedConverter(array.NewFloat16Data, float16.Num.Float32),
+	// Values beyond 2^53 can't be represented exactly by the numbers of the
+	// frontend, so they are sent as floats rather than overflowing.
+	arrow.UINT64: convertedConverter(array.NewUint64Data, func(n uint64) float64 { return float64(n) }),
+	arrow.DATE32: convertedConverter(array.NewDate32Data, arrow.Date32.ToTime),
+	arrow.DATE64: convertedConverter(array.NewDate64Data, arrow.Date64.ToTime),
+	arrow.TIMESTAMP: {
+		newField: timeField,
+		copy: func(field *data.Field, col arrow.Array, opts convertOptions) error {
+			v := array.NewTimestampData(col.Data())
+			defer v.Release()
+			copyConverted(field, v, timestampConverter(v.DataType().(*arrow.TimestampType), opts.location))
+			return nil
+		},
+	},
+	// Times of day are formatted as strings since frames have no type for
+	// them.
+	arrow.TIME32: {
+		newField: stringField,
+		copy: func(field *data.Field, col arrow.Array, _ convertOptions) error {
+			v := array.NewTime32Data(col.Data())
+			defer v.Release()
+			unit := v.DataType().(*arrow.Time32Type).Unit
+			copyConverted(field, v, func(t arrow.Time32) string { return t.FormattedString(unit) })
+			return nil
+		},
+	},
+	arrow.TIME64: {
+		newField: stringField,
+		copy: func(field *data.Field, col arrow.Array, _ convertOptions) error {
+			v := array.NewTime64Data(col.Data())
+			defer v.Release()
+			unit := v.DataType().(*arrow.Time64Type).Unit
+			copyConverted(field, v, func(t arrow.Time64) string { return t.FormattedString(unit) })
+			return nil
+		},
+	},
+	arrow.DURATION: {
+		newField: func(f arrow.Field, _ convertOptions) *data.Field {
+			field := newDataField[int64](f)
+			field.Config = &data.FieldConfig{Unit: durationUnits[f.Type.(*arrow.DurationType).Unit]}
+			return field
+		},
+		copy: convertedConverter(array.NewDurationData, func(d arrow.Duration) int64 { return int64(d) }).copy,
+	},
+	arrow.INTERVAL_MONTHS: convertedConverter(array.NewMonthIntervalData, func(m arrow.MonthInterval) int32 { return int32(m) }),
+	arrow.INTERVAL_DAY_TIME: withUnit("ms", convertedConverter(array.NewDayTimeIntervalData, func(i arrow.DayTimeInterval) int64 {
+		return int64(i.Days)*24*time.Hour.Milliseconds() + int64(i.Milliseconds)
+	})),
+	arrow.INTERVAL_MONTH_DAY_NANO: withUnit("ns", convertedConverter(array.NewMonthDayNanoIntervalData, func(i arrow.MonthDayNanoInterval) int64 {
+		days := int64(i.Months)*daysPerMonth + int64(i.Days)
+		return days*24*time.Hour.Nanoseconds() + i.Nanoseconds
+	})),
+	arrow.BINARY:            binaryConverter(array.NewBinaryData),
+	arrow.LARGE_BINARY:      binaryConverter(array.NewLargeBinaryData),
+	arrow.FIXED_SIZE_BINARY: binaryConverter(array.NewFixedSizeBinaryData),
+	arrow.DECIMAL128: {
+		newField: decimalField,
+		copy: func(field *data.Field, col arrow.Array, _ convertOptions) error {
+			v := array.NewDecimal128Data(col.Data())
+			defer v.Release()
+			scale := v.DataType().(*arrow.Decimal128Type).Scale
+			if isStringField(field) {
+				copyConverted(field, v, func(n decimal128.Num) string { return n.ToString(scale) })
+			} else {
+				copyConverted(field, v, func(n decimal128.Num) float64 { return n.ToFloat64(scale) })
 			}
-			b, err := json.Marshal(data)
-			if err != nil {
-				return err
+			return nil
+		},
+	},
+	arrow.DECIMAL256: {
+		newField: decimalField,
+		copy: func(field *data.Field, col arrow.Array, _ convertOptions) error {
+			v := array.NewDecimal256Data(col.Data())
+			defer v.Release()
+			scale := v.DataType().(*arrow.Decimal256Type).Scale
+			if isStringField(field) {
+				copyConverted(field, v, func(n decimal256.Num) string { return n.ToString(scale) })
+			} else {
+				copyConverted(field, v, func(n decimal256.Num) float64 { return n.ToFloat64(scale) })
 			}
-			field.Append(json.RawMessage(b))
-		}
-	case arrow.STRING:
-		copyBasic[string](field, array.NewStringData(data))
-	case arrow.UINT8:
-		copyBasic[uint8](field, array.NewUint8Data(data))
-	case arrow.UINT16:
-		copyBasic[uint16](field, array.NewUint16Data(data))
-	case arrow.UINT32:
-		copyBasic[uint32](field, array.NewUint32Data(data))
-	case arrow.UINT64:
-		copyBasic[uint64](field, array.NewUint64Data(data))
-	case arrow.INT8:
-		copyBasic[int8](field, array.NewInt8Data(data))
-	case arrow.INT16:
-		copyBasic[int16](field, array.NewInt16Data(data))
-	case arrow.INT32:
-		copyBasic[int32](field, array.NewInt32Data(data))
-	case arrow.INT64:
-		copyBasic[int64](field, array.NewInt64Data(data))
-	case arrow.FLOAT32:
-		copyBasic[float32](field, array.NewFloat32Data(data))
-	case arrow.FLOAT64:
-		copyBasic[float64](field, array.NewFloat64Data(data))
-	case arrow.BOOL:
-		copyBasic[bool](field, array.NewBooleanData(data))
-	case arrow.DURATION:
-		copyBasic[int64](field, array.NewInt64Data(data))
+			return nil
+		},
+	},
+	arrow.LIST:            jsonConverter,
+	arrow.LARGE_LIST:      jsonConverter,
+	arrow.FIXED_SIZE_LIST: jsonConverter,
+	arrow.STRUCT:          jsonConverter,
+	arrow.MAP:             jsonConverter,
+	arrow.DENSE_UNION:     {newField: jsonField, copy: copyDenseUnion},
+}
+
+// fallbackConverter converts columns of types without a converter to
+// strings, so that unsupported types are still displayed rather than failing
+// the query.
+var fallbackConverter = converter{newField: stringField, copy: copyStrings}
+
+// converterFor returns the converter for columns of type dt.
+func converterFor(dt arrow.DataType) converter {
+	if c, ok := converters[dt.ID()]; ok {
+		return c
+	}
+	return fallbackConverter
+}
+
+// basicConverter returns a converter that copies values as they are.
+func basicConverter[T any, Array arrowArray[T]](newArray func(arrow.ArrayData) Array) converter {
+	return convertedConverter(newArray, func(v T) T { return v })
+}
+
+// convertedConverter returns a converter that copies values converted with
+// convert.
+func convertedConverter[T, V any, Array arrowArray[V]](newArray func(arrow.ArrayData) Array, convert func(V) T) converter {
+	return converter{
+		newField: func(f arrow.Field, _ convertOptions) *data.Field { return newDataField[T](f) },
+		copy: func(field *data.Field, col arrow.Array, _ convertOptions) error {
+			v := newArray(col.Data())
+			defer v.Release()
+			copyConverted(field, v, convert)
+			return nil
+		},
+	}
+}
+
+// binaryConverter returns a converter that encodes binary values as strings.
+func binaryConverter[Array arrowArray[[]byte]](newArray func(arrow.ArrayData) Array) converter {
+	return converter{
+		newField: stringField,
+		copy: func(field *data.Field, col arrow.Array, opts convertOptions) error {
+			v := newArray(col.Data())
+			defer v.Release()
+			copyConverted(field, v, binaryEncoder(opts))
+			return nil
+		},
+	}
+}
+
+// withUnit returns c with the fields it creates displayed in unit.
+func withUnit(unit string, c converter) converter {
+	newField := c.newField
+	c.newField = func(f arrow.Field, opts convertOptions) *data.Field {
+		field := newField(f, opts)
+		field.Config = &data.FieldConfig{Unit: unit}
+		return field
+	}
+	return c
+}
+
+// jsonConverter converts nested values to JSON.
+var jsonConverter = converter{
+	newField: jsonField,
+	copy: func(field *data.Field, col arrow.Array, _ convertOptions) error {
+		return copyJSON(field, col)
+	},
+}
+
+func stringField(f arrow.Field, _ convertOptions) *data.Field { return newDataField[string](f) }
+
+func timeField(f arrow.Field, _ convertOptions) *data.Field { return newDataField[time.Time](f) }
+
+func jsonField(f arrow.Field, _ convertOptions) *data.Field { return newDataField[json.RawMessage](f) }
+
+func decimalField(f arrow.Field, opts convertOptions) *data.Field {
+	if opts.decimalAsString {
+		return newDataField[string](f)
 	}
+	return newDataField[float64](f)
+}
 
+// copyDenseUnion copies the values of a dense union column to dst as JSON.
+func copyDenseUnion(dst *data.Field, col arrow.Array, _ convertOptions) error {
+	v := array.NewDenseUnionData(col.Data())
+	defer v.Release()
+	for i := 0; i < v.Len(); i++ {
+		sc, err := scalar.GetScalar(v, i)
+		if err != nil {
+			return err
+		}
+		value := sc.(*scalar.DenseUnion).ChildValue()
+
+		var data any
+		switch value.DataType().ID() {
+		case arrow.STRING:
+			data = value.(*scalar.String).String()
+		case arrow.BOOL:
+			data = value.(*scalar.Boolean).Value
+		case arrow.INT32:
+			data = value.(*scalar.Int32).Value
+		case arrow.INT64:
+			data = value.(*scalar.Int64).Value
+		case arrow.LIST:
+			data = value.(*scalar.List).Value
+		}
+		b, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		raw := json.RawMessage(b)
+		if dst.Nullable() {
+			dst.Append(&raw)
+			continue
+		}
+		dst.Append(raw)
+	}
 	return nil
 }
 
@@ -254,20 +737,153 @@ type arrowArray[T any] interface {
 	IsNull(int) bool
 	Value(int) T
 	Len() int
+	Release()
 }
 
-func copyBasic[T any, Array arrowArray[T]](dst *data.Field, src Array) {
+// copyDictionary copies the decoded values of a dictionary encoded column to
+// dst.
+func copyDictionary(dst *data.Field, src *array.Dictionary, opts convertOptions) error {
+	values := data.NewFieldFromFieldType(dst.Type(), 0)
+	if err := copyData(values, src.Dictionary(), opts); err != nil {
+		return err
+	}
 	for i := 0; i < src.Len(); i++ {
+		if src.IsNull(i) && dst.Nullable() {
+			dst.Append(nil)
+			continue
+		}
+		dst.Append(values.At(src.GetValueIndex(i)))
+	}
+	return nil
+}
+
+// copyJSON copies the values of a nested column to dst as JSON.
+func copyJSON(dst *data.Field, src arrow.Array) error {
+	b, err := src.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	var values []json.RawMessage
+	if err := json.Unmarshal(b, &values); err != nil {
+		return err
+	}
+	for i := range values {
 		if dst.Nullable() {
 			if src.IsNull(i) {
-				var s *T
+				var s *json.RawMessage
 				dst.Append(s)
 				continue
 			}
-			s := src.Value(i)
-			dst.Append(&s)
+			dst.Append(&values[i])
 			continue
 		}
-		dst.Append(src.Value(i))
+		dst.Append(values[i])
+	}
+	return nil
+}
+
+// copyStrings copies the values of a column to dst as strings, using the
+// representation of the values in the column's JSON encoding.
+func copyStrings(dst *data.Field, src arrow.Array, _ convertOptions) error {
+	b, err := src.MarshalJSON()
+	if err != nil {
+		return err
+	}
+	var values []json.RawMessage
+	if err := json.Unmarshal(b, &values); err != nil {
+		return err
 	}
+	strs := make([]string, len(values))
+	for i, v := range values {
+		if json.Unmarshal(v, &strs[i]) != nil {
+			strs[i] = string(v)
+		}
+		if dst.Nullable() {
+			if src.IsNull(i) {
+				var s *string
+				dst.Append(s)
+				continue
+			}
+			dst.Append(&strs[i])
+			continue
+		}
+		dst.Append(strs[i])
+	}
+	return nil
+}
+
+// durationUnits maps the units of Arrow durations to Grafana units.
+var durationUnits = map[arrow.TimeUnit]string{
+	arrow.Second:      "s",
+	arrow.Millisecond: "ms",
+	arrow.Microsecond: "µs",
+	arrow.Nanosecond:  "ns",
+}
+
+// daysPerMonth approximates the length of a month when converting intervals
+// with months to a fixed duration.
+const daysPerMonth = 30
+
+// binaryEncoder returns the function that encodes binary values as strings.
+func binaryEncoder(opts convertOptions) func([]byte) string {
+	if opts.binaryEncoding == "hex" {
+		return hex.EncodeToString
+	}
+	return base64.StdEncoding.EncodeToString
+}
+
+// timestampConverter returns a function that converts timestamps of type dt
+// to times in dt's unit and time zone. Timestamps without a time zone are
+// wall clock times in loc, or UTC if loc is nil. Timestamps with a time zone
+// that can't be loaded are treated as UTC.
+func timestampConverter(dt *arrow.TimestampType, loc *time.Location) func(arrow.Timestamp) time.Time {
+	if dt.TimeZone == "" && loc != nil && loc != time.UTC {
+		return func(ts arrow.Timestamp) time.Time {
+			t := ts.ToTime(dt.Unit)
+			return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+		}
+	}
+	loc = time.UTC
+	if dt.TimeZone != "" {
+		if l, err := time.LoadLocation(dt.TimeZone); err == nil {
+			loc = l
+		} else if l, err := time.Parse("-07:00", dt.TimeZone); err == nil {
+			loc = l.Location()
+		} else {
+			log.DefaultLogger.Warn("Unknown time zone, using UTC", "timeZone", dt.TimeZone, "error", err)
+		}
+	}
+	return func(ts arrow.Timestamp) time.Time {
+		return ts.ToTime(dt.Unit).In(loc)
+	}
+}
+
+// copyConverted copies src to dst, converting each value with convert.
+//
+// dst is extended by the length of src up front and the values are written
+// in place, which avoids boxing each value as it would be to append it. The
+// non-null values of nullable fields share a single allocation.
+func copyConverted[T, V any, Array arrowArray[V]](dst *data.Field, src Array, convert func(V) T) {
+	start, n := dst.Len(), src.Len()
+	dst.Extend(n)
+	if !dst.Nullable() {
+		for i := 0; i < n; i++ {
+			*dst.PointerAt(start + i).(*T) = convert(src.Value(i))
+		}
+		return
+	}
+	values := make([]T, n)
+	for i := 0; i < n; i++ {
+		if src.IsNull(i) {
+			continue
+		}
+		values[i] = convert(src.Value(i))
+		*dst.PointerAt(start + i).(**T) = &values[i]
+	}
+}
+
+// isStringField reports whether field holds strings.
+func isStringField(field *data.Field) bool {
+	t := field.Type()
+	return t == data.FieldTypeString || t == data.FieldTypeNullableString
 }
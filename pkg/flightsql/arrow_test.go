@@ -1,14 +1,23 @@
 package flightsql
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"log"
+	"math"
+	"strconv"
 	"strings"
 	"testing"
 	"time"
 
 	"github.com/apache/arrow/go/v12/arrow"
 	"github.com/apache/arrow/go/v12/arrow/array"
+	"github.com/apache/arrow/go/v12/arrow/decimal128"
+	"github.com/apache/arrow/go/v12/arrow/decimal256"
+	"github.com/apache/arrow/go/v12/arrow/flight"
+	"github.com/apache/arrow/go/v12/arrow/float16"
+	"github.com/apache/arrow/go/v12/arrow/ipc"
 	"github.com/apache/arrow/go/v12/arrow/memory"
 	"github.com/google/go-cmp/cmp"
 	"github.com/grafana/grafana-plugin-sdk-go/data"
@@ -37,7 +46,7 @@ func TestNewQueryDataResponse(t *testing.T) {
 
 			{Name: "utf8", Type: &arrow.StringType{}},
 			{Name: "duration", Type: &arrow.DurationType{}},
-			{Name: "timestamp", Type: &arrow.TimestampType{}},
+			{Name: "timestamp", Type: &arrow.TimestampType{Unit: arrow.Nanosecond}},
 		},
 		nil,
 	)
@@ -58,7 +67,7 @@ func TestNewQueryDataResponse(t *testing.T) {
 
 		newJSONArray(`["foo", "bar", "baz"]`, &arrow.StringType{}),
 		newJSONArray(`[0, 1, -2]`, &arrow.DurationType{}),
-		newJSONArray(`[0, 1, 2]`, &arrow.TimestampType{}),
+		newJSONArray(`[0, 1, 2]`, &arrow.TimestampType{Unit: arrow.Nanosecond}),
 	}
 
 	var arr []arrow.Array
@@ -79,8 +88,8 @@ func TestNewQueryDataResponse(t *testing.T) {
 	reader, err := array.NewRecordReader(schema, records)
 	require.NoError(t, err)
 
-	query := sqlutil.Query{Format: sqlutil.FormatOptionTable}
-	resp := newQueryDataResponse(errReader{RecordReader: reader}, query, metadata.MD{})
+	query := sqlQuery{Query: sqlutil.Query{Format: sqlutil.FormatOptionTable}}
+	resp, _ := newQueryDataResponse(errReader{RecordReader: reader}, query, metadata.MD{})
 	require.NoError(t, resp.Error)
 	require.Len(t, resp.Frames, 1)
 	require.Len(t, resp.Frames[0].Fields, 13)
@@ -123,8 +132,8 @@ func TestNewQueryDataResponse(t *testing.T) {
 
 	f7 := frame.Fields[7]
 	assert.Equal(t, f7.Name, "u64")
-	assert.Equal(t, f7.Type(), data.FieldTypeUint64)
-	assert.Equal(t, []uint64{1, 2, 3}, extractFieldValues[uint64](t, f7))
+	assert.Equal(t, f7.Type(), data.FieldTypeFloat64)
+	assert.Equal(t, []float64{1, 2, 3}, extractFieldValues[float64](t, f7))
 
 	f8 := frame.Fields[8]
 	assert.Equal(t, f8.Name, "f32")
@@ -200,9 +209,22 @@ func TestNewQueryDataResponse_Error(t *testing.T) {
 		RecordReader: reader,
 		err:          fmt.Errorf("explosion!"),
 	}
-	query := sqlutil.Query{Format: sqlutil.FormatOptionTable}
-	resp := newQueryDataResponse(wrappedReader, query, metadata.MD{})
-	require.Error(t, resp.Error)
+	query := sqlQuery{Query: sqlutil.Query{Format: sqlutil.FormatOptionTable}}
+	resp, _ := newQueryDataResponse(wrappedReader, query, metadata.MD{})
+	require.NoError(t, resp.Error)
+	require.Len(t, resp.Frames, 1)
+	require.Equal(t, 3, resp.Frames[0].Rows())
+	require.Len(t, resp.Frames[0].Meta.Notices, 1)
+	require.Equal(t, data.NoticeSeverityWarning, resp.Frames[0].Meta.Notices[0].Severity)
+	require.Contains(t, resp.Frames[0].Meta.Notices[0].Text, "explosion!")
+
+	empty, err := array.NewRecordReader(schema, nil)
+	require.NoError(t, err)
+	wrappedReader = errReader{
+		RecordReader: empty,
+		err:          fmt.Errorf("explosion!"),
+	}
+	resp, _ = newQueryDataResponse(wrappedReader, query, metadata.MD{})
 	require.Equal(t, fmt.Errorf("explosion!"), resp.Error)
 }
 
@@ -210,7 +232,7 @@ func TestNewQueryDataResponse_WideTable(t *testing.T) {
 	alloc := memory.DefaultAllocator
 	schema := arrow.NewSchema(
 		[]arrow.Field{
-			{Name: "time", Type: &arrow.TimestampType{}},
+			{Name: "time", Type: &arrow.TimestampType{Unit: arrow.Nanosecond}},
 			{Name: "label", Type: &arrow.StringType{}},
 			{Name: "value", Type: arrow.PrimitiveTypes.Int64},
 		},
@@ -219,7 +241,7 @@ func TestNewQueryDataResponse_WideTable(t *testing.T) {
 
 	times, _, err := array.FromJSON(
 		alloc,
-		&arrow.TimestampType{},
+		&arrow.TimestampType{Unit: arrow.Nanosecond},
 		strings.NewReader(`["2023-01-01T00:00:00Z", "2023-01-01T00:00:01Z", "2023-01-01T00:00:02Z"]`),
 	)
 	require.NoError(t, err)
@@ -241,7 +263,7 @@ func TestNewQueryDataResponse_WideTable(t *testing.T) {
 	reader, err := array.NewRecordReader(schema, records)
 	require.NoError(t, err)
 
-	resp := newQueryDataResponse(errReader{RecordReader: reader}, sqlutil.Query{}, metadata.MD{})
+	resp, _ := newQueryDataResponse(errReader{RecordReader: reader}, sqlQuery{}, metadata.MD{})
 	require.NoError(t, resp.Error)
 	require.Len(t, resp.Frames, 1)
 	require.Equal(t, 3, resp.Frames[0].Rows())
@@ -266,6 +288,175 @@ func TestNewQueryDataResponse_WideTable(t *testing.T) {
 	assert.Equal(t, []int64{1, 0, 0}, extractFieldValues[int64](t, frame.Fields[3]))
 }
 
+func TestNewQueryDataResponse_MaxFrameRows(t *testing.T) {
+	schema := arrow.NewSchema(
+		[]arrow.Field{
+			{Name: "i64", Type: arrow.PrimitiveTypes.Int64},
+		},
+		nil,
+	)
+
+	var records []arrow.Record
+	for _, v := range []string{`[1, 2, 3]`, `[4, 5, 6]`} {
+		i64s, _, err := array.FromJSON(
+			memory.DefaultAllocator,
+			arrow.PrimitiveTypes.Int64,
+			strings.NewReader(v),
+		)
+		require.NoError(t, err)
+		records = append(records, array.NewRecord(schema, []arrow.Array{i64s}, -1))
+	}
+	reader, err := array.NewRecordReader(schema, records)
+	require.NoError(t, err)
+
+	query := sqlQuery{
+		Query:        sqlutil.Query{Format: sqlutil.FormatOptionTable},
+		MaxFrameRows: 4,
+	}
+	resp, _ := newQueryDataResponse(errReader{RecordReader: reader}, query, metadata.MD{})
+	require.NoError(t, resp.Error)
+	require.Len(t, resp.Frames, 2)
+	assert.Equal(t, []int64{1, 2, 3, 4}, extractFieldValues[int64](t, resp.Frames[0].Fields[0]))
+	assert.Equal(t, []int64{5, 6}, extractFieldValues[int64](t, resp.Frames[1].Fields[0]))
+}
+
+func TestNewQueryDataResponse_MaxRows(t *testing.T) {
+	schema := arrow.NewSchema(
+		[]arrow.Field{
+			{Name: "i64", Type: arrow.PrimitiveTypes.Int64},
+		},
+		nil,
+	)
+	i64s, _, err := array.FromJSON(
+		memory.DefaultAllocator,
+		arrow.PrimitiveTypes.Int64,
+		strings.NewReader(`[1, 2, 3, 4, 5]`),
+	)
+	require.NoError(t, err)
+	record := array.NewRecord(schema, []arrow.Array{i64s}, -1)
+	reader, err := array.NewRecordReader(schema, []arrow.Record{record, record})
+	require.NoError(t, err)
+
+	query := sqlQuery{
+		Query:   sqlutil.Query{Format: sqlutil.FormatOptionTable},
+		MaxRows: 3,
+	}
+	resp, _ := newQueryDataResponse(errReader{RecordReader: reader}, query, metadata.MD{})
+	require.NoError(t, resp.Error)
+	require.Len(t, resp.Frames, 1)
+	assert.Equal(t, []int64{1, 2, 3}, extractFieldValues[int64](t, resp.Frames[0].Fields[0]))
+	require.Len(t, resp.Frames[0].Meta.Notices, 1)
+	assert.Equal(t, "Results have been truncated at 3 rows because the row limit was reached", resp.Frames[0].Meta.Notices[0].Text)
+}
+
+func TestNewQueryDataResponse_MaxBytes(t *testing.T) {
+	schema := arrow.NewSchema(
+		[]arrow.Field{
+			{Name: "i64", Type: arrow.PrimitiveTypes.Int64},
+		},
+		nil,
+	)
+	i64s, _, err := array.FromJSON(
+		memory.DefaultAllocator,
+		arrow.PrimitiveTypes.Int64,
+		strings.NewReader(`[1, 2, 3, 4, 5]`),
+	)
+	require.NoError(t, err)
+	record := array.NewRecord(schema, []arrow.Array{i64s}, -1)
+	reader, err := array.NewRecordReader(schema, []arrow.Record{record, record, record})
+	require.NoError(t, err)
+
+	// Each record adds five int64s to the frame, so reading stops after the
+	// second.
+	query := sqlQuery{
+		Query:    sqlutil.Query{Format: sqlutil.FormatOptionTable},
+		MaxBytes: 5*8 + 1,
+	}
+	resp, stats := newQueryDataResponse(errReader{RecordReader: reader}, query, metadata.MD{})
+	require.ErrorContains(t, resp.Error, "memory budget")
+	require.Len(t, resp.Frames, 1)
+	assert.Equal(t, 10, resp.Frames[0].Rows())
+	assert.Equal(t, int64(80), stats.bytes)
+}
+
+func TestNewQueryDataResponse_MaxBytesRecord(t *testing.T) {
+	schema := arrow.NewSchema(
+		[]arrow.Field{
+			{Name: "i64", Type: arrow.PrimitiveTypes.Int64},
+		},
+		nil,
+	)
+	b := array.NewInt64Builder(memory.DefaultAllocator)
+	defer b.Release()
+	for i := 0; i < 10*budgetCheckRows; i++ {
+		b.Append(int64(i))
+	}
+	i64s := b.NewArray()
+	record := array.NewRecord(schema, []arrow.Array{i64s}, -1)
+	reader, err := array.NewRecordReader(schema, []arrow.Record{record})
+	require.NoError(t, err)
+
+	// The record alone exceeds the budget, so reading stops part way
+	// through it.
+	query := sqlQuery{
+		Query:    sqlutil.Query{Format: sqlutil.FormatOptionTable},
+		MaxBytes: budgetCheckRows*8 + 1,
+	}
+	resp, stats := newQueryDataResponse(errReader{RecordReader: reader}, query, metadata.MD{})
+	require.ErrorContains(t, resp.Error, "memory budget")
+	require.Len(t, resp.Frames, 1)
+	assert.Equal(t, 2*budgetCheckRows, resp.Frames[0].Rows())
+	assert.Equal(t, int64(2*budgetCheckRows*8), stats.bytes)
+}
+
+func TestRowsSize(t *testing.T) {
+	frame := data.NewFrame("",
+		data.NewField("i64", nil, []int64{1, 2}),
+		data.NewField("s", nil, []*string{ptr("abc"), nil}),
+		data.NewField("t", nil, []time.Time{{}, {}}),
+	)
+	// 2 int64s, 2 pointers and a string header with 3 bytes, and 2 times.
+	assert.Equal(t, int64(2*8+2*8+16+3+2*24), rowsSize(frame, 0, 2))
+	assert.Equal(t, int64(8+8+16+3+24), rowsSize(frame, 0, 1))
+}
+
+func TestNewQueryDataResponse_ReleasesRecords(t *testing.T) {
+	schema := arrow.NewSchema(
+		[]arrow.Field{
+			{Name: "i64", Type: arrow.PrimitiveTypes.Int64},
+			{Name: "str", Type: arrow.BinaryTypes.String},
+		},
+		nil,
+	)
+	var buf bytes.Buffer
+	w := ipc.NewWriter(&buf, ipc.WithSchema(schema))
+	for i := 0; i < 3; i++ {
+		b := array.NewRecordBuilder(memory.DefaultAllocator, schema)
+		for j := 0; j < 1000; j++ {
+			b.Field(0).(*array.Int64Builder).Append(int64(i*1000 + j))
+			b.Field(1).(*array.StringBuilder).Append(strconv.Itoa(i*1000 + j))
+		}
+		record := b.NewRecord()
+		require.NoError(t, w.Write(record))
+		record.Release()
+		b.Release()
+	}
+	require.NoError(t, w.Close())
+
+	mem := memory.NewCheckedAllocator(newPoolAllocator())
+	reader, err := ipc.NewReader(&buf, ipc.WithAllocator(mem))
+	require.NoError(t, err)
+	query := sqlQuery{Query: sqlutil.Query{Format: sqlutil.FormatOptionTable}}
+	resp, _ := newQueryDataResponse(reader, query, metadata.MD{})
+	reader.Release()
+	require.NoError(t, resp.Error)
+
+	// Every buffer read has been freed, and the frames are intact.
+	mem.AssertSize(t, 0)
+	require.Equal(t, 3000, resp.Frames[0].Rows())
+	require.Equal(t, "2999", resp.Frames[0].Fields[1].At(2999))
+}
+
 func extractFieldValues[T any](t *testing.T, field *data.Field) []T {
 	t.Helper()
 
@@ -285,6 +476,93 @@ func (r errReader) Err() error {
 	return r.err
 }
 
+func TestNewQueryDataResponse_UndeclaredNulls(t *testing.T) {
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "value", Type: arrow.PrimitiveTypes.Int64},
+	}, nil)
+	first := array.NewRecord(schema, []arrow.Array{arrayFromJSON(t, arrow.PrimitiveTypes.Int64, `[1, 2]`)}, 2)
+	second := array.NewRecord(schema, []arrow.Array{arrayFromJSON(t, arrow.PrimitiveTypes.Int64, `[null, 4]`)}, 2)
+	reader, err := array.NewRecordReader(schema, []arrow.Record{first, second})
+	require.NoError(t, err)
+
+	resp, _ := newQueryDataResponse(errReader{RecordReader: reader}, sqlQuery{
+		Query: sqlutil.Query{Format: sqlutil.FormatOptionTable},
+	}, metadata.MD{})
+	require.NoError(t, resp.Error)
+	field := resp.Frames[0].Fields[0]
+	require.True(t, field.Nullable())
+	require.Equal(t, "value", field.Name)
+	require.Equal(t, []*int64{ptr(int64(1)), ptr(int64(2)), nil, ptr(int64(4))}, extractFieldValues[*int64](t, field))
+}
+
+func TestSchemaResponse(t *testing.T) {
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "time", Type: &arrow.TimestampType{Unit: arrow.Second}},
+		{Name: "value", Type: arrow.PrimitiveTypes.Int64, Nullable: true},
+	}, nil)
+	query := sqlQuery{Query: sqlutil.Query{RawSQL: "select * from empty"}}
+
+	resp := schemaResponse(flight.SerializeSchema(schema, memory.DefaultAllocator), query)
+	require.NoError(t, resp.Error)
+	require.Len(t, resp.Frames, 1)
+	frame := resp.Frames[0]
+	require.Equal(t, 0, frame.Rows())
+	require.Len(t, frame.Fields, 2)
+	require.Equal(t, "time", frame.Fields[0].Name)
+	require.Equal(t, data.FieldTypeTime, frame.Fields[0].Type())
+	require.Equal(t, data.FieldTypeNullableInt64, frame.Fields[1].Type())
+	require.Equal(t, "select * from empty", frame.Meta.ExecutedQueryString)
+	require.Equal(t, map[string]any{"schema": []schemaField{
+		{Name: "time", Type: "timestamp[s]"},
+		{Name: "value", Type: "int64", Nullable: true},
+	}}, frame.Meta.Custom)
+
+	// Statements such as SET may not have a schema at all.
+	resp = schemaResponse(nil, query)
+	require.NoError(t, resp.Error)
+	require.Len(t, resp.Frames[0].Fields, 0)
+
+	resp = schemaResponse([]byte("invalid"), query)
+	require.Error(t, resp.Error)
+}
+
+func TestNewQueryDataResponse_Format(t *testing.T) {
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "time", Type: &arrow.TimestampType{Unit: arrow.Second}},
+		{Name: "value", Type: arrow.PrimitiveTypes.Int64},
+		{Name: "message", Type: arrow.BinaryTypes.String},
+	}, nil)
+	cs := []struct {
+		format    sqlutil.FormatQueryOption
+		frameType data.FrameType
+		vis       data.VisType
+	}{
+		{sqlutil.FormatOptionTimeSeries, data.FrameTypeTimeSeriesWide, data.VisTypeGraph},
+		{sqlutil.FormatOptionTable, data.FrameTypeTable, data.VisTypeTable},
+		{sqlutil.FormatOptionLogs, data.FrameTypeLogLines, data.VisTypeLogs},
+	}
+	for _, c := range cs {
+		record := array.NewRecord(schema, []arrow.Array{
+			arrayFromJSON(t, schema.Field(0).Type, `[1, 2]`),
+			arrayFromJSON(t, schema.Field(1).Type, `[3, 4]`),
+			arrayFromJSON(t, schema.Field(2).Type, `["a", "b"]`),
+		}, 2)
+		reader, err := array.NewRecordReader(schema, []arrow.Record{record})
+		require.NoError(t, err)
+
+		resp, _ := newQueryDataResponse(errReader{RecordReader: reader}, sqlQuery{
+			Query: sqlutil.Query{Format: c.format},
+		}, metadata.MD{})
+		require.NoError(t, resp.Error)
+		require.Equal(t, c.frameType, resp.Frames[0].Meta.Type)
+		require.Equal(t, c.vis, resp.Frames[0].Meta.PreferredVisualization)
+	}
+}
+
+func ptr[T any](v T) *T {
+	return &v
+}
+
 func TestNewFrame(t *testing.T) {
 	schema := arrow.NewSchema([]arrow.Field{
 		{
@@ -295,7 +573,7 @@ func TestNewFrame(t *testing.T) {
 		},
 		{
 			Name:     "time",
-			Type:     &arrow.TimestampType{},
+			Type:     &arrow.TimestampType{Unit: arrow.Nanosecond},
 			Nullable: false,
 			Metadata: arrow.NewMetadata(nil, nil),
 		},
@@ -307,7 +585,7 @@ func TestNewFrame(t *testing.T) {
 		},
 	}, nil)
 
-	actual := newFrame(schema)
+	actual := newFrame(schema, convertOptions{})
 	expected := &data.Frame{
 		Fields: []*data.Field{
 			data.NewField("name", nil, []string{}),
@@ -341,7 +619,7 @@ func TestCopyData_String(t *testing.T) {
 	builder.Append("joe")
 	builder.Append("john")
 	builder.Append("jackie")
-	copyData(field, builder.NewArray())
+	copyData(field, builder.NewArray(), convertOptions{})
 	require.Equal(t, "joe", field.CopyAt(0))
 	require.Equal(t, "john", field.CopyAt(1))
 	require.Equal(t, "jackie", field.CopyAt(2))
@@ -351,43 +629,196 @@ func TestCopyData_String(t *testing.T) {
 	builder.Append("joe")
 	builder.AppendNull()
 	builder.Append("jackie")
-	copyData(field, builder.NewArray())
+	copyData(field, builder.NewArray(), convertOptions{})
 	require.Equal(t, "joe", *(field.CopyAt(0).(*string)))
 	require.Equal(t, (*string)(nil), field.CopyAt(1))
 	require.Equal(t, "jackie", *(field.CopyAt(2).(*string)))
 }
 
+func TestCopyData_StringClone(t *testing.T) {
+	field := data.NewField("field", nil, []string{})
+	builder := array.NewStringBuilder(memory.DefaultAllocator)
+	builder.Append("joe")
+	arr := builder.NewStringArray()
+	require.NoError(t, copyData(field, arr, convertOptions{}))
+
+	// Reusing the array's buffers doesn't change the copied strings.
+	copy(arr.ValueBytes(), "bob")
+	require.Equal(t, "bob", arr.Value(0))
+	require.Equal(t, "joe", field.At(0))
+}
+
 func TestCopyData_Timestamp(t *testing.T) {
 	start, _ := time.Parse(time.RFC3339, "2023-01-01T01:01:01Z")
 
 	field := data.NewField("field", nil, []time.Time{})
-	builder := array.NewTimestampBuilder(memory.DefaultAllocator, &arrow.TimestampType{})
+	builder := array.NewTimestampBuilder(memory.DefaultAllocator, &arrow.TimestampType{Unit: arrow.Nanosecond})
 	builder.Append(arrow.Timestamp(start.Add(time.Hour).UnixNano()))
 	builder.Append(arrow.Timestamp(start.Add(2 * time.Hour).UnixNano()))
 	builder.Append(arrow.Timestamp(start.Add(3 * time.Hour).UnixNano()))
-	copyData(field, builder.NewArray())
+	copyData(field, builder.NewArray(), convertOptions{})
 	require.Equal(t, start.Add(time.Hour), field.CopyAt(0))
 	require.Equal(t, start.Add(2*time.Hour), field.CopyAt(1))
 	require.Equal(t, start.Add(3*time.Hour), field.CopyAt(2))
 
 	field = data.NewField("field", nil, []*time.Time{})
-	builder = array.NewTimestampBuilder(memory.DefaultAllocator, &arrow.TimestampType{})
+	builder = array.NewTimestampBuilder(memory.DefaultAllocator, &arrow.TimestampType{Unit: arrow.Nanosecond})
 	builder.Append(arrow.Timestamp(start.Add(time.Hour).UnixNano()))
 	builder.AppendNull()
 	builder.Append(arrow.Timestamp(start.Add(3 * time.Hour).UnixNano()))
-	copyData(field, builder.NewArray())
+	copyData(field, builder.NewArray(), convertOptions{})
 	require.Equal(t, start.Add(time.Hour), *field.CopyAt(0).(*time.Time))
 	require.Equal(t, (*time.Time)(nil), field.CopyAt(1))
 	require.Equal(t, start.Add(3*time.Hour), *field.CopyAt(2).(*time.Time))
 }
 
+func TestCopyData_TimestampUnits(t *testing.T) {
+	ts := time.Date(2023, 1, 1, 1, 1, 1, 0, time.UTC)
+	cs := []struct {
+		unit  arrow.TimeUnit
+		value int64
+	}{
+		{arrow.Second, ts.Unix()},
+		{arrow.Millisecond, ts.UnixMilli()},
+		{arrow.Microsecond, ts.UnixMicro()},
+		{arrow.Nanosecond, ts.UnixNano()},
+	}
+	for _, c := range cs {
+		t.Run(c.unit.String(), func(t *testing.T) {
+			field := data.NewField("field", nil, []time.Time{})
+			builder := array.NewTimestampBuilder(memory.DefaultAllocator, &arrow.TimestampType{Unit: c.unit})
+			builder.Append(arrow.Timestamp(c.value))
+			require.NoError(t, copyData(field, builder.NewArray(), convertOptions{}))
+			require.Equal(t, ts, field.CopyAt(0))
+		})
+	}
+}
+
+func TestCopyData_TimestampTimeZone(t *testing.T) {
+	ts := time.Date(2023, 1, 1, 1, 1, 1, 0, time.UTC)
+	for _, tz := range []string{"America/New_York", "+05:30"} {
+		t.Run(tz, func(t *testing.T) {
+			field := data.NewField("field", nil, []time.Time{})
+			builder := array.NewTimestampBuilder(memory.DefaultAllocator, &arrow.TimestampType{Unit: arrow.Millisecond, TimeZone: tz})
+			builder.Append(arrow.Timestamp(ts.UnixMilli()))
+			require.NoError(t, copyData(field, builder.NewArray(), convertOptions{}))
+			actual := field.CopyAt(0).(time.Time)
+			require.True(t, ts.Equal(actual))
+			require.NotEqual(t, time.UTC, actual.Location())
+		})
+	}
+}
+
+func TestCopyData_TimestampLocation(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+	opts := convertOptions{location: loc}
+
+	naive := arrayFromJSON(t, &arrow.TimestampType{Unit: arrow.Second}, `[1672567200]`)
+	field := newField(arrow.Field{Name: "time", Type: naive.DataType()}, opts)
+	require.NoError(t, copyData(field, naive, opts))
+	require.True(t, time.Date(2023, 1, 1, 10, 0, 0, 0, loc).Equal(field.At(0).(time.Time)))
+
+	zoned := arrayFromJSON(t, &arrow.TimestampType{Unit: arrow.Second, TimeZone: "UTC"}, `[1672567200]`)
+	field = newField(arrow.Field{Name: "time", Type: zoned.DataType()}, opts)
+	require.NoError(t, copyData(field, zoned, opts))
+	require.True(t, time.Date(2023, 1, 1, 10, 0, 0, 0, time.UTC).Equal(field.At(0).(time.Time)))
+}
+
+func TestCopyData_Date(t *testing.T) {
+	day := time.Date(2023, 3, 4, 0, 0, 0, 0, time.UTC)
+
+	field := newField(arrow.Field{Name: "d32", Type: arrow.FixedWidthTypes.Date32, Nullable: true}, convertOptions{})
+	builder := array.NewDate32Builder(memory.DefaultAllocator)
+	builder.Append(arrow.Date32FromTime(day))
+	builder.AppendNull()
+	require.NoError(t, copyData(field, builder.NewArray(), convertOptions{}))
+	require.Equal(t, day, *field.CopyAt(0).(*time.Time))
+	require.Equal(t, (*time.Time)(nil), field.CopyAt(1))
+
+	field = newField(arrow.Field{Name: "d64", Type: arrow.FixedWidthTypes.Date64}, convertOptions{})
+	builder64 := array.NewDate64Builder(memory.DefaultAllocator)
+	builder64.Append(arrow.Date64FromTime(day))
+	require.NoError(t, copyData(field, builder64.NewArray(), convertOptions{}))
+	require.Equal(t, day, field.CopyAt(0))
+}
+
+func TestCopyData_TimeOfDay(t *testing.T) {
+	tod := 13*time.Hour + 14*time.Minute + 15*time.Second + 123*time.Millisecond
+
+	field := newField(arrow.Field{Name: "t32", Type: arrow.FixedWidthTypes.Time32ms}, convertOptions{})
+	builder := array.NewTime32Builder(memory.DefaultAllocator, &arrow.Time32Type{Unit: arrow.Millisecond})
+	builder.Append(arrow.Time32(tod.Milliseconds()))
+	require.NoError(t, copyData(field, builder.NewArray(), convertOptions{}))
+	require.Equal(t, "13:14:15.123", field.CopyAt(0))
+
+	field = newField(arrow.Field{Name: "t64", Type: arrow.FixedWidthTypes.Time64ns}, convertOptions{})
+	builder64 := array.NewTime64Builder(memory.DefaultAllocator, &arrow.Time64Type{Unit: arrow.Nanosecond})
+	builder64.Append(arrow.Time64(tod.Nanoseconds()))
+	require.NoError(t, copyData(field, builder64.NewArray(), convertOptions{}))
+	require.Equal(t, "13:14:15.123000000", field.CopyAt(0))
+}
+
+func TestCopyData_Duration(t *testing.T) {
+	dt := &arrow.DurationType{Unit: arrow.Millisecond}
+	field := newField(arrow.Field{Name: "elapsed", Type: dt, Nullable: true}, convertOptions{})
+	require.Equal(t, "ms", field.Config.Unit)
+
+	builder := array.NewDurationBuilder(memory.DefaultAllocator, dt)
+	builder.Append(2300)
+	builder.AppendNull()
+	require.NoError(t, copyData(field, builder.NewArray(), convertOptions{}))
+	require.Equal(t, int64(2300), *field.CopyAt(0).(*int64))
+	require.Equal(t, (*int64)(nil), field.CopyAt(1))
+}
+
+func TestCopyData_Interval(t *testing.T) {
+	field := newField(arrow.Field{Name: "dt", Type: arrow.FixedWidthTypes.DayTimeInterval}, convertOptions{})
+	require.Equal(t, "ms", field.Config.Unit)
+	dayTime := array.NewDayTimeIntervalBuilder(memory.DefaultAllocator)
+	dayTime.Append(arrow.DayTimeInterval{Days: 1, Milliseconds: 500})
+	require.NoError(t, copyData(field, dayTime.NewArray(), convertOptions{}))
+	require.Equal(t, (24*time.Hour + 500*time.Millisecond).Milliseconds(), field.CopyAt(0))
+
+	field = newField(arrow.Field{Name: "mdn", Type: arrow.FixedWidthTypes.MonthDayNanoInterval}, convertOptions{})
+	require.Equal(t, "ns", field.Config.Unit)
+	mdn := array.NewMonthDayNanoIntervalBuilder(memory.DefaultAllocator)
+	mdn.Append(arrow.MonthDayNanoInterval{Months: 1, Days: 2, Nanoseconds: 3})
+	require.NoError(t, copyData(field, mdn.NewArray(), convertOptions{}))
+	require.Equal(t, (32*24*time.Hour + 3).Nanoseconds(), field.CopyAt(0))
+
+	field = newField(arrow.Field{Name: "m", Type: arrow.FixedWidthTypes.MonthInterval}, convertOptions{})
+	months := array.NewMonthIntervalBuilder(memory.DefaultAllocator)
+	months.Append(arrow.MonthInterval(14))
+	require.NoError(t, copyData(field, months.NewArray(), convertOptions{}))
+	require.Equal(t, int32(14), field.CopyAt(0))
+}
+
+func TestCopyData_Float16(t *testing.T) {
+	field := newField(arrow.Field{Name: "half", Type: arrow.FixedWidthTypes.Float16, Nullable: true}, convertOptions{})
+	builder := array.NewFloat16Builder(memory.DefaultAllocator)
+	builder.Append(float16.New(1.5))
+	builder.AppendNull()
+	require.NoError(t, copyData(field, builder.NewArray(), convertOptions{}))
+	require.Equal(t, float32(1.5), *field.CopyAt(0).(*float32))
+	require.Equal(t, (*float32)(nil), field.CopyAt(1))
+}
+
+func TestCopyData_Uint64(t *testing.T) {
+	field := newField(arrow.Field{Name: "big", Type: arrow.PrimitiveTypes.Uint64}, convertOptions{})
+	builder := array.NewUint64Builder(memory.DefaultAllocator)
+	builder.Append(math.MaxUint64)
+	require.NoError(t, copyData(field, builder.NewArray(), convertOptions{}))
+	require.Equal(t, float64(math.MaxUint64), field.CopyAt(0))
+}
+
 func TestCopyData_Boolean(t *testing.T) {
 	field := data.NewField("field", nil, []bool{})
 	builder := array.NewBooleanBuilder(memory.DefaultAllocator)
 	builder.Append(true)
 	builder.Append(false)
 	builder.Append(true)
-	copyData(field, builder.NewArray())
+	copyData(field, builder.NewArray(), convertOptions{})
 	require.Equal(t, true, field.CopyAt(0))
 	require.Equal(t, false, field.CopyAt(1))
 	require.Equal(t, true, field.CopyAt(2))
@@ -397,7 +828,7 @@ func TestCopyData_Boolean(t *testing.T) {
 	builder.Append(true)
 	builder.AppendNull()
 	builder.Append(true)
-	copyData(field, builder.NewArray())
+	copyData(field, builder.NewArray(), convertOptions{})
 	require.Equal(t, true, *field.CopyAt(0).(*bool))
 	require.Equal(t, (*bool)(nil), field.CopyAt(1))
 	require.Equal(t, true, *field.CopyAt(2).(*bool))
@@ -409,7 +840,7 @@ func TestCopyData_Int64(t *testing.T) {
 	builder.Append(1)
 	builder.Append(2)
 	builder.Append(3)
-	copyData(field, builder.NewArray())
+	copyData(field, builder.NewArray(), convertOptions{})
 	require.Equal(t, int64(1), field.CopyAt(0))
 	require.Equal(t, int64(2), field.CopyAt(1))
 	require.Equal(t, int64(3), field.CopyAt(2))
@@ -420,7 +851,7 @@ func TestCopyData_Int64(t *testing.T) {
 	builder.AppendNull()
 	builder.Append(3)
 	arr := builder.NewArray()
-	copyData(field, arr)
+	copyData(field, arr, convertOptions{})
 	require.Equal(t, int64(1), *field.CopyAt(0).(*int64))
 	require.Equal(t, (*int64)(nil), field.CopyAt(1))
 	require.Equal(t, int64(3), *field.CopyAt(2).(*int64))
@@ -432,7 +863,7 @@ func TestCopyData_Float64(t *testing.T) {
 	builder.Append(1.1)
 	builder.Append(2.2)
 	builder.Append(3.3)
-	copyData(field, builder.NewArray())
+	copyData(field, builder.NewArray(), convertOptions{})
 	require.Equal(t, float64(1.1), field.CopyAt(0))
 	require.Equal(t, float64(2.2), field.CopyAt(1))
 	require.Equal(t, float64(3.3), field.CopyAt(2))
@@ -442,19 +873,138 @@ func TestCopyData_Float64(t *testing.T) {
 	builder.Append(1.1)
 	builder.AppendNull()
 	builder.Append(3.3)
-	copyData(field, builder.NewArray())
+	copyData(field, builder.NewArray(), convertOptions{})
 	require.Equal(t, float64(1.1), *field.CopyAt(0).(*float64))
 	require.Equal(t, (*float64)(nil), field.CopyAt(1))
 	require.Equal(t, float64(3.3), *field.CopyAt(2).(*float64))
 }
 
+func TestCopyData_Decimal(t *testing.T) {
+	dt := &arrow.Decimal128Type{Precision: 10, Scale: 2}
+	builder := array.NewDecimal128Builder(memory.DefaultAllocator, dt)
+	builder.Append(decimal128.FromI64(12345))
+	builder.AppendNull()
+	arr := builder.NewArray()
+
+	f := arrow.Field{Name: "price", Type: dt, Nullable: true}
+	field := newField(f, convertOptions{})
+	require.NoError(t, copyData(field, arr, convertOptions{}))
+	require.Equal(t, 123.45, *field.CopyAt(0).(*float64))
+	require.Equal(t, (*float64)(nil), field.CopyAt(1))
+
+	field = newField(f, convertOptions{decimalAsString: true})
+	require.NoError(t, copyData(field, arr, convertOptions{}))
+	require.Equal(t, "123.45", *field.CopyAt(0).(*string))
+	require.Equal(t, (*string)(nil), field.CopyAt(1))
+
+	dt256 := &arrow.Decimal256Type{Precision: 40, Scale: 3}
+	builder256 := array.NewDecimal256Builder(memory.DefaultAllocator, dt256)
+	builder256.Append(decimal256.FromI64(-1500))
+	field = newField(arrow.Field{Name: "total", Type: dt256}, convertOptions{})
+	require.NoError(t, copyData(field, builder256.NewArray(), convertOptions{}))
+	require.Equal(t, -1.5, field.CopyAt(0))
+}
+
+func TestCopyData_Dictionary(t *testing.T) {
+	dt := &arrow.DictionaryType{IndexType: arrow.PrimitiveTypes.Int8, ValueType: arrow.BinaryTypes.String}
+	builder := array.NewDictionaryBuilder(memory.DefaultAllocator, dt).(*array.BinaryDictionaryBuilder)
+	require.NoError(t, builder.AppendString("us-east"))
+	require.NoError(t, builder.AppendString("us-west"))
+	builder.AppendNull()
+	require.NoError(t, builder.AppendString("us-east"))
+	arr := builder.NewArray()
+
+	field := newField(arrow.Field{Name: "region", Type: dt, Nullable: true}, convertOptions{})
+	require.Equal(t, data.FieldTypeNullableString, field.Type())
+	require.NoError(t, copyData(field, arr, convertOptions{}))
+	require.Equal(t, 4, field.Len())
+	require.Equal(t, "us-east", *field.CopyAt(0).(*string))
+	require.Equal(t, "us-west", *field.CopyAt(1).(*string))
+	require.Equal(t, (*string)(nil), field.CopyAt(2))
+	require.Equal(t, "us-east", *field.CopyAt(3).(*string))
+}
+
+func TestCopyData_Nested(t *testing.T) {
+	list := arrayFromJSON(t, arrow.ListOf(arrow.PrimitiveTypes.Int64), `[[1, 2], null, []]`)
+	field := newField(arrow.Field{Name: "list", Type: list.DataType(), Nullable: true}, convertOptions{})
+	require.NoError(t, copyData(field, list, convertOptions{}))
+	require.Equal(t, json.RawMessage(`[1,2]`), *field.CopyAt(0).(*json.RawMessage))
+	require.Equal(t, (*json.RawMessage)(nil), field.CopyAt(1))
+	require.Equal(t, json.RawMessage(`[]`), *field.CopyAt(2).(*json.RawMessage))
+
+	structType := arrow.StructOf(
+		arrow.Field{Name: "host", Type: arrow.BinaryTypes.String},
+		arrow.Field{Name: "cpu", Type: arrow.PrimitiveTypes.Float64},
+	)
+	st := arrayFromJSON(t, structType, `[{"host": "a", "cpu": 0.5}]`)
+	field = newField(arrow.Field{Name: "struct", Type: structType}, convertOptions{})
+	require.NoError(t, copyData(field, st, convertOptions{}))
+	require.JSONEq(t, `{"host": "a", "cpu": 0.5}`, string(field.CopyAt(0).(json.RawMessage)))
+
+	mapType := arrow.MapOf(arrow.BinaryTypes.String, arrow.PrimitiveTypes.Int64)
+	m := arrayFromJSON(t, mapType, `[[{"key": "a", "value": 1}]]`)
+	field = newField(arrow.Field{Name: "map", Type: mapType}, convertOptions{})
+	require.NoError(t, copyData(field, m, convertOptions{}))
+	require.Equal(t, 1, field.Len())
+	require.Contains(t, string(field.CopyAt(0).(json.RawMessage)), `"a"`)
+}
+
+func arrayFromJSON(t *testing.T, dt arrow.DataType, s string) arrow.Array {
+	t.Helper()
+	arr, _, err := array.FromJSON(memory.DefaultAllocator, dt, strings.NewReader(s))
+	require.NoError(t, err)
+	return arr
+}
+
+func TestCopyData_Binary(t *testing.T) {
+	bin := arrayFromJSON(t, arrow.BinaryTypes.Binary, `["AQI=", null]`)
+	field := newField(arrow.Field{Name: "blob", Type: bin.DataType(), Nullable: true}, convertOptions{})
+	require.NoError(t, copyData(field, bin, convertOptions{}))
+	require.Equal(t, "AQI=", *field.CopyAt(0).(*string))
+	require.Equal(t, (*string)(nil), field.CopyAt(1))
+
+	uuid := &arrow.FixedSizeBinaryType{ByteWidth: 4}
+	builder := array.NewFixedSizeBinaryBuilder(memory.DefaultAllocator, uuid)
+	builder.Append([]byte{0xde, 0xad, 0xbe, 0xef})
+	field = newField(arrow.Field{Name: "id", Type: uuid}, convertOptions{})
+	require.NoError(t, copyData(field, builder.NewArray(), convertOptions{binaryEncoding: "hex"}))
+	require.Equal(t, "deadbeef", field.CopyAt(0))
+}
+
+func TestCopyData_Fallback(t *testing.T) {
+	large := arrayFromJSON(t, arrow.BinaryTypes.LargeString, `["a", null]`)
+	field := newField(arrow.Field{Name: "text", Type: large.DataType(), Nullable: true}, convertOptions{})
+	require.NoError(t, copyData(field, large, convertOptions{}))
+	require.Equal(t, "a", *field.CopyAt(0).(*string))
+	require.Equal(t, (*string)(nil), field.CopyAt(1))
+
+	nulls := array.NewNull(2)
+	field = newField(arrow.Field{Name: "nothing", Type: nulls.DataType(), Nullable: true}, convertOptions{})
+	require.NoError(t, copyData(field, nulls, convertOptions{}))
+	require.Equal(t, 2, field.Len())
+}
+
+func TestCopyData_CustomConverter(t *testing.T) {
+	original := converters[arrow.INT64]
+	t.Cleanup(func() { converters[arrow.INT64] = original })
+	converters[arrow.INT64] = convertedConverter(array.NewInt64Data, func(n int64) string {
+		return strconv.FormatInt(n, 16)
+	})
+
+	builder := array.NewInt64Builder(memory.DefaultAllocator)
+	builder.Append(255)
+	field := newField(arrow.Field{Name: "id", Type: arrow.PrimitiveTypes.Int64}, convertOptions{})
+	require.NoError(t, copyData(field, builder.NewArray(), convertOptions{}))
+	require.Equal(t, "ff", field.CopyAt(0))
+}
+
 func TestCustomMetadata(t *testing.T) {
 	schema := arrow.NewSchema([]arrow.Field{
 		{
 			Name:     "int64",
 			Type:     &arrow.Int64Type{},
 			Nullable: true,
-			Metadata: arrow.NewMetadata(nil, nil),
+			Metadata: arrow.NewMetadata([]string{"ARROW:FLIGHT:SQL:TYPE_NAME"}, []string{"BIGINT"}),
 		},
 	}, nil)
 	i64s, _, err := array.FromJSON(
@@ -472,10 +1022,10 @@ func TestCustomMetadata(t *testing.T) {
 	md := metadata.MD{}
 	md.Set("trace-id", "abc")
 	md.Set("trace-sampled", "true")
-	query := sqlutil.Query{
+	query := sqlQuery{Query: sqlutil.Query{
 		Format: sqlutil.FormatOptionTable,
-	}
-	resp := newQueryDataResponse(errReader{RecordReader: reader}, query, md)
+	}}
+	resp, _ := newQueryDataResponse(errReader{RecordReader: reader}, query, md)
 	require.NoError(t, resp.Error)
 
 	require.Equal(t, map[string]any{
@@ -483,5 +1033,52 @@ func TestCustomMetadata(t *testing.T) {
 			"trace-id":      []string{"abc"},
 			"trace-sampled": []string{"true"},
 		},
+		"schema": []schemaField{{
+			Name:     "int64",
+			Type:     "int64",
+			Nullable: true,
+			Metadata: map[string]string{"ARROW:FLIGHT:SQL:TYPE_NAME": "BIGINT"},
+		}},
 	}, resp.Frames[0].Meta.Custom)
 }
+
+func BenchmarkNewQueryDataResponse(b *testing.B) {
+	const (
+		batches   = 10
+		batchRows = 10_000
+	)
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "time", Type: &arrow.TimestampType{Unit: arrow.Nanosecond}},
+		{Name: "value", Type: arrow.PrimitiveTypes.Float64},
+		{Name: "count", Type: arrow.PrimitiveTypes.Int64, Nullable: true},
+		{Name: "host", Type: arrow.BinaryTypes.String},
+	}, nil)
+
+	var records []arrow.Record
+	builder := array.NewRecordBuilder(memory.DefaultAllocator, schema)
+	defer builder.Release()
+	for n := 0; n < batches; n++ {
+		for i := 0; i < batchRows; i++ {
+			builder.Field(0).(*array.TimestampBuilder).Append(arrow.Timestamp(n*batchRows + i))
+			builder.Field(1).(*array.Float64Builder).Append(float64(i))
+			if i%10 == 0 {
+				builder.Field(2).(*array.Int64Builder).AppendNull()
+			} else {
+				builder.Field(2).(*array.Int64Builder).Append(int64(i))
+			}
+			builder.Field(3).(*array.StringBuilder).Append(fmt.Sprintf("host-%d", i%100))
+		}
+		records = append(records, builder.NewRecord())
+	}
+	query := sqlQuery{Query: sqlutil.Query{Format: sqlutil.FormatOptionTable}}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		reader, err := array.NewRecordReader(schema, records)
+		require.NoError(b, err)
+		resp, _ := newQueryDataResponse(errReader{RecordReader: reader}, query, metadata.MD{})
+		require.NoError(b, resp.Error)
+		require.Equal(b, batches*batchRows, resp.Frames[0].Rows())
+	}
+}
@@ -0,0 +1,215 @@
+package flightsql
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// authTypeNone is the auth type of datasources whose server doesn't
+// authenticate clients. No credentials are sent, even if some are configured.
+const authTypeNone = "none"
+
+// defaultAuthHeader and defaultAuthScheme are the metadata key and scheme of
+// the token when the datasource doesn't configure them.
+const (
+	defaultAuthHeader = "authorization"
+	defaultAuthScheme = "Bearer"
+)
+
+// rawAuthScheme configures the token to be sent without a scheme, for servers
+// that expect an API key rather than an authorization header.
+const rawAuthScheme = "none"
+
+// authHeader returns the metadata key the token is sent as.
+func (cfg config) authHeader() string {
+	if cfg.AuthHeader == "" {
+		return defaultAuthHeader
+	}
+	return strings.ToLower(cfg.AuthHeader)
+}
+
+// credential returns the metadata value for a token.
+func (cfg config) credential(token string) string {
+	switch cfg.AuthScheme {
+	case "":
+		return fmt.Sprintf("%s %s", defaultAuthScheme, token)
+	case rawAuthScheme:
+		return token
+	default:
+		return fmt.Sprintf("%s %s", cfg.AuthScheme, token)
+	}
+}
+
+// validateAuthHeader checks that a header name can be sent as gRPC metadata.
+// Binary headers are excluded since tokens are sent as text.
+func validateAuthHeader(name string) error {
+	if strings.HasSuffix(name, "-bin") || strings.HasPrefix(name, "grpc-") {
+		return fmt.Errorf("unsupported auth header: %s", name)
+	}
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '-', r == '_', r == '.':
+		default:
+			return fmt.Errorf("unsupported auth header: %s", name)
+		}
+	}
+	return nil
+}
+
+// tokenDirEnvVar and tokenEnvPrefixEnvVar name the environment variables of
+// the plugin with which the operator allows datasources to read their token
+// from files in a directory, or from environment variables with a prefix.
+// Neither is allowed unless they are set, since otherwise anyone who can edit
+// a datasource could have any file or environment variable Grafana can read,
+// such as its secret key, sent to a server of their choosing.
+const (
+	tokenDirEnvVar       = "GF_PLUGIN_FLIGHTSQL_TOKEN_DIR"
+	tokenEnvPrefixEnvVar = "GF_PLUGIN_FLIGHTSQL_TOKEN_ENV_PREFIX"
+)
+
+// validateTokenFile checks that the operator allows the token to be read
+// from file.
+func validateTokenFile(file string) error {
+	dir := os.Getenv(tokenDirEnvVar)
+	if dir == "" {
+		return fmt.Errorf("token files aren't allowed unless %s is set", tokenDirEnvVar)
+	}
+	if !inDir(dir, file) {
+		return fmt.Errorf("token file must be in %s: %s", dir, file)
+	}
+	return nil
+}
+
+// validateTokenEnvVar checks that the operator allows the token to be read
+// from the environment variable name.
+func validateTokenEnvVar(name string) error {
+	prefix := os.Getenv(tokenEnvPrefixEnvVar)
+	if prefix == "" {
+		return fmt.Errorf("token environment variables aren't allowed unless %s is set", tokenEnvPrefixEnvVar)
+	}
+	if !strings.HasPrefix(name, prefix) || name == prefix {
+		return fmt.Errorf("token environment variable must start with %s: %s", prefix, name)
+	}
+	return nil
+}
+
+// inDir reports whether the absolute path file is within dir.
+func inDir(dir, file string) bool {
+	if !filepath.IsAbs(file) {
+		return false
+	}
+	rel, err := filepath.Rel(filepath.Clean(dir), filepath.Clean(file))
+	return err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// resolveTokenFile returns the path of a token file with its symlinks
+// resolved, checking that it is still within the allowed directory, so that a
+// link can't lead out of it.
+func resolveTokenFile(file string) (string, error) {
+	if err := validateTokenFile(file); err != nil {
+		return "", err
+	}
+	dir, err := filepath.EvalSymlinks(os.Getenv(tokenDirEnvVar))
+	if err != nil {
+		return "", fmt.Errorf("token file: %w", err)
+	}
+	resolved, err := filepath.EvalSymlinks(file)
+	if err != nil {
+		return "", fmt.Errorf("token file: %w", err)
+	}
+	if !inDir(dir, resolved) {
+		return "", fmt.Errorf("token file must be in %s: %s", dir, resolved)
+	}
+	return resolved, nil
+}
+
+// tokenFileTTL is how long a token read from a file is used before the file
+// is read again, so that rotated tokens are picked up.
+const tokenFileTTL = 10 * time.Second
+
+// tokenSource provides a bearer token that is read from a file or an
+// environment variable rather than stored in the datasource's settings, for
+// short-lived tokens that are rotated outside of Grafana.
+type tokenSource struct {
+	file   string
+	envVar string
+	now    func() time.Time
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+// newTokenSource returns a [tokenSource] for the configured token file or
+// environment variable, or nil if neither is configured. The token is read
+// up front so that a missing token is reported when the datasource is
+// created.
+func newTokenSource(cfg config) (*tokenSource, error) {
+	if cfg.TokenFile == "" && cfg.TokenEnvVar == "" {
+		return nil, nil
+	}
+	s := &tokenSource{
+		file:   cfg.TokenFile,
+		envVar: cfg.TokenEnvVar,
+		now:    time.Now,
+	}
+	if _, err := s.read(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Token returns the current token. Environment variables are read every
+// time, and files once the token read from them has expired. If the file
+// can't be read, such as while it is being replaced, the last token read is
+// used.
+func (s *tokenSource) Token() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.envVar == "" && s.now().Before(s.expires) {
+		return s.token
+	}
+	token, err := s.read()
+	if err != nil {
+		log.DefaultLogger.Error("Failed to read token, using the last token read", "error", err)
+		return s.token
+	}
+	return token
+}
+
+// read reads the token and stores it.
+func (s *tokenSource) read() (string, error) {
+	var token string
+	if s.envVar != "" {
+		if err := validateTokenEnvVar(s.envVar); err != nil {
+			return "", err
+		}
+		token = os.Getenv(s.envVar)
+		if token == "" {
+			return "", fmt.Errorf("token environment variable %s is empty", s.envVar)
+		}
+	} else {
+		file, err := resolveTokenFile(s.file)
+		if err != nil {
+			return "", err
+		}
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return "", fmt.Errorf("token file: %w", err)
+		}
+		token = strings.TrimSpace(string(b))
+		if token == "" {
+			return "", fmt.Errorf("token file %s is empty", s.file)
+		}
+	}
+	s.token = token
+	s.expires = s.now().Add(tokenFileTTL)
+	return token, nil
+}
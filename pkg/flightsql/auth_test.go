@@ -0,0 +1,112 @@
+package flightsql
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigCredential(t *testing.T) {
+	cfg := config{}
+	require.Equal(t, "authorization", cfg.authHeader())
+	require.Equal(t, "Bearer secret", cfg.credential("secret"))
+
+	cfg = config{AuthHeader: "X-API-Key", AuthScheme: "none"}
+	require.Equal(t, "x-api-key", cfg.authHeader())
+	require.Equal(t, "secret", cfg.credential("secret"))
+
+	cfg = config{AuthScheme: "Basic"}
+	require.Equal(t, "Basic secret", cfg.credential("secret"))
+}
+
+func TestValidateAuthHeader(t *testing.T) {
+	for _, name := range []string{"", "authorization", "X-API-Key", "x_token.v2"} {
+		require.NoError(t, validateAuthHeader(name), name)
+	}
+	for _, name := range []string{"x-token-bin", "grpc-timeout", "x token", "x:token"} {
+		require.Error(t, validateAuthHeader(name), name)
+	}
+}
+
+func TestTokenSource_File(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(tokenDirEnvVar, dir)
+	file := filepath.Join(dir, "token")
+	require.NoError(t, os.WriteFile(file, []byte("first\n"), 0o600))
+
+	s, err := newTokenSource(config{TokenFile: file})
+	require.NoError(t, err)
+	now := time.Now()
+	s.now = func() time.Time { return now }
+	require.Equal(t, "first", s.Token())
+
+	// The file is read again once the token expires.
+	require.NoError(t, os.WriteFile(file, []byte("second"), 0o600))
+	require.Equal(t, "first", s.Token())
+	now = now.Add(tokenFileTTL + time.Second)
+	require.Equal(t, "second", s.Token())
+
+	// The last token is used while the file can't be read.
+	require.NoError(t, os.Remove(file))
+	now = now.Add(tokenFileTTL + time.Second)
+	require.Equal(t, "second", s.Token())
+
+	_, err = newTokenSource(config{TokenFile: file})
+	require.Error(t, err)
+}
+
+func TestTokenSource_EnvVar(t *testing.T) {
+	t.Setenv(tokenEnvPrefixEnvVar, "FLIGHTSQL_TEST_")
+	t.Setenv("FLIGHTSQL_TEST_TOKEN", "first")
+
+	s, err := newTokenSource(config{TokenEnvVar: "FLIGHTSQL_TEST_TOKEN"})
+	require.NoError(t, err)
+	require.Equal(t, "first", s.Token())
+
+	t.Setenv("FLIGHTSQL_TEST_TOKEN", "second")
+	require.Equal(t, "second", s.Token())
+
+	_, err = newTokenSource(config{TokenEnvVar: "FLIGHTSQL_TEST_UNSET"})
+	require.Error(t, err)
+
+	s, err = newTokenSource(config{})
+	require.NoError(t, err)
+	require.Nil(t, s)
+}
+
+func TestValidateTokenFile(t *testing.T) {
+	require.Error(t, validateTokenFile("/var/run/secrets/token"))
+
+	dir := t.TempDir()
+	t.Setenv(tokenDirEnvVar, dir)
+	require.NoError(t, validateTokenFile(filepath.Join(dir, "token")))
+	for _, file := range []string{
+		"token",
+		dir,
+		filepath.Join(dir, "..", "token"),
+		filepath.Join(filepath.Dir(dir), filepath.Base(dir)+"-other", "token"),
+		"/etc/passwd",
+	} {
+		require.Error(t, validateTokenFile(file), file)
+	}
+
+	// Links out of the directory aren't followed.
+	outside := filepath.Join(t.TempDir(), "secret")
+	require.NoError(t, os.WriteFile(outside, []byte("secret"), 0o600))
+	link := filepath.Join(dir, "link")
+	require.NoError(t, os.Symlink(outside, link))
+	_, err := newTokenSource(config{TokenFile: link})
+	require.ErrorContains(t, err, "token file must be in")
+}
+
+func TestValidateTokenEnvVar(t *testing.T) {
+	require.Error(t, validateTokenEnvVar("FLIGHTSQL_TOKEN"))
+
+	t.Setenv(tokenEnvPrefixEnvVar, "FLIGHTSQL_")
+	require.NoError(t, validateTokenEnvVar("FLIGHTSQL_TOKEN"))
+	require.Error(t, validateTokenEnvVar("FLIGHTSQL_"))
+	require.Error(t, validateTokenEnvVar("GF_SECURITY_SECRET_KEY"))
+}
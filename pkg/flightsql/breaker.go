@@ -0,0 +1,73 @@
+package flightsql
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+)
+
+// defaultCircuitBreakerCooldown is how long the circuit stays open when the
+// datasource doesn't configure a cool-down.
+const defaultCircuitBreakerCooldown = 30 * time.Second
+
+// circuitBreaker fails queries fast once a number of consecutive queries have
+// failed to reach the server, rather than have every query wait for the
+// connection to time out. After a cool-down period queries are let through
+// again; if the next one fails the circuit opens for another period.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+
+	mu       sync.Mutex
+	failures int
+	// since is the time of the first of the consecutive failures.
+	since time.Time
+	// opened is the time of the last failure once the circuit is open.
+	opened time.Time
+}
+
+// newCircuitBreaker returns a [circuitBreaker] that opens after threshold
+// consecutive connection failures.
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		now:       time.Now,
+	}
+}
+
+// Allow returns an error if the circuit is open.
+func (b *circuitBreaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.failures >= b.threshold && b.now().Before(b.opened.Add(b.cooldown)) {
+		return fmt.Errorf("server unreachable since %s, retrying after %s",
+			b.since.UTC().Format(time.RFC3339), b.opened.Add(b.cooldown).UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// Record records the outcome of a query. Only failures to reach the server
+// count towards opening the circuit; any other outcome shows that the server
+// is reachable and closes it.
+func (b *circuitBreaker) Record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if st, ok := grpcStatus(err); !ok || st.Code() != codes.Unavailable {
+		b.failures = 0
+		return
+	}
+	now := b.now()
+	if b.failures == 0 {
+		b.since = now
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.opened = now
+	}
+}
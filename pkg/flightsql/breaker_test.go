@@ -0,0 +1,43 @@
+package flightsql
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	now := time.Unix(0, 0)
+	b := newCircuitBreaker(2, time.Minute)
+	b.now = func() time.Time { return now }
+	unavailable := status.Error(codes.Unavailable, "connection refused")
+
+	b.Record(unavailable)
+	require.NoError(t, b.Allow())
+
+	// Other errors show that the server is reachable.
+	b.Record(errors.New("syntax error"))
+	b.Record(unavailable)
+	require.NoError(t, b.Allow())
+
+	now = now.Add(time.Second)
+	b.Record(unavailable)
+	require.EqualError(t, b.Allow(), "server unreachable since 1970-01-01T00:00:00Z, retrying after 1970-01-01T00:01:01Z")
+
+	// After the cool-down a query is let through, and the circuit opens
+	// again if it fails.
+	now = now.Add(time.Minute)
+	require.NoError(t, b.Allow())
+	b.Record(unavailable)
+	require.Error(t, b.Allow())
+
+	now = now.Add(time.Minute)
+	require.NoError(t, b.Allow())
+	b.Record(nil)
+	b.Record(unavailable)
+	require.NoError(t, b.Allow())
+}
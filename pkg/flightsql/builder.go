@@ -0,0 +1,230 @@
+package flightsql
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// builderQuery is a structured query that is compiled to SQL by the backend,
+// letting the query editor offer a builder without generating SQL itself.
+type builderQuery struct {
+	Table  string `json:"table"`
+	Schema string `json:"schema,omitempty"`
+	// TimeColumn, if set, restricts the query to the dashboard time range.
+	TimeColumn string            `json:"timeColumn,omitempty"`
+	Columns    []builderColumn   `json:"columns,omitempty"`
+	Filters    []builderFilter   `json:"filters,omitempty"`
+	GroupBy    []string          `json:"groupBy,omitempty"`
+	OrderBy    []builderOrdering `json:"orderBy,omitempty"`
+	Limit      int64             `json:"limit,omitempty"`
+}
+
+// builderColumn is a column selected by a [builderQuery], optionally
+// aggregated.
+type builderColumn struct {
+	Name        string `json:"name"`
+	Aggregation string `json:"aggregation,omitempty"`
+	Alias       string `json:"alias,omitempty"`
+}
+
+// builderFilter is a condition of a [builderQuery]'s WHERE clause.
+type builderFilter struct {
+	Column   string          `json:"column"`
+	Operator string          `json:"operator"`
+	Value    json.RawMessage `json:"value,omitempty"`
+}
+
+// builderOrdering is a term of a [builderQuery]'s ORDER BY clause.
+type builderOrdering struct {
+	Column    string `json:"column"`
+	Direction string `json:"direction,omitempty"`
+}
+
+// builderAggregations are the aggregations a [builderColumn] may use.
+var builderAggregations = map[string]bool{
+	"count": true,
+	"sum":   true,
+	"avg":   true,
+	"min":   true,
+	"max":   true,
+}
+
+// builderOperators are the operators a [builderFilter] may use, mapped to
+// whether they take a value.
+var builderOperators = map[string]bool{
+	"=":           true,
+	"!=":          true,
+	"<":           true,
+	"<=":          true,
+	">":           true,
+	">=":          true,
+	"LIKE":        true,
+	"NOT LIKE":    true,
+	"IN":          true,
+	"NOT IN":      true,
+	"IS NULL":     false,
+	"IS NOT NULL": false,
+}
+
+// sql compiles the query to SQL. Identifiers are quoted and values are
+// rendered as literals so no part of the query is interpolated verbatim. The
+// time range filter is expressed with the $__timeRange macro, which is
+// expanded along with any others once the SQL is generated.
+func (q builderQuery) sql() (string, error) {
+	if q.Table == "" {
+		return "", errors.New("builder: table is required")
+	}
+
+	var b strings.Builder
+	b.WriteString("SELECT ")
+	if len(q.Columns) == 0 {
+		b.WriteString("*")
+	}
+	for i, c := range q.Columns {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		col, err := c.sql()
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(col)
+	}
+
+	b.WriteString(" FROM ")
+	if q.Schema != "" {
+		b.WriteString(quoteIdentifier(q.Schema) + ".")
+	}
+	b.WriteString(quoteIdentifier(q.Table))
+
+	var conds []string
+	if q.TimeColumn != "" {
+		conds = append(conds, fmt.Sprintf("$__timeRange(%s)", quoteIdentifier(q.TimeColumn)))
+	}
+	for _, f := range q.Filters {
+		cond, err := f.sql()
+		if err != nil {
+			return "", err
+		}
+		conds = append(conds, cond)
+	}
+	if len(conds) > 0 {
+		b.WriteString(" WHERE " + strings.Join(conds, " AND "))
+	}
+
+	if len(q.GroupBy) > 0 {
+		cols := make([]string, len(q.GroupBy))
+		for i, c := range q.GroupBy {
+			cols[i] = quoteIdentifier(c)
+		}
+		b.WriteString(" GROUP BY " + strings.Join(cols, ", "))
+	}
+
+	if len(q.OrderBy) > 0 {
+		terms := make([]string, len(q.OrderBy))
+		for i, o := range q.OrderBy {
+			dir := strings.ToUpper(o.Direction)
+			switch dir {
+			case "":
+				terms[i] = quoteIdentifier(o.Column)
+			case "ASC", "DESC":
+				terms[i] = quoteIdentifier(o.Column) + " " + dir
+			default:
+				return "", fmt.Errorf("builder: unsupported order direction: %s", o.Direction)
+			}
+		}
+		b.WriteString(" ORDER BY " + strings.Join(terms, ", "))
+	}
+
+	if q.Limit < 0 {
+		return "", errors.New("builder: limit must not be negative")
+	}
+	if q.Limit > 0 {
+		b.WriteString(" LIMIT " + strconv.FormatInt(q.Limit, 10))
+	}
+	return b.String(), nil
+}
+
+func (c builderColumn) sql() (string, error) {
+	if c.Name == "" {
+		return "", errors.New("builder: column name is required")
+	}
+	col := quoteIdentifier(c.Name)
+	if c.Name == "*" {
+		col = "*"
+	}
+	if c.Aggregation != "" {
+		agg := strings.ToLower(c.Aggregation)
+		if !builderAggregations[agg] {
+			return "", fmt.Errorf("builder: unsupported aggregation: %s", c.Aggregation)
+		}
+		col = fmt.Sprintf("%s(%s)", agg, col)
+	}
+	if c.Alias != "" {
+		col += " AS " + quoteIdentifier(c.Alias)
+	}
+	return col, nil
+}
+
+func (f builderFilter) sql() (string, error) {
+	if f.Column == "" {
+		return "", errors.New("builder: filter column is required")
+	}
+	op := strings.ToUpper(f.Operator)
+	hasValue, ok := builderOperators[op]
+	if !ok {
+		return "", fmt.Errorf("builder: unsupported operator: %s", f.Operator)
+	}
+	col := quoteIdentifier(f.Column)
+	if !hasValue {
+		return col + " " + op, nil
+	}
+
+	if op == "IN" || op == "NOT IN" {
+		var values []json.RawMessage
+		if err := json.Unmarshal(f.Value, &values); err != nil {
+			return "", fmt.Errorf("builder: %s requires a list of values: %w", op, err)
+		}
+		if len(values) == 0 {
+			return "", fmt.Errorf("builder: %s requires at least one value", op)
+		}
+		lits := make([]string, len(values))
+		for i, v := range values {
+			lit, err := sqlLiteral(v)
+			if err != nil {
+				return "", err
+			}
+			lits[i] = lit
+		}
+		return fmt.Sprintf("%s %s (%s)", col, op, strings.Join(lits, ", ")), nil
+	}
+
+	lit, err := sqlLiteral(f.Value)
+	if err != nil {
+		return "", err
+	}
+	return col + " " + op + " " + lit, nil
+}
+
+// sqlLiteral renders a JSON string, number or boolean as a SQL literal.
+func sqlLiteral(raw json.RawMessage) (string, error) {
+	var v any
+	d := json.NewDecoder(strings.NewReader(string(raw)))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		return "", fmt.Errorf("builder: invalid value: %w", err)
+	}
+	switch v := v.(type) {
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'", nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	default:
+		return "", fmt.Errorf("builder: unsupported value: %s", raw)
+	}
+}
@@ -0,0 +1,95 @@
+package flightsql
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuilderQuery_SQL(t *testing.T) {
+	cs := []struct {
+		name string
+		in   string
+		out  string
+	}{
+		{
+			name: "all columns",
+			in:   `{"table": "cpu"}`,
+			out:  `SELECT * FROM "cpu"`,
+		},
+		{
+			name: "full",
+			in: `{
+				"table": "cpu",
+				"schema": "iox",
+				"timeColumn": "time",
+				"columns": [{"name": "host"}, {"name": "usage", "aggregation": "AVG", "alias": "avg usage"}],
+				"filters": [
+					{"column": "region", "operator": "=", "value": "us-'west'"},
+					{"column": "usage", "operator": ">", "value": 0.5},
+					{"column": "host", "operator": "in", "value": ["a", "b"]},
+					{"column": "idle", "operator": "IS NOT NULL"}
+				],
+				"groupBy": ["host"],
+				"orderBy": [{"column": "host", "direction": "desc"}],
+				"limit": 10
+			}`,
+			out: `SELECT "host", avg("usage") AS "avg usage" FROM "iox"."cpu" ` +
+				`WHERE $__timeRange("time") AND "region" = 'us-''west''' AND "usage" > 0.5 AND "host" IN ('a', 'b') AND "idle" IS NOT NULL ` +
+				`GROUP BY "host" ORDER BY "host" DESC LIMIT 10`,
+		},
+		{
+			name: "quoted identifiers",
+			in:   `{"table": "a\"b", "columns": [{"name": "*", "aggregation": "count"}]}`,
+			out:  `SELECT count(*) FROM "a""b"`,
+		},
+	}
+	for _, c := range cs {
+		t.Run(c.name, func(t *testing.T) {
+			var q builderQuery
+			require.NoError(t, json.Unmarshal([]byte(c.in), &q))
+			sql, err := q.sql()
+			require.NoError(t, err)
+			require.Equal(t, c.out, sql)
+		})
+	}
+}
+
+func TestBuilderQuery_SQL_Invalid(t *testing.T) {
+	cs := map[string]string{
+		"missing table":       `{}`,
+		"bad aggregation":     `{"table": "t", "columns": [{"name": "a", "aggregation": "drop"}]}`,
+		"bad operator":        `{"table": "t", "filters": [{"column": "a", "operator": "; drop", "value": 1}]}`,
+		"bad value":           `{"table": "t", "filters": [{"column": "a", "operator": "=", "value": {"x": 1}}]}`,
+		"empty in":            `{"table": "t", "filters": [{"column": "a", "operator": "IN", "value": []}]}`,
+		"bad order direction": `{"table": "t", "orderBy": [{"column": "a", "direction": "sideways"}]}`,
+		"negative limit":      `{"table": "t", "limit": -1}`,
+	}
+	for name, in := range cs {
+		t.Run(name, func(t *testing.T) {
+			var q builderQuery
+			require.NoError(t, json.Unmarshal([]byte(in), &q))
+			_, err := q.sql()
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestDecodeQueryRequest_Builder(t *testing.T) {
+	from := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	query, err := decodeQueryRequest(backend.DataQuery{
+		JSON:      []byte(`{"builder": {"table": "cpu", "timeColumn": "time"}}`),
+		TimeRange: backend.TimeRange{From: from, To: from.Add(time.Hour)},
+	})
+	require.NoError(t, err)
+	require.Equal(t, `SELECT * FROM "cpu" WHERE "time" >= '2023-01-01T00:00:00Z' AND "time" <= '2023-01-01T01:00:00Z'`, query.RawSQL)
+
+	query, err = decodeQueryRequest(backend.DataQuery{
+		JSON: []byte(`{"queryText": "select 1", "builder": {"table": "cpu"}}`),
+	})
+	require.NoError(t, err)
+	require.Equal(t, "select 1", query.RawSQL)
+}
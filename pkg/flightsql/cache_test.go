@@ -0,0 +1,46 @@
+package flightsql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestTTLCache(t *testing.T) {
+	now := time.Unix(0, 0)
+	c := newTTLCache[int](time.Minute, 2)
+	c.now = func() time.Time { return now }
+
+	c.Set("a", 1)
+	v, ok := c.Get("a")
+	require.True(t, ok)
+	require.Equal(t, 1, v)
+
+	now = now.Add(time.Minute)
+	_, ok = c.Get("a")
+	require.False(t, ok)
+
+	c.Set("b", 2)
+	c.Delete("b")
+	_, ok = c.Get("b")
+	require.False(t, ok)
+}
+
+func TestTTLCache_Evict(t *testing.T) {
+	now := time.Unix(0, 0)
+	c := newTTLCache[int](time.Minute, 2)
+	c.now = func() time.Time { return now }
+
+	c.Set("a", 1)
+	now = now.Add(time.Second)
+	c.Set("b", 2)
+	c.Set("c", 3)
+
+	_, ok := c.Get("a")
+	require.False(t, ok)
+	_, ok = c.Get("b")
+	require.True(t, ok)
+	_, ok = c.Get("c")
+	require.True(t, ok)
+}
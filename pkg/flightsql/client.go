@@ -1,19 +1,35 @@
 package flightsql
 
 import (
+	"bufio"
 	"context"
+	"crypto/tls"
 	"crypto/x509"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/apache/arrow/go/v12/arrow/flight"
 	"github.com/apache/arrow/go/v12/arrow/flight/flightsql"
 	"github.com/apache/arrow/go/v12/arrow/ipc"
 	"github.com/apache/arrow/go/v12/arrow/memory"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/proxy"
+	"go.opentelemetry.io/contrib/instrumentation/google.golang.org/grpc/otelgrpc"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/connectivity"
 	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 func newFlightSQLClient(cfg config) (*client, error) {
@@ -21,30 +37,367 @@ func newFlightSQLClient(cfg config) (*client, error) {
 	if err != nil {
 		return nil, fmt.Errorf("grpc dial options: %s", err)
 	}
-	fsqlc, err := flightsql.NewClient(cfg.Addr, nil, nil, dialOptions...)
+	dial := func() (*grpc.ClientConn, error) {
+		return grpc.Dial(dialTarget(cfg), dialOptions...)
+	}
+	var fo *failover
+	if len(cfg.FailoverHosts) > 0 {
+		creds, err := transportCredentials(cfg)
+		if err != nil {
+			return nil, err
+		}
+		fo = newFailover(cfg, dialOptions, creds)
+		dial = func() (*grpc.ClientConn, error) {
+			return fo.dial(dialOptions)
+		}
+	}
+	size := cfg.ConnectionPoolSize
+	if size < 1 {
+		size = 1
+	}
+	pool := &connPool{conns: make([]*reconnectingConn, 0, size)}
+	for i := 0; i < size; i++ {
+		conn, err := newReconnectingConn(dial)
+		if err != nil {
+			_ = pool.Close()
+			return nil, err
+		}
+		pool.conns = append(pool.conns, conn)
+	}
+	fsqlc := &flightsql.Client{
+		Client: flight.NewClientFromConn(pool, nil),
+		Alloc:  allocator,
+	}
+	if fo != nil {
+		go fo.probe()
+	}
+	return &client{Client: fsqlc, failover: fo}, nil
+}
+
+// connPool is a [grpc.ClientConnInterface] that spreads calls across a pool
+// of connections in turn, since the number of concurrent streams on each
+// connection is limited by the server.
+type connPool struct {
+	conns []*reconnectingConn
+	next  uint32
+}
+
+// conn returns the connection for the next call.
+func (p *connPool) conn() *reconnectingConn {
+	n := atomic.AddUint32(&p.next, 1)
+	return p.conns[n%uint32(len(p.conns))]
+}
+
+// Invoke implements [grpc.ClientConnInterface].
+func (p *connPool) Invoke(ctx context.Context, method string, args, reply any, opts ...grpc.CallOption) error {
+	return p.conn().Invoke(ctx, method, args, reply, opts...)
+}
+
+// NewStream implements [grpc.ClientConnInterface].
+func (p *connPool) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return p.conn().NewStream(ctx, desc, method, opts...)
+}
+
+// Close closes every connection in the pool, returning the first error.
+func (p *connPool) Close() error {
+	var firstErr error
+	for _, conn := range p.conns {
+		if err := conn.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// reconnectingConn is a [grpc.ClientConnInterface] whose connection is
+// replaced by a new one when a call is made after it has failed or been shut
+// down, so that the datasource recovers once the server is reachable again
+// rather than having to be recreated.
+type reconnectingConn struct {
+	dial func() (*grpc.ClientConn, error)
+
+	mu     sync.Mutex
+	cc     *grpc.ClientConn
+	closed bool
+}
+
+// newReconnectingConn returns a [reconnectingConn] that connects with dial.
+func newReconnectingConn(dial func() (*grpc.ClientConn, error)) (*reconnectingConn, error) {
+	cc, err := dial()
 	if err != nil {
 		return nil, err
 	}
-	return &client{fsqlc}, nil
+	return &reconnectingConn{dial: dial, cc: cc}, nil
 }
 
-func grpcDialOptions(cfg config) ([]grpc.DialOption, error) {
-	transport := grpc.WithTransportCredentials(insecure.NewCredentials())
-	if cfg.Secure {
-		pool, err := x509.SystemCertPool()
+// conn returns the current connection, replacing it first if it has failed.
+func (c *reconnectingConn) conn() *grpc.ClientConn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.closed {
+		return c.cc
+	}
+	switch state := c.cc.GetState(); state {
+	case connectivity.TransientFailure, connectivity.Shutdown:
+		cc, err := c.dial()
 		if err != nil {
-			return nil, fmt.Errorf("x509: %s", err)
+			log.DefaultLogger.Error("Failed to reconnect", "error", err)
+			return c.cc
+		}
+		log.DefaultLogger.Info("Reconnecting to server", "state", state.String())
+		if err := c.cc.Close(); err != nil && state != connectivity.Shutdown {
+			log.DefaultLogger.Error("Failed to close failed connection", "error", err)
 		}
-		transport = grpc.WithTransportCredentials(credentials.NewClientTLSFromCert(pool, ""))
+		c.cc = cc
+	}
+	return c.cc
+}
+
+// Invoke implements [grpc.ClientConnInterface].
+func (c *reconnectingConn) Invoke(ctx context.Context, method string, args, reply any, opts ...grpc.CallOption) error {
+	return c.conn().Invoke(ctx, method, args, reply, opts...)
+}
+
+// NewStream implements [grpc.ClientConnInterface].
+func (c *reconnectingConn) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	return c.conn().NewStream(ctx, desc, method, opts...)
+}
+
+// Close closes the connection for good.
+func (c *reconnectingConn) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.closed = true
+	return c.cc.Close()
+}
+
+func grpcDialOptions(cfg config) ([]grpc.DialOption, error) {
+	creds, err := transportCredentials(cfg)
+	if err != nil {
+		return nil, err
 	}
 
 	opts := []grpc.DialOption{
-		transport,
+		grpc.WithTransportCredentials(creds),
+		// Calls are traced, and the trace context of the Grafana request is
+		// sent to the server in their metadata.
+		grpc.WithUnaryInterceptor(otelgrpc.UnaryClientInterceptor()),
+		grpc.WithStreamInterceptor(otelgrpc.StreamClientInterceptor()),
+	}
+
+	if cfg.RoundRobin {
+		opts = append(opts, grpc.WithDefaultServiceConfig(roundRobinServiceConfig))
+	}
+
+	var callOpts []grpc.CallOption
+	if cfg.Compression != "" {
+		callOpts = append(callOpts, grpc.UseCompressor(cfg.Compression))
+	}
+	if cfg.MaxRecvMsgSizeMB > 0 {
+		callOpts = append(callOpts, grpc.MaxCallRecvMsgSize(cfg.MaxRecvMsgSizeMB*1024*1024))
+	}
+	if len(callOpts) > 0 {
+		opts = append(opts, grpc.WithDefaultCallOptions(callOpts...))
+	}
+
+	proxyOpts := &proxy.Options{Enabled: cfg.SecureSocksProxy}
+	if proxy.SecureSocksProxyEnabled(proxyOpts) {
+		dialer, err := secureSocksProxyDialer(proxyOpts)
+		if err != nil {
+			return nil, fmt.Errorf("secure socks proxy: %s", err)
+		}
+		opts = append(opts, grpc.WithContextDialer(dialer))
+	}
+
+	// Without a proxy URL, gRPC's own dialer honors the HTTPS_PROXY and
+	// NO_PROXY environment variables.
+	if cfg.ProxyURL != "" {
+		proxyURL, err := parseProxyURL(cfg.ProxyURL)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.WithContextDialer(httpProxyDialer(proxyURL)))
 	}
 
 	return opts, nil
 }
 
+// transportCredentials returns the credentials connections are secured with:
+// TLS if the datasource is secure and none otherwise.
+func transportCredentials(cfg config) (credentials.TransportCredentials, error) {
+	if !cfg.Secure {
+		return insecure.NewCredentials(), nil
+	}
+	tlsCfg, err := tlsConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return credentials.NewTLS(tlsCfg), nil
+}
+
+// roundRobinServiceConfig spreads calls across all addresses the target
+// resolves to.
+const roundRobinServiceConfig = `{"loadBalancingConfig": [{"round_robin": {}}]}`
+
+// dialTarget returns the gRPC dial target for the configured address. Round
+// robin load balancing requires the dns resolver, which gRPC doesn't use by
+// default, so plain "host:port" addresses are given the dns scheme.
+func dialTarget(cfg config) string {
+	if !cfg.RoundRobin || strings.HasPrefix(cfg.Addr, "dns:") || strings.HasPrefix(cfg.Addr, "unix") {
+		return cfg.Addr
+	}
+	return "dns:///" + cfg.Addr
+}
+
+// parseProxyURL parses the URL of an HTTP proxy, adding the default port of
+// its scheme if it has none.
+func parseProxyURL(s string) (*url.URL, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %s", err)
+	}
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid proxy URL: %s: missing host", s)
+	}
+	port := u.Port()
+	switch u.Scheme {
+	case "http":
+		if port == "" {
+			port = "80"
+		}
+	case "https":
+		if port == "" {
+			port = "443"
+		}
+	default:
+		return nil, fmt.Errorf("unsupported proxy URL scheme: %s", u.Scheme)
+	}
+	u.Host = net.JoinHostPort(u.Hostname(), port)
+	return u, nil
+}
+
+// httpProxyDialer returns a gRPC dialer that tunnels connections through an
+// HTTP proxy with CONNECT requests, authenticating with the proxy using the
+// credentials in its URL, if any.
+func httpProxyDialer(proxyURL *url.URL) func(context.Context, string) (net.Conn, error) {
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", proxyURL.Host)
+		if err != nil {
+			return nil, fmt.Errorf("proxy: %w", err)
+		}
+		tunnel, err := connectTunnel(ctx, conn, proxyURL, addr)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("proxy: %w", err)
+		}
+		return tunnel, nil
+	}
+}
+
+// connectTunnel asks the proxy on conn to connect to addr, returning the
+// connection to use for addr once it has.
+func connectTunnel(ctx context.Context, conn net.Conn, proxyURL *url.URL, addr string) (net.Conn, error) {
+	if deadline, ok := ctx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			return nil, err
+		}
+		defer conn.SetDeadline(time.Time{})
+	}
+	if proxyURL.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Host: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxyURL.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("CONNECT %s: %s", addr, resp.Status)
+	}
+	// The server may already have sent data through the tunnel, which has
+	// been buffered while reading the proxy's response.
+	if r.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, r: r}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn is a connection whose first reads are served from a buffer.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// secureSocksProxyDialer returns a gRPC dialer that tunnels connections
+// through Grafana's secure socks proxy.
+func secureSocksProxyDialer(opts *proxy.Options) (func(context.Context, string) (net.Conn, error), error) {
+	d, err := proxy.NewSecureSocksProxyContextDialer(opts)
+	if err != nil {
+		return nil, err
+	}
+	cd, ok := d.(interface {
+		DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+	})
+	if !ok {
+		return nil, fmt.Errorf("unable to cast socks proxy dialer to context dialer")
+	}
+	return func(ctx context.Context, addr string) (net.Conn, error) {
+		return cd.DialContext(ctx, "tcp", addr)
+	}, nil
+}
+
+// tlsConfig builds the [tls.Config] used for secure connections.
+func tlsConfig(cfg config) (*tls.Config, error) {
+	if cfg.InsecureSkipVerify {
+		return &tls.Config{InsecureSkipVerify: true, ServerName: cfg.TLSServerName}, nil
+	}
+	pool, err := certPool(cfg)
+	if err != nil {
+		return nil, err
+	}
+	// An empty ServerName makes gRPC derive it from the dial target.
+	return &tls.Config{RootCAs: pool, ServerName: cfg.TLSServerName}, nil
+}
+
+// certPool returns the system certificate pool extended with the configured
+// PEM encoded CA certificates, if any.
+func certPool(cfg config) (*x509.CertPool, error) {
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		return nil, fmt.Errorf("x509: %s", err)
+	}
+	if cfg.TLSCACert != "" && !pool.AppendCertsFromPEM([]byte(cfg.TLSCACert)) {
+		return nil, fmt.Errorf("x509: no valid PEM certificates found in CA certificate")
+	}
+	return pool, nil
+}
+
 // client wraps a [flightsql.Client] client to extend its behavior.
 //
 // The API provided by flightsql.Client provides no access to gRPC headers for
@@ -52,6 +405,18 @@ func grpcDialOptions(cfg config) ([]grpc.DialOption, error) {
 // to provide access to that information.
 type client struct {
 	*flightsql.Client
+
+	// failover moves the connection between the configured hosts when
+	// non-nil.
+	failover *failover
+}
+
+// Close closes the connection.
+func (c *client) Close() error {
+	if c.failover != nil {
+		c.failover.Close()
+	}
+	return c.Client.Close()
 }
 
 // FlightClient returns the underlying [flight.Client].
@@ -119,3 +484,28 @@ func (s *headerExtractor) Recv() (*flight.FlightData, error) {
 	})
 	return data, err
 }
+
+// grpcStatus returns the status of a failed gRPC call from err, which may
+// wrap it.
+func grpcStatus(err error) (*status.Status, bool) {
+	var se interface{ GRPCStatus() *status.Status }
+	if errors.As(err, &se) {
+		return se.GRPCStatus(), true
+	}
+	return nil, false
+}
+
+// retryable reports whether err is from a call that failed transiently, such
+// as when the connection dropped, and may succeed if made again.
+func retryable(err error) bool {
+	st, ok := grpcStatus(err)
+	if !ok {
+		return false
+	}
+	switch st.Code() {
+	case codes.Unavailable, codes.Aborted:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,72 @@
+package flightsql
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// columnRule configures how the fields of the columns it matches are
+// displayed, so that display settings such as units travel with the data
+// rather than being repeated as overrides on every panel.
+type columnRule struct {
+	// Column is the name of the columns the rule applies to, which may be a
+	// pattern such as "*_bytes".
+	Column      string  `json:"column"`
+	DisplayName string  `json:"displayName,omitempty"`
+	Unit        string  `json:"unit,omitempty"`
+	Decimals    *uint16 `json:"decimals,omitempty"`
+}
+
+// validateColumnRules checks that every rule has a valid column pattern.
+func validateColumnRules(rules []columnRule) error {
+	for _, r := range rules {
+		if r.Column == "" {
+			return fmt.Errorf("column rule column is required")
+		}
+		if _, err := path.Match(r.Column, ""); err != nil {
+			return fmt.Errorf("invalid column rule pattern: %s", r.Column)
+		}
+	}
+	return nil
+}
+
+// applyColumnRules sets the display name, unit and decimals of the fields of
+// frames that rules match. Rules are applied in order, so a later rule
+// overrides the settings of an earlier one that matches the same column,
+// while leaving the settings it doesn't set alone.
+func applyColumnRules(frames data.Frames, rules []columnRule) {
+	if len(rules) == 0 {
+		return
+	}
+	for _, frame := range frames {
+		for _, field := range frame.Fields {
+			var cfg *data.FieldConfig
+			for _, r := range rules {
+				if ok, _ := path.Match(r.Column, field.Name); !ok {
+					continue
+				}
+				// The config is copied, since fields may share theirs.
+				if cfg == nil {
+					cfg = &data.FieldConfig{}
+					if field.Config != nil {
+						*cfg = *field.Config
+					}
+				}
+				if r.DisplayName != "" {
+					cfg.DisplayNameFromDS = r.DisplayName
+				}
+				if r.Unit != "" {
+					cfg.Unit = r.Unit
+				}
+				if r.Decimals != nil {
+					cfg.Decimals = r.Decimals
+				}
+			}
+			if cfg != nil {
+				field.Config = cfg
+			}
+		}
+	}
+}
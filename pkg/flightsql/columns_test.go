@@ -0,0 +1,42 @@
+package flightsql
+
+import (
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyColumnRules(t *testing.T) {
+	duration := &data.FieldConfig{Unit: "ms"}
+	frame := data.NewFrame("",
+		data.NewField("cpu_pct", nil, []float64{1}),
+		data.NewField("bytes_sent", nil, []int64{1}),
+		data.NewField("bytes_recv", nil, []int64{1}),
+		data.NewField("took", nil, []int64{1}).SetConfig(duration),
+		data.NewField("host", nil, []string{"a"}),
+	)
+
+	applyColumnRules(data.Frames{frame}, []columnRule{
+		{Column: "cpu_pct", DisplayName: "CPU %", Unit: "percent", Decimals: ptr(uint16(1))},
+		{Column: "bytes_*", Unit: "bytes"},
+		{Column: "bytes_recv", Unit: "decbytes"},
+		{Column: "took", Decimals: ptr(uint16(0))},
+	})
+
+	require.Equal(t, &data.FieldConfig{DisplayNameFromDS: "CPU %", Unit: "percent", Decimals: ptr(uint16(1))}, frame.Fields[0].Config)
+	require.Equal(t, &data.FieldConfig{Unit: "bytes"}, frame.Fields[1].Config)
+	// Later rules override earlier ones.
+	require.Equal(t, &data.FieldConfig{Unit: "decbytes"}, frame.Fields[2].Config)
+	// Existing settings are kept, without changing the config they came
+	// from.
+	require.Equal(t, &data.FieldConfig{Unit: "ms", Decimals: ptr(uint16(0))}, frame.Fields[3].Config)
+	require.Nil(t, duration.Decimals)
+	require.Nil(t, frame.Fields[4].Config)
+}
+
+func TestValidateColumnRules(t *testing.T) {
+	require.NoError(t, validateColumnRules([]columnRule{{Column: "*_bytes", Unit: "bytes"}}))
+	require.EqualError(t, validateColumnRules([]columnRule{{Unit: "bytes"}}), "column rule column is required")
+	require.EqualError(t, validateColumnRules([]columnRule{{Column: "[bytes"}}), "invalid column rule pattern: [bytes")
+}
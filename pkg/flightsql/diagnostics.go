@@ -0,0 +1,126 @@
+package flightsql
+
+import (
+	"math"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencyWindow is the number of recent queries whose latencies the
+// datasource's latency percentiles are computed over.
+const latencyWindow = 1000
+
+// instanceStats are rolling statistics about the queries of a datasource
+// instance, for diagnosing a misbehaving datasource without its logs or
+// metrics.
+type instanceStats struct {
+	started time.Time
+
+	mu          sync.Mutex
+	queries     int64
+	errors      int64
+	cacheHits   int64
+	cacheMisses int64
+	// latencies is a ring buffer of the latencies of the most recent
+	// queries, of which next is the oldest once it is full.
+	latencies []time.Duration
+	next      int
+}
+
+// newInstanceStats returns an empty [instanceStats].
+func newInstanceStats() *instanceStats {
+	return &instanceStats{
+		started:   time.Now(),
+		latencies: make([]time.Duration, 0, latencyWindow),
+	}
+}
+
+// RecordQuery records a query executed against the server.
+func (s *instanceStats) RecordQuery(latency time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.queries++
+	if err != nil {
+		s.errors++
+	}
+	if len(s.latencies) < latencyWindow {
+		s.latencies = append(s.latencies, latency)
+		return
+	}
+	s.latencies[s.next] = latency
+	s.next = (s.next + 1) % latencyWindow
+}
+
+// RecordCache records a lookup in the query cache.
+func (s *instanceStats) RecordCache(hit bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if hit {
+		s.cacheHits++
+	} else {
+		s.cacheMisses++
+	}
+}
+
+// instanceStatsSnapshot is the JSON form of [instanceStats].
+type instanceStatsSnapshot struct {
+	Since        time.Time `json:"since"`
+	Queries      int64     `json:"queries"`
+	Errors       int64     `json:"errors"`
+	ErrorRate    float64   `json:"errorRate"`
+	P50Ms        float64   `json:"p50Ms"`
+	P95Ms        float64   `json:"p95Ms"`
+	CacheHits    int64     `json:"cacheHits"`
+	CacheMisses  int64     `json:"cacheMisses"`
+	CacheHitRate float64   `json:"cacheHitRate"`
+}
+
+// Snapshot returns the statistics as they are now.
+func (s *instanceStats) Snapshot() instanceStatsSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snap := instanceStatsSnapshot{
+		Since:        s.started,
+		Queries:      s.queries,
+		Errors:       s.errors,
+		ErrorRate:    ratio(s.errors, s.queries),
+		CacheHits:    s.cacheHits,
+		CacheMisses:  s.cacheMisses,
+		CacheHitRate: ratio(s.cacheHits, s.cacheHits+s.cacheMisses),
+	}
+	if len(s.latencies) > 0 {
+		sorted := append([]time.Duration(nil), s.latencies...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		snap.P50Ms = durationMilliseconds(percentile(sorted, 0.5))
+		snap.P95Ms = durationMilliseconds(percentile(sorted, 0.95))
+	}
+	return snap
+}
+
+// percentile returns the p-th percentile of sorted by the nearest-rank
+// method.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+// ratio returns n/d, or zero if d is zero.
+func ratio(n, d int64) float64 {
+	if d == 0 {
+		return 0
+	}
+	return float64(n) / float64(d)
+}
+
+// getStats returns the datasource's query statistics.
+func (d *FlightSQLDatasource) getStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, d.instanceStats.Snapshot())
+}
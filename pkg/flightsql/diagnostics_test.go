@@ -0,0 +1,60 @@
+package flightsql
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInstanceStats(t *testing.T) {
+	s := newInstanceStats()
+	require.Equal(t, instanceStatsSnapshot{Since: s.started}, s.Snapshot())
+
+	for i := 1; i <= 100; i++ {
+		var err error
+		if i%4 == 0 {
+			err = errors.New("failed")
+		}
+		s.RecordQuery(time.Duration(i)*time.Millisecond, err)
+	}
+	s.RecordCache(true)
+	s.RecordCache(false)
+	s.RecordCache(false)
+	s.RecordCache(false)
+
+	snap := s.Snapshot()
+	require.Equal(t, int64(100), snap.Queries)
+	require.Equal(t, int64(25), snap.Errors)
+	require.Equal(t, 0.25, snap.ErrorRate)
+	require.Equal(t, float64(50), snap.P50Ms)
+	require.Equal(t, float64(95), snap.P95Ms)
+	require.Equal(t, 0.25, snap.CacheHitRate)
+
+	// Percentiles are of the most recent queries.
+	for i := 0; i < latencyWindow; i++ {
+		s.RecordQuery(time.Second, nil)
+	}
+	snap = s.Snapshot()
+	require.Equal(t, int64(100+latencyWindow), snap.Queries)
+	require.Equal(t, float64(1000), snap.P50Ms)
+}
+
+func TestIntegration_GetStats(t *testing.T) {
+	ds := newIntegrationDatasource(t)
+	_, err := ds.QueryData(context.Background(), &backend.QueryDataRequest{
+		Queries: []backend.DataQuery{{RefID: "A", JSON: mustQueryJSON(t, "A", "select 1")}},
+	})
+	require.NoError(t, err)
+
+	status, body := callResource(t, ds, "plugin/stats")
+	require.Equal(t, http.StatusOK, status, string(body))
+	var snap instanceStatsSnapshot
+	require.NoError(t, json.Unmarshal(body, &snap))
+	require.Equal(t, int64(1), snap.Queries)
+}
@@ -0,0 +1,276 @@
+package flightsql
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/attributes"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/resolver"
+)
+
+// failoverScheme is the scheme of the dial target of connections with
+// failover hosts, whose addresses are provided by a [failover].
+const failoverScheme = "failover"
+
+// defaultFailoverProbeInterval is how often the primary host is probed when
+// the datasource doesn't configure an interval.
+const defaultFailoverProbeInterval = 30 * time.Second
+
+// failoverProbeTimeout bounds the time taken to connect to the primary host
+// when probing it.
+const failoverProbeTimeout = 5 * time.Second
+
+// failover resolves the dial target of connections to the configured host
+// followed by its failover hosts. gRPC's default pick_first balancer connects
+// to the first of them that is reachable, and reconnects in the same order
+// when the connection fails. While a connection is on a failover host, the
+// primary host is probed on an interval and the connection moved back to it
+// once it recovers.
+type failover struct {
+	addrs    []resolver.Address
+	interval time.Duration
+	// dialOptions are used to probe the primary host in the same way as
+	// connections connect to it.
+	dialOptions []grpc.DialOption
+	// creds are the credentials of connections, wrapped to record the host
+	// each is connected to.
+	creds credentials.TransportCredentials
+	done  chan struct{}
+
+	mu sync.Mutex
+	// resolvers are the resolvers of open connections.
+	resolvers map[*failoverResolver]struct{}
+}
+
+var _ resolver.Builder = (*failover)(nil)
+
+// newFailover returns a [failover] for the configured hosts, whose
+// connections are secured with creds.
+func newFailover(cfg config, dialOptions []grpc.DialOption, creds credentials.TransportCredentials) *failover {
+	hosts := append([]string{cfg.Addr}, cfg.FailoverHosts...)
+	addrs := make([]resolver.Address, len(hosts))
+	for i, host := range hosts {
+		addrs[i] = resolver.Address{Addr: host}
+		// Certificates are verified against each host's own name.
+		if name, _, err := net.SplitHostPort(host); err == nil {
+			addrs[i].ServerName = name
+		}
+	}
+
+	interval := defaultFailoverProbeInterval
+	if cfg.FailoverProbeSeconds > 0 {
+		interval = time.Duration(cfg.FailoverProbeSeconds) * time.Second
+	}
+	return &failover{
+		addrs:       addrs,
+		interval:    interval,
+		dialOptions: append([]grpc.DialOption(nil), dialOptions...),
+		creds:       failoverCredentials{creds},
+		done:        make(chan struct{}),
+		resolvers:   make(map[*failoverResolver]struct{}),
+	}
+}
+
+// Build implements [resolver.Builder].
+func (f *failover) Build(_ resolver.Target, cc resolver.ClientConn, _ resolver.BuildOptions) (resolver.Resolver, error) {
+	r := &failoverResolver{failover: f, cc: cc}
+	// Each address is tagged with the resolver and its index, so that the
+	// credentials can record which host the connection is on.
+	r.addrs = make([]resolver.Address, len(f.addrs))
+	for i, addr := range f.addrs {
+		addr.Attributes = attributes.New(failoverHostKey{}, failoverHost{resolver: r, index: int32(i)})
+		r.addrs[i] = addr
+	}
+	f.mu.Lock()
+	f.resolvers[r] = struct{}{}
+	f.mu.Unlock()
+	if err := cc.UpdateState(resolver.State{Addresses: r.addrs}); err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// Scheme implements [resolver.Builder].
+func (f *failover) Scheme() string {
+	return failoverScheme
+}
+
+// dial dials a connection that resolves its addresses with the failover.
+func (f *failover) dial(opts []grpc.DialOption) (*grpc.ClientConn, error) {
+	target := fmt.Sprintf("%s:///%s", failoverScheme, f.addrs[0].Addr)
+	opts = append(opts[:len(opts):len(opts)], grpc.WithResolvers(f), grpc.WithTransportCredentials(f.creds))
+	return grpc.Dial(target, opts...)
+}
+
+// probe probes the primary host on the failover's interval, while any
+// connection is on a failover host, until it is closed. The connection the
+// primary host is probed with is kept between probes, and closed once
+// connections have moved back to it.
+func (f *failover) probe() {
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+
+	var probe *grpc.ClientConn
+	closeProbe := func() {
+		if err := probe.Close(); err != nil {
+			log.DefaultLogger.Error("Failed to close failover probe connection", "error", err)
+		}
+		probe = nil
+	}
+	defer func() {
+		if probe != nil {
+			closeProbe()
+		}
+	}()
+
+	for {
+		select {
+		case <-f.done:
+			return
+		case <-ticker.C:
+			if !f.failedOver() {
+				continue
+			}
+			if probe == nil {
+				var err error
+				probe, err = grpc.Dial(f.addrs[0].Addr, f.dialOptions...)
+				if err != nil {
+					log.DefaultLogger.Error("Failed to dial failover probe connection", "error", err)
+					continue
+				}
+			}
+			if primaryReachable(probe) {
+				f.preferPrimary()
+				closeProbe()
+			}
+		}
+	}
+}
+
+// failedOver reports whether any connection is on a failover host.
+func (f *failover) failedOver() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for r := range f.resolvers {
+		if r.failedOver() {
+			return true
+		}
+	}
+	return false
+}
+
+// primaryReachable reports whether the probe connection to the primary host
+// is ready, or becomes ready within failoverProbeTimeout.
+func primaryReachable(cc *grpc.ClientConn) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), failoverProbeTimeout)
+	defer cancel()
+
+	// A connection that failed is retried at once rather than after its
+	// backoff.
+	cc.ResetConnectBackoff()
+	cc.Connect()
+	for {
+		state := cc.GetState()
+		if state == connectivity.Ready {
+			return true
+		}
+		if !cc.WaitForStateChange(ctx, state) {
+			return false
+		}
+	}
+}
+
+// preferPrimary moves the connections on a failover host to the primary
+// host. Resolving to the primary host alone replaces the connection to the
+// failover host, and the failover hosts are then resolved again for the next
+// failure.
+func (f *failover) preferPrimary() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for r := range f.resolvers {
+		if !r.failedOver() {
+			continue
+		}
+		_ = r.cc.UpdateState(resolver.State{Addresses: r.addrs[:1]})
+		_ = r.cc.UpdateState(resolver.State{Addresses: r.addrs})
+	}
+}
+
+// Close stops probing the primary host.
+func (f *failover) Close() {
+	close(f.done)
+}
+
+// failoverResolver is the resolver of a connection dialed by a [failover].
+type failoverResolver struct {
+	failover *failover
+	cc       resolver.ClientConn
+	// addrs are the failover's addresses, tagged with their failoverHost.
+	addrs []resolver.Address
+	// host is the index of the host the connection last connected to,
+	// accessed atomically.
+	host int32
+}
+
+// failedOver reports whether the connection is on a failover host.
+func (r *failoverResolver) failedOver() bool {
+	return atomic.LoadInt32(&r.host) != 0
+}
+
+// ResolveNow implements [resolver.Resolver]. The addresses never change, so
+// there is nothing to do.
+func (r *failoverResolver) ResolveNow(resolver.ResolveNowOptions) {}
+
+// Close implements [resolver.Resolver].
+func (r *failoverResolver) Close() {
+	r.failover.mu.Lock()
+	defer r.failover.mu.Unlock()
+
+	delete(r.failover.resolvers, r)
+}
+
+// failoverHostKey is the key of the [failoverHost] attribute of the addresses
+// resolved by a [failoverResolver].
+type failoverHostKey struct{}
+
+// failoverHost identifies one of the hosts of a connection dialed by a
+// [failover].
+type failoverHost struct {
+	resolver *failoverResolver
+	index    int32
+}
+
+// failoverCredentials records the host each connection dialed by a
+// [failover] is on as its handshake with the host completes.
+type failoverCredentials struct {
+	credentials.TransportCredentials
+}
+
+// ClientHandshake implements [credentials.TransportCredentials].
+func (c failoverCredentials) ClientHandshake(ctx context.Context, authority string, conn net.Conn) (net.Conn, credentials.AuthInfo, error) {
+	conn, info, err := c.TransportCredentials.ClientHandshake(ctx, authority, conn)
+	if err != nil {
+		return nil, nil, err
+	}
+	attrs := credentials.ClientHandshakeInfoFromContext(ctx).Attributes
+	if host, ok := attrs.Value(failoverHostKey{}).(failoverHost); ok {
+		atomic.StoreInt32(&host.resolver.host, host.index)
+	}
+	return conn, info, nil
+}
+
+// Clone implements [credentials.TransportCredentials].
+func (c failoverCredentials) Clone() credentials.TransportCredentials {
+	return failoverCredentials{c.TransportCredentials.Clone()}
+}
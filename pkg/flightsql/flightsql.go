@@ -3,18 +3,26 @@ package flightsql
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"runtime/debug"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/apache/arrow/go/v12/arrow/flight/flightsql"
 	"github.com/go-chi/chi/v5"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/instancemgmt"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
 	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/encoding/gzip"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 )
 
 var (
@@ -25,27 +33,218 @@ var (
 )
 
 type config struct {
-	Addr     string              `json:"host"`
-	Metadata []map[string]string `json:"metadata"`
-	Secure   bool                `json:"secure"`
-	Username string              `json:"username"`
-	Password string              `json:"password"`
-	Token    string              `json:"token"`
+	Addr                 string              `json:"host"`
+	Metadata             []map[string]string `json:"metadata"`
+	Secure               bool                `json:"secure"`
+	Username             string              `json:"username"`
+	Password             string              `json:"password"`
+	Token                string              `json:"token"`
+	TokenFile            string              `json:"tokenFile"`
+	TokenEnvVar          string              `json:"tokenEnvVar"`
+	AuthType             string              `json:"selectedAuthType"`
+	AuthHeader           string              `json:"authHeader"`
+	AuthScheme           string              `json:"authScheme"`
+	TLSCACert            string              `json:"tlsCACert"`
+	InsecureSkipVerify   bool                `json:"insecureSkipVerify"`
+	TLSServerName        string              `json:"tlsServerName"`
+	Database             string              `json:"database"`
+	DatabaseKey          string              `json:"databaseKey"`
+	DatabasesQuery       string              `json:"databasesQuery"`
+	Catalog              string              `json:"catalog"`
+	CatalogKey           string              `json:"catalogKey"`
+	Schema               string              `json:"schema"`
+	SchemaKey            string              `json:"schemaKey"`
+	OAuthPassThru        bool                `json:"oauthPassThru"`
+	ForwardGrafanaUser   bool                `json:"forwardGrafanaUser"`
+	SecureSocksProxy     bool                `json:"enableSecureSocksProxy"`
+	ProxyURL             string              `json:"proxyUrl"`
+	Compression          string              `json:"compression"`
+	MaxRecvMsgSizeMB     int                 `json:"maxRecvMsgSizeMB"`
+	RoundRobin           bool                `json:"roundRobin"`
+	FailoverHosts        []string            `json:"failoverHosts"`
+	FailoverProbeSeconds int                 `json:"failoverProbeSeconds"`
+	ConnectionPoolSize   int                 `json:"connectionPoolSize"`
+	MaxFrameRows         int64               `json:"maxFrameRows"`
+	MaxRows              int64               `json:"maxRows"`
+	LimitMaxDataPoints   bool                `json:"limitMaxDataPoints"`
+	MaxQueryMemoryMB     int                 `json:"maxQueryMemoryMB"`
+	MaxConcurrentQueries int                 `json:"maxConcurrentQueries"`
+	QueryCacheTTLSeconds int                 `json:"queryCacheTTLSeconds"`
+	DecimalAsString      bool                `json:"decimalAsString"`
+	BinaryEncoding       string              `json:"binaryEncoding"`
+	ColumnRules          []columnRule        `json:"columnRules"`
+	HealthCheckQuery     string              `json:"healthCheckQuery"`
+	AdhocFilterTable     string              `json:"adhocFilterTable"`
+	QueryLogLevel        string              `json:"queryLogLevel"`
+	SlowQueryThresholdMs int                 `json:"slowQueryThresholdMs"`
+	AuditLog             bool                `json:"auditLog"`
+	DoGetRetries         int                 `json:"doGetRetries"`
+	BreakerFailures      int                 `json:"circuitBreakerFailures"`
+	BreakerCooldown      int                 `json:"circuitBreakerCooldownSeconds"`
+
+	// SecureMetadata holds metadata whose values are stored in
+	// secureJsonData under the "metadata." prefix.
+	SecureMetadata map[string]string `json:"-"`
 }
 
 func (cfg config) validate() error {
-	if strings.Count(cfg.Addr, ":") == 0 {
-		return fmt.Errorf(`server address must be in the form "host:port"`)
+	if err := validateAddr(cfg.Addr); err != nil {
+		return err
+	}
+
+	for _, host := range cfg.FailoverHosts {
+		// Failover hosts are resolved by the datasource rather than gRPC,
+		// so they can't be dial targets.
+		if _, _, err := net.SplitHostPort(host); err != nil || strings.Contains(host, "/") {
+			return fmt.Errorf(`failover hosts must be in the form "host:port": %s`, host)
+		}
+	}
+
+	if len(cfg.FailoverHosts) > 0 && (cfg.RoundRobin || strings.Contains(cfg.Addr, "/")) {
+		return fmt.Errorf(`failover hosts require the host to be in the form "host:port" without round robin`)
+	}
+
+	if cfg.FailoverProbeSeconds < 0 {
+		return fmt.Errorf("failover probe interval must not be negative")
+	}
+
+	if cfg.ConnectionPoolSize < 0 {
+		return fmt.Errorf("connection pool size must not be negative")
+	}
+
+	tokenSources := 0
+	for _, source := range []string{cfg.Token, cfg.TokenFile, cfg.TokenEnvVar} {
+		if source != "" {
+			tokenSources++
+		}
+	}
+	if tokenSources > 1 {
+		return fmt.Errorf("only one of a token, token file or token environment variable can be configured")
+	}
+
+	if cfg.TokenFile != "" {
+		if err := validateTokenFile(cfg.TokenFile); err != nil {
+			return err
+		}
+	}
+
+	if cfg.TokenEnvVar != "" {
+		if err := validateTokenEnvVar(cfg.TokenEnvVar); err != nil {
+			return err
+		}
+	}
+
+	noToken := tokenSources == 0
+
+	if err := validateAuthHeader(cfg.AuthHeader); err != nil {
+		return err
+	}
+
+	if strings.ContainsAny(cfg.AuthScheme, " \t") {
+		return fmt.Errorf("auth scheme must not contain spaces: %s", cfg.AuthScheme)
+	}
+
+	switch cfg.AuthType {
+	case "", authTypeNone, "token", "username/password":
+	default:
+		return fmt.Errorf("unsupported auth type: %s", cfg.AuthType)
 	}
 
-	noToken := len(cfg.Token) == 0
 	noUserPass := len(cfg.Username) == 0 || len(cfg.Password) == 0
 
-	// if not secure don't make users supply a token
-	if noToken && noUserPass && cfg.Secure {
+	// if not secure don't make users supply a token; when forwarding OAuth
+	// identity the token comes from the signed in user. Servers that don't
+	// authenticate clients can select no authentication explicitly.
+	if noToken && noUserPass && cfg.Secure && !cfg.OAuthPassThru && cfg.AuthType != authTypeNone {
 		return fmt.Errorf("token or username/password are required")
 	}
 
+	if cfg.TLSCACert != "" && !cfg.Secure {
+		return fmt.Errorf("a CA certificate requires TLS to be enabled")
+	}
+
+	if cfg.InsecureSkipVerify && !cfg.Secure {
+		return fmt.Errorf("skipping TLS verification requires TLS to be enabled")
+	}
+
+	if cfg.TLSServerName != "" && !cfg.Secure {
+		return fmt.Errorf("a TLS server name requires TLS to be enabled")
+	}
+
+	if cfg.ProxyURL != "" {
+		if _, err := parseProxyURL(cfg.ProxyURL); err != nil {
+			return err
+		}
+		if cfg.SecureSocksProxy {
+			return fmt.Errorf("a proxy URL can't be combined with the secure socks proxy")
+		}
+		if strings.HasPrefix(cfg.Addr, "unix") {
+			return fmt.Errorf("a proxy URL can't be used with unix sockets")
+		}
+	}
+
+	switch cfg.Compression {
+	case "", gzip.Name:
+	default:
+		return fmt.Errorf("unsupported compression: %s", cfg.Compression)
+	}
+
+	switch cfg.BinaryEncoding {
+	case "", "base64", "hex":
+	default:
+		return fmt.Errorf("unsupported binary encoding: %s", cfg.BinaryEncoding)
+	}
+
+	switch cfg.QueryLogLevel {
+	case "", "debug", "info", "warn", "error", "off":
+	default:
+		return fmt.Errorf("unsupported query log level: %s", cfg.QueryLogLevel)
+	}
+
+	if err := validateColumnRules(cfg.ColumnRules); err != nil {
+		return err
+	}
+
+	if cfg.MaxFrameRows < 0 {
+		return fmt.Errorf("max frame rows must not be negative")
+	}
+
+	if cfg.MaxRows < 0 {
+		return fmt.Errorf("max rows must not be negative")
+	}
+
+	if cfg.MaxQueryMemoryMB < 0 {
+		return fmt.Errorf("max query memory must not be negative")
+	}
+
+	if cfg.MaxConcurrentQueries < 0 {
+		return fmt.Errorf("max concurrent queries must not be negative")
+	}
+
+	if cfg.QueryCacheTTLSeconds < 0 {
+		return fmt.Errorf("query cache TTL must not be negative")
+	}
+
+	if cfg.MaxRecvMsgSizeMB < 0 {
+		return fmt.Errorf("max receive message size must not be negative")
+	}
+
+	if cfg.SlowQueryThresholdMs < 0 {
+		return fmt.Errorf("slow query threshold must not be negative")
+	}
+
+	if cfg.DoGetRetries < 0 {
+		return fmt.Errorf("DoGet retries must not be negative")
+	}
+
+	if cfg.BreakerFailures < 0 {
+		return fmt.Errorf("circuit breaker failures must not be negative")
+	}
+
+	if cfg.BreakerCooldown < 0 {
+		return fmt.Errorf("circuit breaker cool-down must not be negative")
+	}
+
 	return nil
 }
 
@@ -54,44 +253,74 @@ type FlightSQLDatasource struct {
 	client          *client
 	resourceHandler backend.CallResourceHandler
 	md              metadata.MD
+	cfg             config
+
+	// tokens provides the bearer token when it is read from a file or
+	// environment variable rather than stored in the settings.
+	tokens *tokenSource
+
+	// uid identifies the datasource in logs.
+	uid string
+
+	// querySlots limits the number of concurrently running queries when
+	// non-nil.
+	querySlots chan struct{}
+
+	// breaker fails queries fast while the server is unreachable when
+	// non-nil.
+	breaker *circuitBreaker
+
+	// instanceStats are rolling statistics about the datasource's queries.
+	instanceStats *instanceStats
+
+	// queryCache holds recent query results when non-nil.
+	queryCache *ttlCache[backend.DataResponse]
+
+	// metadataCache holds recent responses to metadata resource requests.
+	metadataCache *ttlCache[[]byte]
+
+	// adhocColumns holds the numeric columns of the ad hoc filter table.
+	adhocColumns *ttlCache[map[string]bool]
+
+	// streams holds the queries that can be run by RunStream, keyed by
+	// channel path, until they are run or expire unsubscribed.
+	streams *ttlCache[streamQuery]
+
+	// done is closed when the datasource is disposed, cancelling the
+	// queries and streams in flight.
+	done chan struct{}
+	// mu guards disposed, which stops queries from starting once the
+	// datasource is disposed, and the additions to inflight, which counts
+	// the queries in flight.
+	mu       sync.Mutex
+	disposed bool
+	inflight sync.WaitGroup
 }
 
 // NewDatasource creates a new datasource instance.
 func NewDatasource(settings backend.DataSourceInstanceSettings) (instancemgmt.Instance, error) {
-	var cfg config
-
-	err := json.Unmarshal(settings.JSONData, &cfg)
+	cfg, err := loadConfig(settings.JSONData, settings.DecryptedSecureJSONData)
 	if err != nil {
-		return nil, fmt.Errorf("config: %s", err)
-	}
-
-	if token, exists := settings.DecryptedSecureJSONData["token"]; exists {
-		cfg.Token = token
-	}
-
-	if password, exists := settings.DecryptedSecureJSONData["password"]; exists {
-		cfg.Password = password
+		return nil, err
 	}
 
 	if err := cfg.validate(); err != nil {
 		return nil, fmt.Errorf("config validation: %v", err)
 	}
 
+	tokens, err := newTokenSource(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("token: %s", err)
+	}
+
 	client, err := newFlightSQLClient(cfg)
 	if err != nil {
 		return nil, fmt.Errorf("flightsql: %s", err)
 	}
 
-	md := metadata.MD{}
-	for _, m := range cfg.Metadata {
-		for k, v := range m {
-			if _, ok := md[k]; ok {
-				return nil, fmt.Errorf("metadata: duplicate key: %s", k)
-			}
-			if k != "" {
-				md.Set(k, v)
-			}
-		}
+	md, err := newMetadata(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("metadata: %s", err)
 	}
 
 	ctx := context.Background()
@@ -105,33 +334,268 @@ func NewDatasource(settings backend.DataSourceInstanceSettings) (instancemgmt.In
 	}
 
 	if cfg.Token != "" {
-		md.Set("Authorization", fmt.Sprintf("Bearer %s", cfg.Token))
+		md.Set(cfg.authHeader(), cfg.credential(cfg.Token))
 	}
 
 	ds := &FlightSQLDatasource{
-		client: client,
-		md:     md,
+		client:        client,
+		md:            md,
+		tokens:        tokens,
+		cfg:           cfg,
+		uid:           settings.UID,
+		metadataCache: newTTLCache[[]byte](metadataCacheTTL, metadataCacheSize),
+		adhocColumns:  newTTLCache[map[string]bool](metadataCacheTTL, 1),
+		streams:       newTTLCache[streamQuery](streamTTL, maxStreams),
+		done:          make(chan struct{}),
+		instanceStats: newInstanceStats(),
+	}
+	activeDatasources.Inc()
+	if cfg.MaxConcurrentQueries > 0 {
+		ds.querySlots = make(chan struct{}, cfg.MaxConcurrentQueries)
+	}
+	if cfg.BreakerFailures > 0 {
+		cooldown := defaultCircuitBreakerCooldown
+		if cfg.BreakerCooldown > 0 {
+			cooldown = time.Duration(cfg.BreakerCooldown) * time.Second
+		}
+		ds.breaker = newCircuitBreaker(cfg.BreakerFailures, cooldown)
+	}
+	if cfg.QueryCacheTTLSeconds > 0 {
+		ttl := time.Duration(cfg.QueryCacheTTLSeconds) * time.Second
+		ds.queryCache = newTTLCache[backend.DataResponse](ttl, queryCacheSize)
 	}
 	r := chi.NewRouter()
 	r.Use(recoverer)
 	r.Route("/plugin", func(r chi.Router) {
 		r.Get("/macros", ds.getMacros)
+		r.Get("/stats", ds.getStats)
 	})
 	r.Route("/flightsql", func(r chi.Router) {
 		r.Get("/sql-info", ds.getSQLInfo)
-		r.Get("/tables", ds.getTables)
-		r.Get("/columns", ds.getColumns)
+		r.With(ds.cacheMetadata).Get("/sql-dialect", ds.getSQLDialect)
+		r.With(ds.cacheMetadata).Get("/tables", ds.getTables)
+		r.With(ds.cacheMetadata).Get("/columns", ds.getColumns)
+		r.With(ds.cacheMetadata).Get("/tag-keys", ds.getTagKeys)
+		r.With(ds.cacheMetadata).Get("/tag-values", ds.getTagValues)
+		r.With(ds.cacheMetadata).Get("/catalogs", ds.getCatalogs)
+		r.With(ds.cacheMetadata).Get("/databases", ds.getDatabases)
+		r.With(ds.cacheMetadata).Get("/schemas", ds.getSchemas)
+		r.With(ds.cacheMetadata).Get("/table-types", ds.getTableTypes)
+		r.With(ds.cacheMetadata).Get("/type-info", ds.getTypeInfo)
+		r.With(ds.cacheMetadata).Get("/primary-keys", ds.getPrimaryKeys)
+		r.With(ds.cacheMetadata).Get("/foreign-keys", ds.getForeignKeys)
+		r.Get("/preview", ds.getPreview)
+		r.Post("/validate-sql", ds.validateSQL)
+		r.Post("/validate-config", ds.validateConfig)
 	})
 	ds.resourceHandler = httpadapter.New(r)
 
 	return ds, nil
 }
 
-// Dispose cleans up before we are reaped.
+// validateAddr checks that addr is either a "host:port" pair or a gRPC dial
+// target using the unix, unix-abstract or dns schemes.
+func validateAddr(addr string) error {
+	switch {
+	case strings.HasPrefix(addr, "unix:"), strings.HasPrefix(addr, "unix-abstract:"):
+		_, path, _ := strings.Cut(addr, ":")
+		if strings.TrimPrefix(path, "//") == "" {
+			return fmt.Errorf("socket path is required: %s", addr)
+		}
+		return nil
+	case strings.HasPrefix(addr, "dns:"):
+		// dns:[//authority/]host:port
+		addr = strings.TrimPrefix(addr, "dns:")
+		if strings.HasPrefix(addr, "//") {
+			_, addr, _ = strings.Cut(strings.TrimPrefix(addr, "//"), "/")
+		}
+	}
+	if strings.Count(addr, ":") == 0 {
+		return fmt.Errorf(`server address must be in the form "host:port"`)
+	}
+	return nil
+}
+
+// loadConfig builds the config of a datasource from its settings, with its
+// secrets decrypted. The config isn't validated.
+func loadConfig(jsonData json.RawMessage, secureJSONData map[string]string) (config, error) {
+	var cfg config
+	if err := json.Unmarshal(jsonData, &cfg); err != nil {
+		return cfg, fmt.Errorf("config: %s", err)
+	}
+
+	if token, exists := secureJSONData["token"]; exists {
+		cfg.Token = strings.TrimSpace(token)
+	}
+
+	if password, exists := secureJSONData["password"]; exists {
+		cfg.Password = password
+	}
+
+	if caCert, exists := secureJSONData["tlsCACert"]; exists {
+		cfg.TLSCACert = caCert
+	}
+
+	for k, v := range secureJSONData {
+		if !strings.HasPrefix(k, secureMetadataPrefix) {
+			continue
+		}
+		if cfg.SecureMetadata == nil {
+			cfg.SecureMetadata = map[string]string{}
+		}
+		cfg.SecureMetadata[strings.TrimPrefix(k, secureMetadataPrefix)] = v
+	}
+
+	// Credentials left over from another auth type aren't sent.
+	if cfg.AuthType == authTypeNone {
+		cfg.Token, cfg.TokenFile, cfg.TokenEnvVar = "", "", ""
+		cfg.Username, cfg.Password = "", ""
+	}
+
+	return cfg, nil
+}
+
+// secureJSONData returns the secrets of the config in the form they are
+// stored in the datasource's settings.
+func (cfg config) secureJSONData() map[string]string {
+	secure := map[string]string{}
+	if cfg.Token != "" {
+		secure["token"] = cfg.Token
+	}
+	if cfg.Password != "" {
+		secure["password"] = cfg.Password
+	}
+	if cfg.TLSCACert != "" {
+		secure["tlsCACert"] = cfg.TLSCACert
+	}
+	for k, v := range cfg.SecureMetadata {
+		secure[secureMetadataPrefix+k] = v
+	}
+	return secure
+}
+
+// defaultDatabaseKey is the metadata key used to select the database when
+// none is configured.
+const defaultDatabaseKey = "bucket-name"
+
+// databaseKey returns the metadata key used to select the database.
+func (cfg config) databaseKey() string {
+	if cfg.DatabaseKey == "" {
+		return defaultDatabaseKey
+	}
+	return cfg.DatabaseKey
+}
+
+// defaultCatalogKey and defaultSchemaKey are the metadata keys used to set
+// the default catalog and schema when the datasource doesn't configure them.
+// The version of Flight SQL the plugin speaks has no session options, so
+// servers that resolve unqualified names against a default catalog or schema
+// accept them as metadata instead.
+const (
+	defaultCatalogKey = "catalog"
+	defaultSchemaKey  = "schema"
+)
+
+// catalogKey returns the metadata key used to set the default catalog.
+func (cfg config) catalogKey() string {
+	if cfg.CatalogKey == "" {
+		return defaultCatalogKey
+	}
+	return cfg.CatalogKey
+}
+
+// schemaKey returns the metadata key used to set the default schema.
+func (cfg config) schemaKey() string {
+	if cfg.SchemaKey == "" {
+		return defaultSchemaKey
+	}
+	return cfg.SchemaKey
+}
+
+// secureMetadataPrefix marks secureJsonData entries that are sent as metadata.
+const secureMetadataPrefix = "metadata."
+
+// newMetadata builds the metadata sent with every call from the configured
+// plain and secure key/value pairs.
+func newMetadata(cfg config) (metadata.MD, error) {
+	md := metadata.MD{}
+	set := func(k, v string) error {
+		if k == "" {
+			return nil
+		}
+		if len(md.Get(k)) != 0 {
+			return fmt.Errorf("duplicate key: %s", k)
+		}
+		md.Set(k, v)
+		return nil
+	}
+	for _, m := range cfg.Metadata {
+		for k, v := range m {
+			if err := set(k, v); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for k, v := range cfg.SecureMetadata {
+		if err := set(k, v); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Database != "" {
+		if err := set(cfg.databaseKey(), cfg.Database); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Catalog != "" {
+		if err := set(cfg.catalogKey(), cfg.Catalog); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Schema != "" {
+		if err := set(cfg.schemaKey(), cfg.Schema); err != nil {
+			return nil, err
+		}
+	}
+	return md, nil
+}
+
+// Dispose cleans up before we are reaped. Grafana disposes of the instance
+// whenever the datasource's settings change, so queries and streams in
+// flight are cancelled, and the prepared statements of queries closed, before
+// the client is closed. The version of Flight SQL the plugin speaks has no
+// sessions, so there is no session to close.
 func (d *FlightSQLDatasource) Dispose() {
+	d.mu.Lock()
+	if d.disposed {
+		d.mu.Unlock()
+		return
+	}
+	d.disposed = true
+	close(d.done)
+	d.mu.Unlock()
+	d.inflight.Wait()
+
+	activeDatasources.Dec()
 	if err := d.client.Close(); err != nil {
-		logErrorf(err.Error())
+		log.DefaultLogger.Error("Failed to close client", "error", err)
+	}
+}
+
+// errDisposed is returned by queries started after the datasource is
+// disposed.
+var errDisposed = errors.New("datasource is disposed")
+
+// startQuery records that a query is in flight until the returned function is
+// called, returning errDisposed instead if the datasource is disposed.
+func (d *FlightSQLDatasource) startQuery() (func(), error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.disposed {
+		return nil, errDisposed
 	}
+	d.inflight.Add(1)
+	return d.inflight.Done, nil
 }
 
 // CallResource forwards requests to an internal HTTP mux that handles custom
@@ -144,20 +608,64 @@ func (d *FlightSQLDatasource) CallResource(ctx context.Context, req *backend.Cal
 // The main use case for these health checks is the test button on the
 // datasource configuration page which allows users to verify that
 // a datasource is working as expected.
+//
+// Connectivity and authentication are checked by requesting the server's
+// SqlInfo, which doesn't depend on the SQL dialect or on a database being
+// selected. Servers that don't support GetSqlInfo are sent a query instead.
+// If a health check query is configured it is run instead, so that
+// permissions on the database can be checked too.
 func (d *FlightSQLDatasource) CheckHealth(ctx context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
-	query := sqlutil.Query{
-		RawSQL: "select 1",
-		Format: sqlutil.FormatOptionTable,
+	query := sqlQuery{
+		Query: sqlutil.Query{
+			RawSQL: "select 1",
+			Format: sqlutil.FormatOptionTable,
+		},
 	}
-	if resp := d.query(ctx, query); resp.Error != nil {
-		return &backend.CheckHealthResult{
-			Status:  backend.HealthStatusError,
-			Message: fmt.Sprintf("ERROR: %s", resp.Error),
-		}, nil
+	query.Metadata = d.forwardedMetadata(req.PluginContext, req.GetHTTPHeader)
+
+	start := time.Now()
+	details := healthDetails{
+		Check: "sqlInfo",
+		TLS:   d.cfg.Secure,
+		Auth:  d.cfg.authMode(),
+	}
+	if d.cfg.HealthCheckQuery != "" {
+		details.Check = "query"
+		query.RawSQL = d.cfg.HealthCheckQuery
+	} else {
+		infoCtx := ctx
+		if md := d.queryMetadata(query); md.Len() != 0 {
+			infoCtx = metadata.NewOutgoingContext(ctx, md)
+		}
+		var p peer.Peer
+		info, err := d.sqlInfo(infoCtx, nil, grpc.Peer(&p))
+		if err != nil {
+			log.DefaultLogger.Info("GetSqlInfo failed, checking health with a query", "error", err)
+			details.Check = "query"
+		} else {
+			details.ServerName, _ = info[flightsql.SqlInfoFlightSqlServerName].(string)
+			details.ServerVersion, _ = info[flightsql.SqlInfoFlightSqlServerVersion].(string)
+			details.setTLS(p.AuthInfo)
+		}
+	}
+	if details.Check == "query" {
+		if resp := d.query(ctx, query); resp.Error != nil {
+			return &backend.CheckHealthResult{
+				Status:  backend.HealthStatusError,
+				Message: fmt.Sprintf("ERROR: %s", resp.Error),
+			}, nil
+		}
+	}
+	details.LatencyMs = durationMilliseconds(time.Since(start))
+
+	jsonDetails, err := json.Marshal(details)
+	if err != nil {
+		return nil, err
 	}
 	return &backend.CheckHealthResult{
-		Status:  backend.HealthStatusOk,
-		Message: "OK",
+		Status:      backend.HealthStatusOk,
+		Message:     "OK",
+		JSONDetails: jsonDetails,
 	}, nil
 }
 
@@ -168,7 +676,7 @@ func recoverer(next http.Handler) http.Handler {
 				if rec == http.ErrAbortHandler {
 					panic(rec)
 				}
-				logErrorf("Panic: %s %s", rec, string(debug.Stack()))
+				log.DefaultLogger.Error("Panic", "error", rec, "stack", string(debug.Stack()))
 				w.WriteHeader(http.StatusInternalServerError)
 			}
 		}()
@@ -176,11 +684,3 @@ func recoverer(next http.Handler) http.Handler {
 	}
 	return http.HandlerFunc(fn)
 }
-
-func logInfof(format string, v ...any) {
-	log.DefaultLogger.Info(fmt.Sprintf(format, v...))
-}
-
-func logErrorf(format string, v ...any) {
-	log.DefaultLogger.Error(fmt.Sprintf(format, v...))
-}
@@ -3,45 +3,31 @@ package flightsql
 import (
 	"context"
 	"encoding/json"
+	"net"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/apache/arrow/go/v12/arrow/flight"
 	"github.com/apache/arrow/go/v12/arrow/flight/flightsql"
 	"github.com/apache/arrow/go/v12/arrow/flight/flightsql/example"
 	"github.com/apache/arrow/go/v12/arrow/memory"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
 )
 
 func TestIntegration_QueryData(t *testing.T) {
-	db, err := example.CreateDB()
-	require.NoError(t, err)
-	defer db.Close()
-
-	sqliteServer, err := example.NewSQLiteFlightSQLServer(db)
-	require.NoError(t, err)
-	sqliteServer.Alloc = memory.NewCheckedAllocator(memory.DefaultAllocator)
-	server := flight.NewServerWithMiddleware(nil)
-	server.RegisterFlightService(flightsql.NewFlightServer(sqliteServer))
-	err = server.Init("localhost:0")
-	require.NoError(t, err)
-	go server.Serve()
-	defer server.Shutdown()
+	ds := newIntegrationDatasource(t)
 
-	cfg := config{
-		Addr:   server.Addr().String(),
-		Token:  "secret",
-		Secure: false,
-	}
-	cfgJSON, err := json.Marshal(cfg)
-	require.NoError(t, err)
-
-	settings := backend.DataSourceInstanceSettings{JSONData: cfgJSON}
-	ds, err := NewDatasource(settings)
-	require.NoError(t, err)
-
-	resp, err := ds.(*FlightSQLDatasource).QueryData(context.Background(),
+	resp, err := ds.QueryData(context.Background(),
 		&backend.QueryDataRequest{
 			Queries: []backend.DataQuery{
 				{
@@ -69,6 +55,487 @@ func TestIntegration_QueryData(t *testing.T) {
 	for _, f := range frame.Fields {
 		assert.Equal(t, 4, f.Len())
 	}
+
+	timings, ok := frame.Meta.Custom.(map[string]any)["timings"].(queryTimings)
+	require.True(t, ok)
+	require.Greater(t, timings.Execute, float64(0))
+	require.Greater(t, timings.DoGet, float64(0))
+	require.Contains(t, frame.Meta.Custom, "headers")
+}
+
+func TestIntegration_QueryData_ColumnRules(t *testing.T) {
+	ds := newIntegrationDatasource(t)
+	ds.cfg.ColumnRules = []columnRule{
+		{Column: "value", DisplayName: "Value", Unit: "bytes"},
+		{Column: "*Id", Decimals: ptr(uint16(0))},
+	}
+
+	resp, err := ds.QueryData(context.Background(),
+		&backend.QueryDataRequest{
+			Queries: []backend.DataQuery{{
+				RefID: "A",
+				JSON: []byte(`{"refId": "A", "queryText": "select * from intTable", "format": "table",
+					"columnRules": [{"column": "value", "unit": "decbytes"}]}`),
+			}},
+		},
+	)
+	require.NoError(t, err)
+	respA := resp.Responses["A"]
+	require.NoError(t, respA.Error)
+	fields := respA.Frames[0].Fields
+
+	require.Equal(t, "value", fields[2].Name)
+	// The query's rules take precedence over the datasource's.
+	require.Equal(t, &data.FieldConfig{DisplayNameFromDS: "Value", Unit: "decbytes"}, fields[2].Config)
+	require.Equal(t, &data.FieldConfig{Decimals: ptr(uint16(0))}, fields[3].Config)
+	require.Nil(t, fields[0].Config)
+}
+
+func TestIntegration_QueryData_Parameters(t *testing.T) {
+	ds := newIntegrationDatasource(t)
+
+	resp, err := ds.QueryData(context.Background(),
+		&backend.QueryDataRequest{
+			Queries: []backend.DataQuery{
+				{
+					RefID: "A",
+					JSON:  []byte(`{"refId": "A", "format": "table", "queryText": "select * from intTable where keyName = ?", "parameters": ["one"]}`),
+				},
+			},
+		},
+	)
+	require.NoError(t, err)
+
+	respA := resp.Responses["A"]
+	require.NoError(t, respA.Error)
+	require.Len(t, respA.Frames, 1)
+	require.Equal(t, 1, respA.Frames[0].Rows())
+	require.Equal(t, "one", *respA.Frames[0].Fields[1].At(0).(*string))
+
+	stats := map[string]float64{}
+	for _, stat := range respA.Frames[0].Meta.Stats {
+		stats[stat.DisplayName] = stat.Value
+	}
+	require.Equal(t, float64(1), stats["Rows"])
+	require.Equal(t, float64(4), stats["Server round trips"])
+	require.Contains(t, stats, "Execution time")
+	require.Contains(t, stats, "Fetch time")
+}
+
+func TestIntegration_QueryData_Statement(t *testing.T) {
+	ds := newIntegrationDatasource(t)
+	// Statements aren't limited, which SQLite would reject.
+	ds.cfg.LimitMaxDataPoints = true
+
+	// Queries in a request run concurrently, so each statement is sent in
+	// its own request.
+	for _, c := range []struct {
+		json     string
+		affected int64
+	}{
+		{`{"refId": "A", "format": "table", "statement": true, "queryText": "update intTable set value = 0 where keyName = ?", "parameters": ["one"]}`, 1},
+		{`{"refId": "A", "format": "table", "statement": true, "maxDataPoints": 100, "queryText": "delete from intTable"}`, 4},
+	} {
+		resp, err := ds.QueryData(context.Background(),
+			&backend.QueryDataRequest{
+				Queries: []backend.DataQuery{{RefID: "A", JSON: []byte(c.json)}},
+			},
+		)
+		require.NoError(t, err)
+
+		respA := resp.Responses["A"]
+		require.NoError(t, respA.Error)
+		require.Equal(t, "affected_rows", respA.Frames[0].Fields[0].Name)
+		require.Equal(t, c.affected, respA.Frames[0].Fields[0].At(0))
+	}
+}
+
+// flakyServer fails the first failures calls to DoGet.
+type flakyServer struct {
+	flight.FlightServer
+	failures int32
+}
+
+func (s *flakyServer) DoGet(ticket *flight.Ticket, stream flight.FlightService_DoGetServer) error {
+	if atomic.AddInt32(&s.failures, -1) >= 0 {
+		return status.Error(codes.Unavailable, "connection reset")
+	}
+	return s.FlightServer.DoGet(ticket, stream)
+}
+
+// blockingServer blocks calls to DoGet until they are cancelled, signalling
+// blocked when they start.
+type blockingServer struct {
+	flight.FlightServer
+	blocked chan struct{}
+}
+
+func (s *blockingServer) DoGet(ticket *flight.Ticket, stream flight.FlightService_DoGetServer) error {
+	s.blocked <- struct{}{}
+	<-stream.Context().Done()
+	return stream.Context().Err()
+}
+
+func TestIntegration_Dispose(t *testing.T) {
+	server := &blockingServer{blocked: make(chan struct{}, 1)}
+	ds := newWrappedIntegrationDatasource(t, func(s flight.FlightServer) flight.FlightServer {
+		server.FlightServer = s
+		return server
+	})
+
+	query := func() backend.DataResponse {
+		resp, err := ds.QueryData(context.Background(),
+			&backend.QueryDataRequest{
+				Queries: []backend.DataQuery{{RefID: "A", JSON: mustQueryJSON(t, "A", "select * from intTable")}},
+			},
+		)
+		require.NoError(t, err)
+		return resp.Responses["A"]
+	}
+
+	// Queries in flight are cancelled.
+	resps := make(chan backend.DataResponse, 1)
+	go func() { resps <- query() }()
+	<-server.blocked
+	ds.Dispose()
+	select {
+	case resp := <-resps:
+		require.Error(t, resp.Error)
+	case <-time.After(5 * time.Second):
+		t.Fatal("query in flight wasn't cancelled")
+	}
+
+	require.EqualError(t, query().Error, errDisposed.Error())
+	ds.Dispose()
+}
+
+func TestIntegration_QueryData_DoGetRetries(t *testing.T) {
+	server := &flakyServer{failures: 2}
+	ds := newWrappedIntegrationDatasource(t, func(s flight.FlightServer) flight.FlightServer {
+		server.FlightServer = s
+		return server
+	})
+
+	query := func() backend.DataResponse {
+		resp, err := ds.QueryData(context.Background(),
+			&backend.QueryDataRequest{
+				Queries: []backend.DataQuery{{RefID: "A", JSON: mustQueryJSON(t, "A", "select * from intTable")}},
+			},
+		)
+		require.NoError(t, err)
+		return resp.Responses["A"]
+	}
+
+	resp := query()
+	require.Error(t, resp.Error)
+	require.Contains(t, resp.Error.Error(), "connection reset")
+
+	atomic.StoreInt32(&server.failures, 2)
+	ds.cfg.DoGetRetries = 2
+	resp = query()
+	require.NoError(t, resp.Error)
+	require.Equal(t, 4, resp.Frames[0].Rows())
+	stats := map[string]float64{}
+	for _, stat := range resp.Frames[0].Meta.Stats {
+		stats[stat.DisplayName] = stat.Value
+	}
+	require.Equal(t, float64(6), stats["Server round trips"])
+}
+
+func TestIntegration_QueryData_Metrics(t *testing.T) {
+	ds := newIntegrationDatasource(t)
+	ok := testutil.ToFloat64(queriesTotal.WithLabelValues("ok"))
+	failed := testutil.ToFloat64(queriesTotal.WithLabelValues("error"))
+	unknown := testutil.ToFloat64(grpcErrorsTotal.WithLabelValues(codes.Unknown.String()))
+
+	resp, err := ds.QueryData(context.Background(),
+		&backend.QueryDataRequest{
+			Queries: []backend.DataQuery{
+				{RefID: "A", JSON: mustQueryJSON(t, "A", "select * from intTable")},
+				{RefID: "B", JSON: mustQueryJSON(t, "B", "select * from missingTable")},
+			},
+		},
+	)
+	require.NoError(t, err)
+	require.NoError(t, resp.Responses["A"].Error)
+	require.Error(t, resp.Responses["B"].Error)
+
+	require.Equal(t, ok+1, testutil.ToFloat64(queriesTotal.WithLabelValues("ok")))
+	require.Equal(t, failed+1, testutil.ToFloat64(queriesTotal.WithLabelValues("error")))
+	require.Equal(t, unknown+1, testutil.ToFloat64(grpcErrorsTotal.WithLabelValues(codes.Unknown.String())))
+	require.GreaterOrEqual(t, testutil.ToFloat64(activeDatasources), float64(1))
+}
+
+func TestIntegration_CheckHealth(t *testing.T) {
+	ds := newIntegrationDatasource(t)
+
+	res, err := ds.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+	require.NoError(t, err)
+	require.Equal(t, backend.HealthStatusOk, res.Status)
+
+	var details healthDetails
+	require.NoError(t, json.Unmarshal(res.JSONDetails, &details))
+	require.Equal(t, "sqlInfo", details.Check)
+	require.NotEmpty(t, details.ServerName)
+	require.False(t, details.TLS)
+	require.Equal(t, "token", details.Auth)
+
+	ds.cfg.HealthCheckQuery = "select * from intTable limit 1"
+	res, err = ds.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+	require.NoError(t, err)
+	require.Equal(t, backend.HealthStatusOk, res.Status)
+
+	ds.cfg.HealthCheckQuery = "select * from missingTable"
+	res, err = ds.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+	require.NoError(t, err)
+	require.Equal(t, backend.HealthStatusError, res.Status)
+}
+
+func TestCheckHealth_Unreachable(t *testing.T) {
+	cfgJSON, err := json.Marshal(config{Addr: "localhost:1"})
+	require.NoError(t, err)
+	ds, err := NewDatasource(backend.DataSourceInstanceSettings{JSONData: cfgJSON})
+	require.NoError(t, err)
+	t.Cleanup(ds.(*FlightSQLDatasource).Dispose)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	res, err := ds.(*FlightSQLDatasource).CheckHealth(ctx, &backend.CheckHealthRequest{})
+	require.NoError(t, err)
+	require.Equal(t, backend.HealthStatusError, res.Status)
+}
+
+// countingServer counts the calls to GetFlightInfo.
+type countingServer struct {
+	flight.FlightServer
+	calls int32
+}
+
+func (s *countingServer) GetFlightInfo(ctx context.Context, desc *flight.FlightDescriptor) (*flight.FlightInfo, error) {
+	atomic.AddInt32(&s.calls, 1)
+	return s.FlightServer.GetFlightInfo(ctx, desc)
+}
+
+func TestIntegration_QueryData_Failover(t *testing.T) {
+	// The primary host is down to begin with.
+	l, err := net.Listen("tcp", "localhost:0")
+	require.NoError(t, err)
+	primaryAddr := l.Addr().String()
+	require.NoError(t, l.Close())
+
+	primary, secondary := &countingServer{}, &countingServer{}
+	secondaryAddr := newIntegrationServer(t, "localhost:0", func(s flight.FlightServer) flight.FlightServer {
+		secondary.FlightServer = s
+		return secondary
+	})
+
+	cfgJSON, err := json.Marshal(config{
+		Addr:                 primaryAddr,
+		FailoverHosts:        []string{secondaryAddr},
+		FailoverProbeSeconds: 1,
+	})
+	require.NoError(t, err)
+	inst, err := NewDatasource(backend.DataSourceInstanceSettings{JSONData: cfgJSON})
+	require.NoError(t, err)
+	ds := inst.(*FlightSQLDatasource)
+	t.Cleanup(ds.Dispose)
+
+	query := func() {
+		resp, err := ds.QueryData(context.Background(),
+			&backend.QueryDataRequest{
+				Queries: []backend.DataQuery{{RefID: "A", JSON: mustQueryJSON(t, "A", "select 1")}},
+			},
+		)
+		require.NoError(t, err)
+		require.NoError(t, resp.Responses["A"].Error)
+	}
+	query()
+	require.Equal(t, int32(1), atomic.LoadInt32(&secondary.calls))
+	require.True(t, ds.client.failover.failedOver())
+
+	// Once the primary host recovers, queries move back to it. The example
+	// database can only be opened once, so both hosts share it.
+	primary.FlightServer = secondary.FlightServer
+	server := flight.NewServerWithMiddleware(nil)
+	server.RegisterFlightService(primary)
+	require.NoError(t, server.Init(primaryAddr))
+	go server.Serve()
+	t.Cleanup(server.Shutdown)
+	require.Eventually(t, func() bool {
+		query()
+		return atomic.LoadInt32(&primary.calls) > 0
+	}, 10*time.Second, 100*time.Millisecond)
+	// The primary host is no longer probed.
+	require.False(t, ds.client.failover.failedOver())
+}
+
+// peerServer records the addresses that GetFlightInfo is called from.
+type peerServer struct {
+	flight.FlightServer
+	peers sync.Map
+}
+
+func (s *peerServer) GetFlightInfo(ctx context.Context, desc *flight.FlightDescriptor) (*flight.FlightInfo, error) {
+	if p, ok := peer.FromContext(ctx); ok {
+		s.peers.Store(p.Addr.String(), true)
+	}
+	return s.FlightServer.GetFlightInfo(ctx, desc)
+}
+
+func TestIntegration_QueryData_ConnectionPool(t *testing.T) {
+	server := &peerServer{}
+	addr := newIntegrationServer(t, "localhost:0", func(s flight.FlightServer) flight.FlightServer {
+		server.FlightServer = s
+		return server
+	})
+	cfgJSON, err := json.Marshal(config{Addr: addr, ConnectionPoolSize: 3})
+	require.NoError(t, err)
+	inst, err := NewDatasource(backend.DataSourceInstanceSettings{JSONData: cfgJSON})
+	require.NoError(t, err)
+	ds := inst.(*FlightSQLDatasource)
+	t.Cleanup(ds.Dispose)
+
+	for i := 0; i < 3; i++ {
+		resp, err := ds.QueryData(context.Background(),
+			&backend.QueryDataRequest{
+				Queries: []backend.DataQuery{{RefID: "A", JSON: mustQueryJSON(t, "A", "select 1")}},
+			},
+		)
+		require.NoError(t, err)
+		require.NoError(t, resp.Responses["A"].Error)
+	}
+
+	var peers int
+	server.peers.Range(func(_, _ any) bool {
+		peers++
+		return true
+	})
+	require.Equal(t, 3, peers)
+}
+
+func TestConfigValidate_FailoverHosts(t *testing.T) {
+	cfg := config{Addr: "localhost:1234", FailoverHosts: []string{"localhost:1235"}}
+	require.NoError(t, cfg.validate())
+
+	cfg.FailoverHosts = []string{"dns:///localhost:1235"}
+	require.Error(t, cfg.validate())
+
+	cfg.FailoverHosts = []string{"localhost"}
+	require.Error(t, cfg.validate())
+
+	cfg = config{Addr: "localhost:1234", FailoverHosts: []string{"localhost:1235"}, RoundRobin: true}
+	require.Error(t, cfg.validate())
+}
+
+func TestIntegration_QueryData_Proxy(t *testing.T) {
+	addr := newIntegrationServer(t, "localhost:0", func(s flight.FlightServer) flight.FlightServer { return s })
+	proxy := newTestConnectProxy(t, "")
+	cfgJSON, err := json.Marshal(config{Addr: addr, ProxyURL: "http://" + proxy.addr})
+	require.NoError(t, err)
+	inst, err := NewDatasource(backend.DataSourceInstanceSettings{JSONData: cfgJSON})
+	require.NoError(t, err)
+	ds := inst.(*FlightSQLDatasource)
+	t.Cleanup(ds.Dispose)
+
+	resp, err := ds.QueryData(context.Background(),
+		&backend.QueryDataRequest{
+			Queries: []backend.DataQuery{{RefID: "A", JSON: mustQueryJSON(t, "A", "select 1")}},
+		},
+	)
+	require.NoError(t, err)
+	require.NoError(t, resp.Responses["A"].Error)
+	require.Contains(t, proxy.targets(), addr)
+}
+
+func TestConfigValidate_ProxyURL(t *testing.T) {
+	cfg := config{Addr: "localhost:1234", ProxyURL: "http://proxy.internal:3128"}
+	require.NoError(t, cfg.validate())
+
+	cfg.ProxyURL = "socks5://proxy.internal:1080"
+	require.Error(t, cfg.validate())
+
+	cfg = config{Addr: "localhost:1234", ProxyURL: "http://proxy.internal:3128", SecureSocksProxy: true}
+	require.Error(t, cfg.validate())
+
+	cfg = config{Addr: "unix:///tmp/fsql.sock", ProxyURL: "http://proxy.internal:3128"}
+	require.Error(t, cfg.validate())
+}
+
+func TestQueryData_CircuitBreaker(t *testing.T) {
+	cfgJSON, err := json.Marshal(config{Addr: "localhost:1", BreakerFailures: 1})
+	require.NoError(t, err)
+	inst, err := NewDatasource(backend.DataSourceInstanceSettings{JSONData: cfgJSON})
+	require.NoError(t, err)
+	ds := inst.(*FlightSQLDatasource)
+	t.Cleanup(ds.Dispose)
+
+	query := func() error {
+		resp, err := ds.QueryData(context.Background(),
+			&backend.QueryDataRequest{
+				Queries: []backend.DataQuery{{RefID: "A", JSON: mustQueryJSON(t, "A", "select 1")}},
+			},
+		)
+		require.NoError(t, err)
+		return resp.Responses["A"].Error
+	}
+	require.ErrorContains(t, query(), "connection refused")
+	require.ErrorContains(t, query(), "server unreachable since")
+
+	// Health checks still try to reach the server.
+	res, err := ds.CheckHealth(context.Background(), &backend.CheckHealthRequest{})
+	require.NoError(t, err)
+	require.NotContains(t, res.Message, "server unreachable since")
+}
+
+// newIntegrationDatasource returns a datasource connected to an in-process
+// Flight SQL server backed by an example SQLite database.
+func newIntegrationDatasource(t *testing.T) *FlightSQLDatasource {
+	t.Helper()
+	return newWrappedIntegrationDatasource(t, func(s flight.FlightServer) flight.FlightServer { return s })
+}
+
+// newWrappedIntegrationDatasource is like newIntegrationDatasource, but the
+// server is wrapped so tests can alter its behaviour.
+func newWrappedIntegrationDatasource(t *testing.T, wrap func(flight.FlightServer) flight.FlightServer) *FlightSQLDatasource {
+	t.Helper()
+
+	cfg := config{
+		Addr:   newIntegrationServer(t, "localhost:0", wrap),
+		Token:  "secret",
+		Secure: false,
+	}
+	cfgJSON, err := json.Marshal(cfg)
+	require.NoError(t, err)
+
+	settings := backend.DataSourceInstanceSettings{JSONData: cfgJSON}
+	ds, err := NewDatasource(settings)
+	require.NoError(t, err)
+	t.Cleanup(ds.(*FlightSQLDatasource).Dispose)
+
+	return ds.(*FlightSQLDatasource)
+}
+
+// newIntegrationServer starts an in-process Flight SQL server backed by an
+// example SQLite database listening on addr, and returns the address it
+// listens on.
+func newIntegrationServer(t *testing.T, addr string, wrap func(flight.FlightServer) flight.FlightServer) string {
+	t.Helper()
+
+	db, err := example.CreateDB()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	sqliteServer, err := example.NewSQLiteFlightSQLServer(db)
+	require.NoError(t, err)
+	sqliteServer.Alloc = memory.NewCheckedAllocator(memory.DefaultAllocator)
+	server := flight.NewServerWithMiddleware(nil)
+	server.RegisterFlightService(wrap(flightsql.NewFlightServer(sqliteServer)))
+	err = server.Init(addr)
+	require.NoError(t, err)
+	go server.Serve()
+	t.Cleanup(server.Shutdown)
+
+	return server.Addr().String()
 }
 
 func mustQueryJSON(t *testing.T, refID, sql string) []byte {
@@ -84,3 +551,229 @@ func mustQueryJSON(t *testing.T, refID, sql string) []byte {
 	}
 	return b
 }
+
+func TestNewMetadata(t *testing.T) {
+	md, err := newMetadata(config{
+		Metadata: []map[string]string{
+			{"bucket-name": "telegraf"},
+			{"": "ignored"},
+		},
+		SecureMetadata: map[string]string{
+			"x-api-key": "secret",
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, metadata.Pairs(
+		"bucket-name", "telegraf",
+		"x-api-key", "secret",
+	), md)
+
+	_, err = newMetadata(config{
+		Metadata: []map[string]string{
+			{"x-api-key": "plain"},
+		},
+		SecureMetadata: map[string]string{
+			"X-Api-Key": "secret",
+		},
+	})
+	require.Error(t, err)
+}
+
+func TestNewMetadata_Database(t *testing.T) {
+	md, err := newMetadata(config{Database: "telegraf"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"telegraf"}, md.Get("bucket-name"))
+
+	md, err = newMetadata(config{Database: "telegraf", DatabaseKey: "database"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"telegraf"}, md.Get("database"))
+	require.Empty(t, md.Get("bucket-name"))
+
+	md, err = newMetadata(config{DatabaseKey: "database"})
+	require.NoError(t, err)
+	require.Empty(t, md.Get("database"))
+}
+
+func TestNewMetadata_CatalogSchema(t *testing.T) {
+	md, err := newMetadata(config{Catalog: "iceberg", Schema: "sales"})
+	require.NoError(t, err)
+	require.Equal(t, metadata.Pairs("catalog", "iceberg", "schema", "sales"), md)
+
+	md, err = newMetadata(config{Schema: "sales", SchemaKey: "x-default-schema"})
+	require.NoError(t, err)
+	require.Equal(t, metadata.Pairs("x-default-schema", "sales"), md)
+
+	_, err = newMetadata(config{Schema: "sales", Metadata: []map[string]string{{"schema": "other"}}})
+	require.Error(t, err)
+}
+
+func TestQueryMetadata(t *testing.T) {
+	ds := &FlightSQLDatasource{
+		md:  metadata.Pairs("bucket-name", "default", "x-tenant", "a"),
+		cfg: config{Database: "default"},
+	}
+
+	md := ds.queryMetadata(sqlQuery{})
+	require.Equal(t, []string{"default"}, md.Get("bucket-name"))
+
+	md = ds.queryMetadata(sqlQuery{Database: "other"})
+	require.Equal(t, []string{"other"}, md.Get("bucket-name"))
+	require.Equal(t, []string{"a"}, md.Get("x-tenant"))
+	require.Equal(t, []string{"default"}, ds.md.Get("bucket-name"))
+}
+
+func TestForwardedMetadata(t *testing.T) {
+	header := func(k string) string {
+		return map[string]string{"Authorization": "Bearer user-token"}[k]
+	}
+
+	pCtx := backend.PluginContext{
+		OrgID: 2,
+		User:  &backend.User{Login: "jane"},
+	}
+
+	ds := &FlightSQLDatasource{md: metadata.Pairs("authorization", "Bearer static")}
+	require.Equal(t, 0, ds.forwardedMetadata(pCtx, header).Len())
+
+	ds.cfg.OAuthPassThru = true
+	md := ds.queryMetadata(sqlQuery{Metadata: ds.forwardedMetadata(pCtx, header)})
+	require.Equal(t, []string{"Bearer user-token"}, md.Get("authorization"))
+	require.Empty(t, md.Get("x-grafana-user"))
+
+	// The static credential isn't sent alongside the user's token when it
+	// goes under another key.
+	ds = &FlightSQLDatasource{
+		md:  metadata.Pairs("x-api-key", "static"),
+		cfg: config{AuthHeader: "x-api-key", OAuthPassThru: true},
+	}
+	md = ds.queryMetadata(sqlQuery{Metadata: ds.forwardedMetadata(pCtx, header)})
+	require.Equal(t, []string{"Bearer user-token"}, md.Get("authorization"))
+	require.Empty(t, md.Get("x-api-key"))
+	require.Equal(t, []string{"static"}, ds.md.Get("x-api-key"))
+
+	// Without a forwarded token, the static credential is sent.
+	md = ds.queryMetadata(sqlQuery{Metadata: metadata.Pairs("x-priority", "low")})
+	require.Equal(t, []string{"static"}, md.Get("x-api-key"))
+	require.Empty(t, md.Get("authorization"))
+
+	ds.cfg.ForwardGrafanaUser = true
+	md = ds.forwardedMetadata(pCtx, header)
+	require.Equal(t, []string{"jane"}, md.Get("x-grafana-user"))
+	require.Equal(t, []string{"2"}, md.Get("x-grafana-org-id"))
+}
+
+func TestNewQueryOrigin(t *testing.T) {
+	header := func(k string) string {
+		return map[string]string{"X-Dashboard-Uid": "dash", "X-Panel-Id": "4"}[k]
+	}
+	pCtx := backend.PluginContext{
+		OrgID: 2,
+		User:  &backend.User{Login: "jane"},
+	}
+	require.Equal(t, queryOrigin{
+		User:         "jane",
+		OrgID:        2,
+		DashboardUID: "dash",
+		PanelID:      "4",
+	}, newQueryOrigin(pCtx, header))
+
+	require.Equal(t, queryOrigin{}, newQueryOrigin(backend.PluginContext{}, func(string) string { return "" }))
+}
+
+func TestConfigValidate_Compression(t *testing.T) {
+	cfg := config{Addr: "localhost:1234", Compression: "gzip"}
+	require.NoError(t, cfg.validate())
+
+	cfg.Compression = "lz4"
+	require.Error(t, cfg.validate())
+}
+
+func TestConfigValidate_BinaryEncoding(t *testing.T) {
+	cfg := config{Addr: "localhost:1234", BinaryEncoding: "hex"}
+	require.NoError(t, cfg.validate())
+
+	cfg.BinaryEncoding = "base32"
+	require.Error(t, cfg.validate())
+}
+
+func TestConfigValidate_QueryLogLevel(t *testing.T) {
+	cfg := config{Addr: "localhost:1234", QueryLogLevel: "off"}
+	require.NoError(t, cfg.validate())
+
+	cfg.QueryLogLevel = "trace"
+	require.Error(t, cfg.validate())
+}
+
+func TestConfigValidate_SlowQueryThreshold(t *testing.T) {
+	cfg := config{Addr: "localhost:1234", SlowQueryThresholdMs: 5000}
+	require.NoError(t, cfg.validate())
+
+	cfg.SlowQueryThresholdMs = -1
+	require.Error(t, cfg.validate())
+}
+
+func TestConfigValidate_TokenSources(t *testing.T) {
+	t.Setenv(tokenDirEnvVar, "/var/run/secrets")
+	t.Setenv(tokenEnvPrefixEnvVar, "FLIGHTSQL_")
+	cfg := config{Addr: "localhost:1234", Secure: true, TokenFile: "/var/run/secrets/token"}
+	require.NoError(t, cfg.validate())
+
+	cfg = config{Addr: "localhost:1234", Secure: true, TokenEnvVar: "FLIGHTSQL_TOKEN"}
+	require.NoError(t, cfg.validate())
+
+	cfg.Token = "secret"
+	require.Error(t, cfg.validate())
+
+	cfg = config{Addr: "localhost:1234", Secure: true, TokenFile: "/etc/passwd"}
+	require.Error(t, cfg.validate())
+
+	cfg = config{Addr: "localhost:1234", Secure: true, TokenEnvVar: "GF_DATABASE_PASSWORD"}
+	require.Error(t, cfg.validate())
+}
+
+func TestNewDatasource_NoAuth(t *testing.T) {
+	cfgJSON, err := json.Marshal(config{Addr: "localhost:1234", Secure: true, AuthType: authTypeNone})
+	require.NoError(t, err)
+	inst, err := NewDatasource(backend.DataSourceInstanceSettings{
+		JSONData:                cfgJSON,
+		DecryptedSecureJSONData: map[string]string{"token": "stale"},
+	})
+	require.NoError(t, err)
+	ds := inst.(*FlightSQLDatasource)
+	t.Cleanup(ds.Dispose)
+	require.Empty(t, ds.md.Get("authorization"))
+
+	// A blank token isn't sent as an empty bearer token.
+	cfgJSON, err = json.Marshal(config{Addr: "localhost:1234"})
+	require.NoError(t, err)
+	inst, err = NewDatasource(backend.DataSourceInstanceSettings{
+		JSONData:                cfgJSON,
+		DecryptedSecureJSONData: map[string]string{"token": " "},
+	})
+	require.NoError(t, err)
+	ds = inst.(*FlightSQLDatasource)
+	t.Cleanup(ds.Dispose)
+	require.Empty(t, ds.md.Get("authorization"))
+}
+
+func TestValidateAddr(t *testing.T) {
+	for _, addr := range []string{
+		"localhost:1234",
+		"10.0.0.1:443",
+		"unix:///var/run/flightsql.sock",
+		"unix:relative.sock",
+		"unix-abstract:flightsql",
+		"dns:///flightsql.default.svc:443",
+		"dns://8.8.8.8/flightsql.example.com:443",
+	} {
+		require.NoError(t, validateAddr(addr), addr)
+	}
+
+	for _, addr := range []string{
+		"localhost",
+		"unix://",
+		"dns:///flightsql.default.svc",
+	} {
+		require.Error(t, validateAddr(addr), addr)
+	}
+}
@@ -0,0 +1,60 @@
+package flightsql
+
+import (
+	"crypto/tls"
+
+	"google.golang.org/grpc/credentials"
+)
+
+// healthDetails are the details of a successful health check, reported to
+// help debug datasources whose health check passes but whose queries fail.
+type healthDetails struct {
+	// Check is how health was checked, either "sqlInfo" or "query".
+	Check         string `json:"check"`
+	ServerName    string `json:"serverName,omitempty"`
+	ServerVersion string `json:"serverVersion,omitempty"`
+	TLS           bool   `json:"tls"`
+	// TLSVersion and TLSCipherSuite are those negotiated with the server
+	// when it was checked with GetSqlInfo.
+	TLSVersion     string  `json:"tlsVersion,omitempty"`
+	TLSCipherSuite string  `json:"tlsCipherSuite,omitempty"`
+	Auth           string  `json:"auth"`
+	LatencyMs      float64 `json:"latencyMs"`
+}
+
+// tlsVersions names the versions of TLS.
+var tlsVersions = map[uint16]string{
+	tls.VersionTLS10: "TLS 1.0",
+	tls.VersionTLS11: "TLS 1.1",
+	tls.VersionTLS12: "TLS 1.2",
+	tls.VersionTLS13: "TLS 1.3",
+}
+
+// setTLS records the TLS state of a connection with the given peer
+// authentication info.
+func (h *healthDetails) setTLS(info credentials.AuthInfo) {
+	tlsInfo, ok := info.(credentials.TLSInfo)
+	if !ok {
+		return
+	}
+	h.TLSVersion = tlsVersions[tlsInfo.State.Version]
+	h.TLSCipherSuite = tls.CipherSuiteName(tlsInfo.State.CipherSuite)
+}
+
+// authMode names the way the datasource authenticates with the server.
+func (cfg config) authMode() string {
+	switch {
+	case cfg.OAuthPassThru:
+		return "oauth"
+	case cfg.Username != "" || cfg.Password != "":
+		return "username/password"
+	case cfg.Token != "":
+		return "token"
+	case cfg.TokenFile != "":
+		return "token file"
+	case cfg.TokenEnvVar != "":
+		return "token environment variable"
+	default:
+		return "none"
+	}
+}
@@ -0,0 +1,127 @@
+package flightsql
+
+import (
+	"time"
+	"unicode/utf8"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"google.golang.org/grpc/codes"
+)
+
+// maxLoggedSQLLength is the number of bytes after which SQL is truncated in
+// query logs.
+const maxLoggedSQLLength = 1000
+
+// queryLog describes a completed query.
+type queryLog struct {
+	refID     string
+	sql       string
+	timeRange backend.TimeRange
+	duration  time.Duration
+	stats     executeStats
+	rows      int
+	err       error
+	// code is the status code of the call to the server that failed, if
+	// any.
+	code codes.Code
+}
+
+// logQuery logs a completed query at the configured query log level, and
+// logs it in full as a warning if it took longer than the slow query
+// threshold.
+func (d *FlightSQLDatasource) logQuery(q queryLog) {
+	threshold := time.Duration(d.cfg.SlowQueryThresholdMs) * time.Millisecond
+	if threshold > 0 && q.duration >= threshold {
+		log.DefaultLogger.Warn("Slow query",
+			"refId", q.refID,
+			"datasourceUid", d.uid,
+			"sql", q.sql,
+			"from", q.timeRange.From,
+			"to", q.timeRange.To,
+			"durationMs", q.duration.Milliseconds(),
+			"executeMs", q.stats.execute.Milliseconds(),
+			"fetchMs", q.stats.fetch.Milliseconds(),
+			"rows", q.rows,
+		)
+	}
+
+	logf := loggerFunc(queryLogLevel(d.cfg.QueryLogLevel, q.err != nil))
+	if logf == nil {
+		return
+	}
+	args := []any{
+		"refId", q.refID,
+		"datasourceUid", d.uid,
+		"sql", truncate(q.sql, maxLoggedSQLLength),
+		"durationMs", q.duration.Milliseconds(),
+		"rows", q.rows,
+	}
+	if q.err != nil {
+		args = append(args, "error", q.err)
+	}
+	if q.code != codes.OK {
+		args = append(args, "code", q.code.String())
+	}
+	logf("Query completed", args...)
+}
+
+// queryLogLevel returns the level at which to log a query given the
+// configured level, which defaults to debug. Failed queries are logged at
+// least at the warn level so they show up in Grafana's server logs.
+func queryLogLevel(level string, failed bool) string {
+	if level == "" {
+		level = "debug"
+	}
+	if failed && (level == "debug" || level == "info") {
+		return "warn"
+	}
+	return level
+}
+
+// loggerFunc returns the function that logs at level, or nil if level is
+// "off".
+func loggerFunc(level string) func(string, ...any) {
+	switch level {
+	case "off":
+		return nil
+	case "info":
+		return log.DefaultLogger.Info
+	case "warn":
+		return log.DefaultLogger.Warn
+	case "error":
+		return log.DefaultLogger.Error
+	default:
+		return log.DefaultLogger.Debug
+	}
+}
+
+// truncate shortens s to at most n bytes without splitting a UTF-8 sequence,
+// marking that it was truncated.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n] + "..."
+}
+
+// auditQuery logs an executed query along with the user, org, dashboard and
+// panel that ran it.
+func (d *FlightSQLDatasource) auditQuery(query sqlQuery, err error) {
+	args := []any{
+		"refId", query.RefID,
+		"datasourceUid", d.uid,
+		"user", query.Origin.User,
+		"orgId", query.Origin.OrgID,
+		"dashboardUid", query.Origin.DashboardUID,
+		"panelId", query.Origin.PanelID,
+		"sql", query.RawSQL,
+	}
+	if err != nil {
+		args = append(args, "error", err)
+	}
+	log.DefaultLogger.Info("Query audit", args...)
+}
@@ -0,0 +1,25 @@
+package flightsql
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryLogLevel(t *testing.T) {
+	require.Equal(t, "debug", queryLogLevel("", false))
+	require.Equal(t, "warn", queryLogLevel("", true))
+	require.Equal(t, "info", queryLogLevel("info", false))
+	require.Equal(t, "warn", queryLogLevel("info", true))
+	require.Equal(t, "error", queryLogLevel("error", true))
+	require.Equal(t, "off", queryLogLevel("off", true))
+	require.Nil(t, loggerFunc("off"))
+	require.NotNil(t, loggerFunc("debug"))
+}
+
+func TestTruncate(t *testing.T) {
+	require.Equal(t, "select 1", truncate("select 1", 10))
+	require.Equal(t, "select...", truncate("select 1", 6))
+	// The multi-byte character isn't split.
+	require.Equal(t, "'...", truncate("'é'", 2))
+}
@@ -0,0 +1,134 @@
+package flightsql
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// logColumns designates the columns of a logs query that hold the log line
+// and its severity. Undesignated columns are found by name.
+type logColumns struct {
+	Body     string `json:"body,omitempty"`
+	Severity string `json:"severity,omitempty"`
+}
+
+var (
+	// logBodyNames are the names of columns that hold log lines, in order of
+	// preference.
+	logBodyNames = []string{"body", "line", "message", "msg", "log"}
+	// logSeverityNames are the names of columns that hold log levels.
+	logSeverityNames = []string{"severity", "level", "lvl"}
+)
+
+// logsFrame maps the columns of frame to a frame of log lines as described
+// by Grafana's logs data contract: a timestamp, a body, an optional severity
+// and the values of the remaining columns as labels. Without a designated
+// body column the first column with a known name, or else the first string
+// column, is used.
+func logsFrame(frame *data.Frame, cols logColumns) (*data.Frame, error) {
+	timeIdx := findTimeField(frame)
+	if timeIdx == -1 {
+		return nil, fmt.Errorf("no time column found")
+	}
+
+	severityIdx, err := logColumn(frame, cols.Severity, logSeverityNames)
+	if err != nil {
+		return nil, err
+	}
+	bodyIdx, err := logColumn(frame, cols.Body, logBodyNames)
+	if err != nil {
+		return nil, err
+	}
+	if bodyIdx == -1 {
+		for i, f := range frame.Fields {
+			if i != severityIdx && isStringField(f) {
+				bodyIdx = i
+				break
+			}
+		}
+	}
+	if bodyIdx == -1 {
+		return nil, fmt.Errorf("no log line column found")
+	}
+
+	var labelFields []*data.Field
+	for i, f := range frame.Fields {
+		if i != timeIdx && i != bodyIdx && i != severityIdx {
+			labelFields = append(labelFields, f)
+		}
+	}
+	labels := make([]json.RawMessage, frame.Fields[timeIdx].Len())
+	for row := range labels {
+		values := map[string]string{}
+		for _, f := range labelFields {
+			if v, ok := f.ConcreteAt(row); ok {
+				values[f.Name] = labelValue(v)
+			}
+		}
+		b, err := json.Marshal(values)
+		if err != nil {
+			return nil, err
+		}
+		labels[row] = b
+	}
+
+	out := data.NewFrame(frame.Name)
+	out.Meta = frame.Meta
+	out.Meta.Type = data.FrameTypeLogLines
+	out.Meta.PreferredVisualization = data.VisTypeLogs
+	out.Fields = append(out.Fields,
+		renamed(frame.Fields[timeIdx], "timestamp"),
+		renamed(frame.Fields[bodyIdx], "body"),
+	)
+	if severityIdx != -1 {
+		out.Fields = append(out.Fields, renamed(frame.Fields[severityIdx], "severity"))
+	}
+	out.Fields = append(out.Fields, data.NewField("labels", nil, labels))
+	return out, nil
+}
+
+// logColumn returns the index of the column named name or, if name is empty,
+// of the first column with one of names in order. It returns -1 if there is no such
+// column.
+func logColumn(frame *data.Frame, name string, names []string) (int, error) {
+	if name != "" {
+		_, i := frame.FieldByName(name)
+		if i == -1 {
+			return -1, fmt.Errorf("column %q not found", name)
+		}
+		return i, nil
+	}
+	for _, n := range names {
+		for i, f := range frame.Fields {
+			if strings.EqualFold(f.Name, n) && isStringField(f) {
+				return i, nil
+			}
+		}
+	}
+	return -1, nil
+}
+
+// labelValue formats a value as a label.
+func labelValue(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case json.RawMessage:
+		return string(v)
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// renamed returns a copy of field, sharing its values, with the given name.
+func renamed(field *data.Field, name string) *data.Field {
+	f := *field
+	f.Name = name
+	return &f
+}
@@ -0,0 +1,62 @@
+package flightsql
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLogsFrame(t *testing.T) {
+	t0 := time.Unix(0, 0).UTC()
+	frame := data.NewFrame("",
+		data.NewField("time", nil, []time.Time{t0}),
+		data.NewField("host", nil, []*string{ptr("a")}),
+		data.NewField("level", nil, []string{"error"}),
+		data.NewField("message", nil, []string{"disk full"}),
+		data.NewField("code", nil, []*int64{nil}),
+	)
+	frame.Meta = &data.FrameMeta{}
+
+	logs, err := logsFrame(frame, logColumns{})
+	require.NoError(t, err)
+	require.Equal(t, data.FrameTypeLogLines, logs.Meta.Type)
+	require.Equal(t, data.VisType(data.VisTypeLogs), logs.Meta.PreferredVisualization)
+	require.Len(t, logs.Fields, 4)
+	require.Equal(t, "timestamp", logs.Fields[0].Name)
+	require.Equal(t, "body", logs.Fields[1].Name)
+	require.Equal(t, "disk full", logs.Fields[1].At(0))
+	require.Equal(t, "severity", logs.Fields[2].Name)
+	require.Equal(t, "error", logs.Fields[2].At(0))
+	require.Equal(t, "labels", logs.Fields[3].Name)
+	require.JSONEq(t, `{"host": "a"}`, string(logs.Fields[3].At(0).(json.RawMessage)))
+}
+
+func TestLogsFrame_DesignatedColumns(t *testing.T) {
+	frame := data.NewFrame("",
+		data.NewField("time", nil, []time.Time{time.Unix(0, 0)}),
+		data.NewField("message", nil, []string{"disk full"}),
+		data.NewField("text", nil, []string{"sda1"}),
+	)
+	frame.Meta = &data.FrameMeta{}
+
+	logs, err := logsFrame(frame, logColumns{Body: "text"})
+	require.NoError(t, err)
+	require.Len(t, logs.Fields, 3)
+	require.Equal(t, "sda1", logs.Fields[1].At(0))
+	require.JSONEq(t, `{"message": "disk full"}`, string(logs.Fields[2].At(0).(json.RawMessage)))
+
+	_, err = logsFrame(frame, logColumns{Severity: "level"})
+	require.EqualError(t, err, `column "level" not found`)
+}
+
+func TestLogsFrame_NoBody(t *testing.T) {
+	frame := data.NewFrame("",
+		data.NewField("time", nil, []time.Time{time.Unix(0, 0)}),
+		data.NewField("value", nil, []int64{1}),
+	)
+	_, err := logsFrame(frame, logColumns{})
+	require.EqualError(t, err, "no log line column found")
+}
@@ -2,8 +2,14 @@ package flightsql
 
 import (
 	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/gtime"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
 	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
 )
 
@@ -17,16 +23,122 @@ var macros = sqlutil.Macros{
 	// The behaviors of timeFrom and timeTo as defined in the SDK are different
 	// from all other Grafana SQL plugins. Instead we'll take their
 	// implementations, rename them and define timeFrom and timeTo ourselves.
-	"timeRangeFrom": sqlutil.DefaultMacros["timeFrom"],
-	"timeRangeTo":   sqlutil.DefaultMacros["timeTo"],
-	"timeRange":     sqlutil.DefaultMacros["timeFilter"],
+	// They're also reimplemented to format times in the time zone of the
+	// query.
+	"timeRangeFrom": macroTimeRangeFrom,
+	"timeRangeTo":   macroTimeRangeTo,
+	"timeRange":     macroTimeRange,
+	"timeFilter":    macroTimeRange,
 	"timeTo":        macroTo,
 	"timeFrom":      macroFrom,
 }
 
+// macroDoc documents a macro for the query editor.
+type macroDoc struct {
+	// Args names the arguments of the macro.
+	Args []string
+	// Example holds the arguments used to demonstrate the macro.
+	Example     []string
+	Description string
+}
+
+// macroDocs documents the macros advertised to the query editor.
+var macroDocs = map[string]macroDoc{
+	"dateBin": {
+		Args:        []string{"column", "[fill]"},
+		Example:     []string{"time"},
+		Description: "Bins the column into intervals of the query interval. Empty intervals are filled with NULL, previous or a number if fill is given.",
+	},
+	"dateBinAlias": {
+		Args:        []string{"column", "[fill]"},
+		Example:     []string{"time"},
+		Description: "Same as $__dateBin, aliased as <column>_binned.",
+	},
+	"interval": {
+		Description: "The query interval as a SQL interval.",
+	},
+	"timeFilter": {
+		Args:        []string{"column"},
+		Example:     []string{"time"},
+		Description: "Filters the column to the dashboard time range.",
+	},
+	"timeFrom": {
+		Description: "The start of the dashboard time range as a timestamp.",
+	},
+	"timeGroup": {
+		Args:        []string{"column", "unit", "[fill]"},
+		Example:     []string{"time", "hour"},
+		Description: "Groups the column by a unit of minute, hour, day, month or year, or bins it into intervals such as '5m' whose empty intervals are filled with NULL, previous or a number if fill is given.",
+	},
+	"timeGroupAlias": {
+		Args:        []string{"column", "unit", "[fill]"},
+		Example:     []string{"time", "hour"},
+		Description: "Same as $__timeGroup, aliasing each part as <column>_<unit>, or the intervals as <column>_binned.",
+	},
+	"timeRange": {
+		Args:        []string{"column"},
+		Example:     []string{"time"},
+		Description: "Filters the column to the dashboard time range.",
+	},
+	"timeRangeFrom": {
+		Args:        []string{"column"},
+		Example:     []string{"time"},
+		Description: "Filters the column to after the start of the dashboard time range.",
+	},
+	"timeRangeTo": {
+		Args:        []string{"column"},
+		Example:     []string{"time"},
+		Description: "Filters the column to before the end of the dashboard time range.",
+	},
+	"timeTo": {
+		Description: "The end of the dashboard time range as a timestamp.",
+	},
+}
+
+// macroExampleQuery is the query that example macro expansions are
+// interpolated with.
+var macroExampleQuery = sqlutil.Query{
+	TimeRange: backend.TimeRange{
+		From: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC),
+		To:   time.Date(2023, 1, 1, 1, 0, 0, 0, time.UTC),
+	},
+	Interval: time.Minute,
+}
+
+// example returns an example use of the macro name.
+func (m macroDoc) example(name string) string {
+	if len(m.Example) == 0 {
+		return "$__" + name
+	}
+	return fmt.Sprintf("$__%s(%s)", name, strings.Join(m.Example, ", "))
+}
+
+// signature returns the signature of the macro name.
+func (m macroDoc) signature(name string) string {
+	if len(m.Args) == 0 {
+		return "$__" + name
+	}
+	return fmt.Sprintf("$__%s(%s)", name, strings.Join(m.Args, ", "))
+}
+
 func macroTimeGroup(query *sqlutil.Query, args []string) (string, error) {
+	if len(args) == 2 || len(args) == 3 {
+		// An interval rather than a unit bins the column with date_bin.
+		if interval, err := gtime.ParseDuration(strings.Trim(args[1], `'" `)); err == nil {
+			// Intervals are rendered in milliseconds at the finest.
+			if interval <= 0 || interval%time.Millisecond != 0 {
+				return "", fmt.Errorf("invalid interval: %s", args[1])
+			}
+			if len(args) == 3 {
+				if _, err := macroFill(args[2]); err != nil {
+					return "", err
+				}
+			}
+			return dateBin(interval, args[0]), nil
+		}
+	}
 	if len(args) != 2 {
-		return "", fmt.Errorf("%w: expected 1 argument, received %d", sqlutil.ErrorBadArgumentCount, len(args))
+		return "", fmt.Errorf("%w: expected 2 arguments, received %d", sqlutil.ErrorBadArgumentCount, len(args))
 	}
 
 	column := args[0]
@@ -52,9 +164,81 @@ func macroTimeGroup(query *sqlutil.Query, args []string) (string, error) {
 	return res, nil
 }
 
+// fillPattern matches uses of the macros that bin time and may fill empty
+// intervals.
+var fillPattern = regexp.MustCompile(`\$__(timeGroup|timeGroupAlias|dateBin|dateBinAlias)\(([^)]*)\)`)
+
+// macroFillMissing returns how the first macro in sql that bins time with a
+// fill argument fills empty intervals, and the length of its intervals. The
+// length is zero for intervals of the query interval. Macros are expanded
+// with a copy of the query, so the fill is found ahead of expanding them.
+func macroFillMissing(sql string) (*data.FillMissing, time.Duration) {
+	for _, m := range fillPattern.FindAllStringSubmatch(sql, -1) {
+		args := strings.Split(m[2], ",")
+		var interval time.Duration
+		if m[1] == "timeGroup" || m[1] == "timeGroupAlias" {
+			if len(args) != 3 {
+				continue
+			}
+			d, err := gtime.ParseDuration(strings.Trim(args[1], `'" `))
+			if err != nil {
+				continue
+			}
+			interval = d
+		}
+		if len(args) < 2 {
+			continue
+		}
+		if fill, err := macroFill(args[len(args)-1]); err == nil {
+			return fill, interval
+		}
+	}
+	return nil, 0
+}
+
+// searchFilterPattern matches the $__searchFilter macro.
+var searchFilterPattern = regexp.MustCompile(`\$__searchFilter\b`)
+
+// expandSearchFilter expands $__searchFilter to a LIKE pattern matching
+// values that contain term, or any value if term is empty. The term is what
+// the user has typed into a variable's search box; it isn't a query argument,
+// so the macro is expanded ahead of the others.
+func expandSearchFilter(sql, term string) string {
+	pattern := "'%" + strings.ReplaceAll(term, "'", "''") + "%'"
+	if term == "" {
+		pattern = "'%'"
+	}
+	return searchFilterPattern.ReplaceAllLiteralString(sql, pattern)
+}
+
+// macroFill parses the fill argument of a macro: NULL, previous or a number.
+func macroFill(arg string) (*data.FillMissing, error) {
+	arg = strings.TrimSpace(arg)
+	switch strings.ToLower(arg) {
+	case "null":
+		return &data.FillMissing{Mode: data.FillModeNull}, nil
+	case "previous":
+		return &data.FillMissing{Mode: data.FillModePrevious}, nil
+	}
+	v, err := strconv.ParseFloat(arg, 64)
+	if err != nil {
+		return nil, fmt.Errorf("unsupported fill: %s", arg)
+	}
+	return &data.FillMissing{Mode: data.FillModeValue, Value: v}, nil
+}
+
 func macroTimeGroupAlias(query *sqlutil.Query, args []string) (string, error) {
+	if len(args) == 2 || len(args) == 3 {
+		if _, err := gtime.ParseDuration(strings.Trim(args[1], `'" `)); err == nil {
+			bin, err := macroTimeGroup(query, args)
+			if err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%s as %s_binned", bin, args[0]), nil
+		}
+	}
 	if len(args) != 2 {
-		return "", fmt.Errorf("%w: expected 1 argument, received %d", sqlutil.ErrorBadArgumentCount, len(args))
+		return "", fmt.Errorf("%w: expected 2 arguments, received %d", sqlutil.ErrorBadArgumentCount, len(args))
 	}
 
 	column := args[0]
@@ -81,21 +265,67 @@ func macroTimeGroupAlias(query *sqlutil.Query, args []string) (string, error) {
 }
 
 func macroInterval(query *sqlutil.Query, _ []string) (string, error) {
-	return fmt.Sprintf("interval '%d second'", int64(query.Interval.Seconds())), nil
+	return sqlInterval(query.Interval), nil
+}
+
+// sqlInterval formats d as a SQL interval, in seconds when it's a whole
+// number of them and otherwise in milliseconds, so that intervals under a
+// second aren't truncated to zero.
+func sqlInterval(d time.Duration) string {
+	if d%time.Second == 0 {
+		return fmt.Sprintf("interval '%d second'", d/time.Second)
+	}
+	return fmt.Sprintf("interval '%d millisecond'", d/time.Millisecond)
 }
 
 func macroFrom(query *sqlutil.Query, _ []string) (string, error) {
-	return fmt.Sprintf("cast('%s' as timestamp)", query.TimeRange.From.Format(time.RFC3339)), nil
+	return fmt.Sprintf("cast('%s' as timestamp)", timeLiteral(query.TimeRange.From)), nil
 }
 
 func macroTo(query *sqlutil.Query, _ []string) (string, error) {
-	return fmt.Sprintf("cast('%s' as timestamp)", query.TimeRange.To.Format(time.RFC3339)), nil
+	return fmt.Sprintf("cast('%s' as timestamp)", timeLiteral(query.TimeRange.To)), nil
+}
+
+func macroTimeRange(query *sqlutil.Query, args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("%w: expected 1 argument, received %d", sqlutil.ErrorBadArgumentCount, len(args))
+	}
+	return fmt.Sprintf("%s >= '%s' AND %s <= '%s'", args[0], timeLiteral(query.TimeRange.From), args[0], timeLiteral(query.TimeRange.To)), nil
+}
+
+func macroTimeRangeFrom(query *sqlutil.Query, args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("%w: expected 1 argument, received %d", sqlutil.ErrorBadArgumentCount, len(args))
+	}
+	return fmt.Sprintf("%s >= '%s'", args[0], timeLiteral(query.TimeRange.From)), nil
+}
+
+func macroTimeRangeTo(query *sqlutil.Query, args []string) (string, error) {
+	if len(args) != 1 {
+		return "", fmt.Errorf("%w: expected 1 argument, received %d", sqlutil.ErrorBadArgumentCount, len(args))
+	}
+	return fmt.Sprintf("%s <= '%s'", args[0], timeLiteral(query.TimeRange.To)), nil
+}
+
+// timeLiteral formats t for a time macro. Times in UTC are formatted as RFC
+// 3339 timestamps and times in other locations, those of queries with a time
+// zone, as wall clock times in that location without an offset.
+func timeLiteral(t time.Time) string {
+	if t.Location() == time.UTC {
+		return t.Format(time.RFC3339)
+	}
+	return t.Format("2006-01-02T15:04:05")
 }
 
 func macroDateBin(suffix string) sqlutil.MacroFunc {
 	return func(query *sqlutil.Query, args []string) (string, error) {
-		if len(args) != 1 {
-			return "", fmt.Errorf("%w: expected 1 argument, received %d", sqlutil.ErrorBadArgumentCount, len(args))
+		if len(args) != 1 && len(args) != 2 {
+			return "", fmt.Errorf("%w: expected 1 or 2 arguments, received %d", sqlutil.ErrorBadArgumentCount, len(args))
+		}
+		if len(args) == 2 {
+			if _, err := macroFill(args[1]); err != nil {
+				return "", err
+			}
 		}
 		column := args[0]
 		aliasing := func() string {
@@ -104,6 +334,10 @@ func macroDateBin(suffix string) sqlutil.MacroFunc {
 			}
 			return fmt.Sprintf(" as %s%s", column, suffix)
 		}()
-		return fmt.Sprintf("date_bin(interval '%d second', %s, timestamp '1970-01-01T00:00:00Z')%s", int64(query.Interval.Seconds()), column, aliasing), nil
+		return dateBin(query.Interval, column) + aliasing, nil
 	}
 }
+
+func dateBin(interval time.Duration, column string) string {
+	return fmt.Sprintf("date_bin(%s, %s, timestamp '1970-01-01T00:00:00Z')", sqlInterval(interval), column)
+}
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
 	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
 	"github.com/stretchr/testify/require"
 )
@@ -40,6 +41,22 @@ func TestMacros(t *testing.T) {
 			in:  `select $__dateBinAlias(time)`,
 			out: `select date_bin(interval '10 second', time, timestamp '1970-01-01T00:00:00Z') as time_binned`,
 		},
+		{
+			in:  `select $__timeGroup(time, '5m')`,
+			out: `select date_bin(interval '300 second', time, timestamp '1970-01-01T00:00:00Z')`,
+		},
+		{
+			in:  `select $__timeGroup(time, '250ms')`,
+			out: `select date_bin(interval '250 millisecond', time, timestamp '1970-01-01T00:00:00Z')`,
+		},
+		{
+			in:  `select $__timeGroupAlias(time, '5m', 0)`,
+			out: `select date_bin(interval '300 second', time, timestamp '1970-01-01T00:00:00Z') as time_binned`,
+		},
+		{
+			in:  `select $__timeGroupAlias(time, hour)`,
+			out: `select datepart('hour', time) as time_hour,datepart('day', time) as time_day,datepart('month', time) as time_month,datepart('year', time) as time_year`,
+		},
 		{
 			in:  `select * from x where $__timeFilter(time)`,
 			out: `select * from x where time >= '2023-01-01T00:00:00Z' AND time <= '2023-01-01T00:10:00Z'`,
@@ -73,3 +90,72 @@ func TestMacros(t *testing.T) {
 		})
 	}
 }
+
+func TestMacroFillMissing(t *testing.T) {
+	cs := []struct {
+		in       string
+		fill     *data.FillMissing
+		interval time.Duration
+	}{
+		{`select $__dateBin(time)`, nil, 0},
+		{`select $__dateBin(time, NULL)`, &data.FillMissing{Mode: data.FillModeNull}, 0},
+		{`select $__dateBinAlias(time, previous)`, &data.FillMissing{Mode: data.FillModePrevious}, 0},
+		{`select $__timeGroup(time, hour)`, nil, 0},
+		{`select $__timeGroup(time, 1h, 0)`, &data.FillMissing{Mode: data.FillModeValue}, time.Hour},
+		{`select $__timeGroup(time, '1m', 2.5)`, &data.FillMissing{Mode: data.FillModeValue, Value: 2.5}, time.Minute},
+		{`select $__timeGroupAlias(time, '1m', previous)`, &data.FillMissing{Mode: data.FillModePrevious}, time.Minute},
+	}
+	for _, c := range cs {
+		t.Run(c.in, func(t *testing.T) {
+			fill, interval := macroFillMissing(c.in)
+			require.Equal(t, c.fill, fill)
+			require.Equal(t, c.interval, interval)
+		})
+	}
+
+	_, err := sqlutil.Interpolate((&sqlutil.Query{}).WithSQL(`$__dateBin(time, sometimes)`), macros)
+	require.ErrorContains(t, err, "unsupported fill: sometimes")
+	_, err = sqlutil.Interpolate((&sqlutil.Query{}).WithSQL(`$__timeGroupAlias(time, 1m, sometimes)`), macros)
+	require.ErrorContains(t, err, "unsupported fill: sometimes")
+}
+
+func TestMacros_SubSecondInterval(t *testing.T) {
+	query := sqlutil.Query{Interval: 500 * time.Millisecond}
+	sql, err := sqlutil.Interpolate(query.WithSQL(`select $__interval`), macros)
+	require.NoError(t, err)
+	require.Equal(t, `select interval '500 millisecond'`, sql)
+
+	sql, err = sqlutil.Interpolate(query.WithSQL(`select $__dateBin(time)`), macros)
+	require.NoError(t, err)
+	require.Equal(t, `select date_bin(interval '500 millisecond', time, timestamp '1970-01-01T00:00:00Z')`, sql)
+
+	_, err = sqlutil.Interpolate(query.WithSQL(`select $__timeGroup(time, '500us')`), macros)
+	require.ErrorContains(t, err, "invalid interval")
+}
+
+func TestMacros_TimeZone(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	from := time.Date(2023, 1, 1, 5, 0, 0, 0, time.UTC)
+	query := sqlutil.Query{
+		TimeRange: backend.TimeRange{From: from.In(loc), To: from.Add(time.Hour).In(loc)},
+	}
+
+	sql, err := sqlutil.Interpolate(query.WithSQL(`select * from x where $__timeRange(time) and time > $__timeFrom`), macros)
+	require.NoError(t, err)
+	require.Equal(t, `select * from x where time >= '2023-01-01T00:00:00' AND time <= '2023-01-01T01:00:00' and time > cast('2023-01-01T00:00:00' as timestamp)`, sql)
+}
+
+func TestExpandSearchFilter(t *testing.T) {
+	sql := `select host from hosts where host like $__searchFilter`
+	require.Equal(t, `select host from hosts where host like '%'`, expandSearchFilter(sql, ""))
+	require.Equal(t, `select host from hosts where host like '%o''brien%'`, expandSearchFilter(sql, "o'brien"))
+	require.Equal(t, `select $__searchFilters`, expandSearchFilter(`select $__searchFilters`, "a"))
+
+	query, err := decodeQueryRequest(backend.DataQuery{
+		QueryType: variableQueryType,
+		JSON:      []byte(`{"queryText": "select host from hosts where host like $__searchFilter", "searchFilter": "web"}`),
+	})
+	require.NoError(t, err)
+	require.Equal(t, `select host from hosts where host like '%web%'`, query.RawSQL)
+}
@@ -0,0 +1,85 @@
+package flightsql
+
+import (
+	"math/bits"
+	"sync"
+
+	"github.com/apache/arrow/go/v12/arrow/memory"
+)
+
+// allocator is the Arrow allocator shared by all datasources. Results are
+// copied into frames and their records released as they are read, so the
+// buffers they were read into can be reused for the next query rather than
+// left to the garbage collector, which dashboards that refresh frequently
+// would otherwise keep busy.
+var allocator memory.Allocator = newPoolAllocator()
+
+// The buffers of a poolAllocator are pooled in power of two size classes
+// from minPooledSize to maxPooledSize. Smaller buffers are cheap to allocate,
+// and larger ones are rare enough that pooling them would mostly hold on to
+// memory.
+const (
+	minPooledShift = 12 // 4KiB
+	maxPooledShift = 26 // 64MiB
+	minPooledSize  = 1 << minPooledShift
+	maxPooledSize  = 1 << maxPooledShift
+)
+
+// poolAllocator is a [memory.Allocator] that reuses freed buffers.
+//
+// Buffers are allocated at their size class, which being a power of two of
+// at least 4KiB keeps them aligned to the 64 bytes Arrow expects, and are
+// zeroed when reused since Arrow expects new buffers to be zeroed.
+type poolAllocator struct {
+	pools    [maxPooledShift - minPooledShift + 1]sync.Pool
+	fallback *memory.GoAllocator
+}
+
+// newPoolAllocator returns an empty [poolAllocator].
+func newPoolAllocator() *poolAllocator {
+	return &poolAllocator{fallback: memory.NewGoAllocator()}
+}
+
+// sizeClass returns the index of the pool of buffers of at least size
+// bytes, or -1 if buffers of that size aren't pooled.
+func sizeClass(size int) int {
+	if size < minPooledSize || size > maxPooledSize {
+		return -1
+	}
+	return bits.Len(uint(size-1)) - minPooledShift
+}
+
+func (a *poolAllocator) Allocate(size int) []byte {
+	class := sizeClass(size)
+	if class < 0 {
+		return a.fallback.Allocate(size)
+	}
+	if b, ok := a.pools[class].Get().(*[]byte); ok {
+		buf := (*b)[:cap(*b)]
+		for i := range buf {
+			buf[i] = 0
+		}
+		return buf[:size]
+	}
+	return make([]byte, size, 1<<(class+minPooledShift))
+}
+
+func (a *poolAllocator) Reallocate(size int, b []byte) []byte {
+	if size <= cap(b) {
+		return b[:size]
+	}
+	buf := a.Allocate(size)
+	copy(buf, b)
+	a.Free(b)
+	return buf
+}
+
+// Free returns b to its pool. Buffers that weren't allocated by a pool, whose
+// capacity isn't that of a size class, are left to the garbage collector.
+func (a *poolAllocator) Free(b []byte) {
+	class := sizeClass(cap(b))
+	if class < 0 || cap(b) != 1<<(class+minPooledShift) {
+		return
+	}
+	a.pools[class].Put(&b)
+}
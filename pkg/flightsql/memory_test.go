@@ -0,0 +1,45 @@
+package flightsql
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSizeClass(t *testing.T) {
+	require.Equal(t, -1, sizeClass(64))
+	require.Equal(t, 0, sizeClass(minPooledSize))
+	require.Equal(t, 1, sizeClass(minPooledSize+1))
+	require.Equal(t, maxPooledShift-minPooledShift, sizeClass(maxPooledSize))
+	require.Equal(t, -1, sizeClass(maxPooledSize+1))
+}
+
+func TestPoolAllocator(t *testing.T) {
+	a := newPoolAllocator()
+
+	b := a.Allocate(5000)
+	require.Len(t, b, 5000)
+	require.Equal(t, 8192, cap(b))
+	for i := range b {
+		b[i] = 0xff
+	}
+	a.Free(b)
+
+	// Buffers are zeroed whether or not they are reused.
+	b = a.Allocate(6000)
+	require.Len(t, b, 6000)
+	for i := range b[:cap(b)] {
+		require.Zero(t, b[:cap(b)][i])
+	}
+
+	b[0] = 1
+	b = a.Reallocate(10000, b)
+	require.Len(t, b, 10000)
+	require.Equal(t, 16384, cap(b))
+	require.Equal(t, byte(1), b[0])
+	a.Free(b)
+
+	small := a.Allocate(100)
+	require.Len(t, small, 100)
+	a.Free(small)
+}
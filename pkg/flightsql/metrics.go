@@ -0,0 +1,67 @@
+package flightsql
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// The metrics are registered with the default registry, which the plugin SDK
+// serves on Grafana's plugin metrics endpoint.
+var (
+	queriesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "flightsql",
+		Name:      "queries_total",
+		Help:      "Number of queries executed, by status.",
+	}, []string{"status"})
+
+	queryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "flightsql",
+		Name:      "query_duration_seconds",
+		Help:      "Duration of queries, by phase.",
+		Buckets:   []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60},
+	}, []string{"phase"})
+
+	queryRows = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: "flightsql",
+		Name:      "query_rows",
+		Help:      "Number of rows returned by queries.",
+		Buckets:   prometheus.ExponentialBuckets(1, 10, 8),
+	})
+
+	grpcErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "flightsql",
+		Name:      "grpc_errors_total",
+		Help:      "Number of failed calls to the server, by gRPC status code.",
+	}, []string{"code"})
+
+	activeDatasources = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: "flightsql",
+		Name:      "active_datasources",
+		Help:      "Number of datasource instances with a client to a Flight SQL server.",
+	})
+)
+
+// observeQuery records the outcome of a query along with the duration of its
+// phases and the number of rows it returned.
+func observeQuery(err error, stats executeStats, rows int) {
+	if err != nil {
+		queriesTotal.WithLabelValues("error").Inc()
+	} else {
+		queriesTotal.WithLabelValues("ok").Inc()
+	}
+	queryDuration.WithLabelValues("execute").Observe(stats.execute.Seconds())
+	if stats.fetch > 0 {
+		queryDuration.WithLabelValues("fetch").Observe(stats.fetch.Seconds())
+	}
+	queryDuration.WithLabelValues("total").Observe((stats.execute + stats.fetch).Seconds())
+	queryRows.Observe(float64(rows))
+}
+
+// observeGRPCError records the status code of a failed call to the server.
+// Errors that didn't come from the server, such as invalid parameters, are
+// ignored.
+func observeGRPCError(err error) {
+	if st, ok := grpcStatus(err); ok {
+		grpcErrorsTotal.WithLabelValues(st.Code().String()).Inc()
+	}
+}
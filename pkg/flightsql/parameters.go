@@ -0,0 +1,180 @@
+package flightsql
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/apache/arrow/go/v12/arrow"
+	"github.com/apache/arrow/go/v12/arrow/array"
+)
+
+// parameterRecord builds the single row record of parameters bound to a
+// prepared statement. Where the server describes the parameters in schema the
+// values are converted to its types, otherwise the types are inferred from the
+// JSON values: strings as utf8, integers as int64, other numbers as float64
+// and booleans as bool.
+func parameterRecord(schema *arrow.Schema, params []json.RawMessage) (arrow.Record, error) {
+	values := make([]any, len(params))
+	for i, p := range params {
+		d := json.NewDecoder(bytes.NewReader(p))
+		d.UseNumber()
+		if err := d.Decode(&values[i]); err != nil {
+			return nil, fmt.Errorf("parameter %d: %w", i+1, err)
+		}
+	}
+
+	if schema == nil || len(schema.Fields()) == 0 {
+		fields := make([]arrow.Field, len(values))
+		for i, v := range values {
+			typ, err := inferParameterType(v)
+			if err != nil {
+				return nil, fmt.Errorf("parameter %d: %w", i+1, err)
+			}
+			fields[i] = arrow.Field{Name: strconv.Itoa(i + 1), Type: typ, Nullable: true}
+		}
+		schema = arrow.NewSchema(fields, nil)
+	}
+	if len(schema.Fields()) != len(values) {
+		return nil, fmt.Errorf("statement takes %d parameters, received %d", len(schema.Fields()), len(values))
+	}
+
+	b := array.NewRecordBuilder(allocator, schema)
+	defer b.Release()
+	for i, v := range values {
+		if err := appendParameter(b.Field(i), v); err != nil {
+			return nil, fmt.Errorf("parameter %d: %w", i+1, err)
+		}
+	}
+	return b.NewRecord(), nil
+}
+
+// inferParameterType returns the Arrow type of a parameter decoded from JSON.
+func inferParameterType(v any) (arrow.DataType, error) {
+	switch v := v.(type) {
+	case nil:
+		return arrow.Null, nil
+	case string:
+		return arrow.BinaryTypes.String, nil
+	case bool:
+		return arrow.FixedWidthTypes.Boolean, nil
+	case json.Number:
+		if _, err := v.Int64(); err == nil {
+			return arrow.PrimitiveTypes.Int64, nil
+		}
+		return arrow.PrimitiveTypes.Float64, nil
+	default:
+		return nil, fmt.Errorf("unsupported value: %v", v)
+	}
+}
+
+// appendParameter appends a parameter decoded from JSON to b, converting it
+// to b's type.
+func appendParameter(b array.Builder, v any) error {
+	if v == nil {
+		b.AppendNull()
+		return nil
+	}
+
+	switch b := b.(type) {
+	case *array.StringBuilder:
+		// Numbers are bound as their literal text, since template variable
+		// values that look like numbers are sent as numbers.
+		switch v := v.(type) {
+		case string:
+			b.Append(v)
+		case json.Number:
+			b.Append(v.String())
+		default:
+			return fmt.Errorf("expected a string, received %v", v)
+		}
+	case *array.BooleanBuilder:
+		t, ok := v.(bool)
+		if !ok {
+			return fmt.Errorf("expected a boolean, received %v", v)
+		}
+		b.Append(t)
+	case *array.Int64Builder:
+		n, err := parameterInt(v, 64)
+		if err != nil {
+			return err
+		}
+		b.Append(n)
+	case *array.Int32Builder:
+		n, err := parameterInt(v, 32)
+		if err != nil {
+			return err
+		}
+		b.Append(int32(n))
+	case *array.Float64Builder:
+		f, err := parameterFloat(v)
+		if err != nil {
+			return err
+		}
+		b.Append(f)
+	case *array.Float32Builder:
+		f, err := parameterFloat(v)
+		if err != nil {
+			return err
+		}
+		b.Append(float32(f))
+	case *array.TimestampBuilder:
+		t, err := parameterTime(v)
+		if err != nil {
+			return err
+		}
+		b.Append(timestampInUnit(t, b.Type().(*arrow.TimestampType).Unit))
+	default:
+		return fmt.Errorf("unsupported parameter type: %s", b.Type())
+	}
+	return nil
+}
+
+func parameterInt(v any, bits int) (int64, error) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("expected an integer, received %v", v)
+	}
+	return strconv.ParseInt(n.String(), 10, bits)
+}
+
+func parameterFloat(v any) (float64, error) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("expected a number, received %v", v)
+	}
+	return n.Float64()
+}
+
+// parameterTime converts an RFC 3339 string or a number of milliseconds since
+// the epoch to a time.
+func parameterTime(v any) (time.Time, error) {
+	switch v := v.(type) {
+	case string:
+		return time.Parse(time.RFC3339Nano, v)
+	case json.Number:
+		ms, err := v.Int64()
+		if err != nil {
+			return time.Time{}, err
+		}
+		return time.UnixMilli(ms), nil
+	default:
+		return time.Time{}, fmt.Errorf("expected a timestamp, received %v", v)
+	}
+}
+
+// timestampInUnit converts t to a timestamp in unit.
+func timestampInUnit(t time.Time, unit arrow.TimeUnit) arrow.Timestamp {
+	switch unit {
+	case arrow.Second:
+		return arrow.Timestamp(t.Unix())
+	case arrow.Millisecond:
+		return arrow.Timestamp(t.UnixMilli())
+	case arrow.Microsecond:
+		return arrow.Timestamp(t.UnixMicro())
+	default:
+		return arrow.Timestamp(t.UnixNano())
+	}
+}
@@ -0,0 +1,71 @@
+package flightsql
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v12/arrow"
+	"github.com/apache/arrow/go/v12/arrow/array"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParameterRecord_Inferred(t *testing.T) {
+	rec, err := parameterRecord(nil, rawParams(`"a"`, `1`, `1.5`, `true`, `null`))
+	require.NoError(t, err)
+	defer rec.Release()
+
+	require.Equal(t, int64(1), rec.NumRows())
+	require.Equal(t, arrow.BinaryTypes.String, rec.Column(0).DataType())
+	require.Equal(t, "a", rec.Column(0).(*array.String).Value(0))
+	require.Equal(t, int64(1), rec.Column(1).(*array.Int64).Value(0))
+	require.Equal(t, 1.5, rec.Column(2).(*array.Float64).Value(0))
+	require.True(t, rec.Column(3).(*array.Boolean).Value(0))
+	require.Equal(t, arrow.Null, rec.Column(4).DataType())
+}
+
+func TestParameterRecord_Schema(t *testing.T) {
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "n", Type: arrow.PrimitiveTypes.Int32},
+		{Name: "f", Type: arrow.PrimitiveTypes.Float32},
+		{Name: "ts", Type: &arrow.TimestampType{Unit: arrow.Millisecond}},
+	}, nil)
+	rec, err := parameterRecord(schema, rawParams(`7`, `2`, `"2023-01-01T00:00:00Z"`))
+	require.NoError(t, err)
+	defer rec.Release()
+
+	require.Equal(t, int32(7), rec.Column(0).(*array.Int32).Value(0))
+	require.Equal(t, float32(2), rec.Column(1).(*array.Float32).Value(0))
+	ts := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	require.Equal(t, arrow.Timestamp(ts.UnixMilli()), rec.Column(2).(*array.Timestamp).Value(0))
+
+	_, err = parameterRecord(schema, rawParams(`7`))
+	require.ErrorContains(t, err, "statement takes 3 parameters, received 1")
+
+	_, err = parameterRecord(schema, rawParams(`"seven"`, `2`, `0`))
+	require.ErrorContains(t, err, "parameter 1")
+}
+
+func TestParameterRecord_NumericString(t *testing.T) {
+	// Variable values that look like numbers are bound as numbers, but are
+	// still accepted by string parameters.
+	params := []json.RawMessage{variableParameter(json.RawMessage(`"42"`)), variableParameter(json.RawMessage(`"1e3"`))}
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "host", Type: arrow.BinaryTypes.String},
+		{Name: "tag", Type: arrow.BinaryTypes.String},
+	}, nil)
+	rec, err := parameterRecord(schema, params)
+	require.NoError(t, err)
+	defer rec.Release()
+
+	require.Equal(t, "42", rec.Column(0).(*array.String).Value(0))
+	require.Equal(t, "1e3", rec.Column(1).(*array.String).Value(0))
+}
+
+func rawParams(params ...string) []json.RawMessage {
+	raw := make([]json.RawMessage, len(params))
+	for i, p := range params {
+		raw[i] = json.RawMessage(p)
+	}
+	return raw
+}
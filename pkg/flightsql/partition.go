@@ -0,0 +1,114 @@
+package flightsql
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// maxPartitions is the number of frames a query's results may be
+// partitioned into, which bounds the cost of partitioning by a column with
+// many distinct values by mistake.
+const maxPartitions = 1000
+
+// partition is the rows of a frame with the same values in the columns it
+// is partitioned by.
+type partition struct {
+	// frame holds the rows, without the partition columns.
+	frame *data.Frame
+	// labels are the values of the partition columns.
+	labels data.Labels
+	rows   []int
+}
+
+// partitionFrame splits frame into a partition for each distinct combination
+// of values of columns, in the order the combinations first appear. The
+// partition columns are removed from the partitions' frames, which are
+// instead named after their values, such as "host=a, region=eu". Nulls are
+// treated as empty values.
+func partitionFrame(frame *data.Frame, columns []string) ([]*partition, error) {
+	isPartition := make(map[string]bool, len(columns))
+	fields := make([]*data.Field, len(columns))
+	for i, name := range columns {
+		f, idx := frame.FieldByName(name)
+		if idx == -1 {
+			return nil, fmt.Errorf("partition column %q not found", name)
+		}
+		fields[i] = f
+		isPartition[name] = true
+	}
+
+	var partitions []*partition
+	byKey := map[string]*partition{}
+	values := make([]string, len(fields))
+	for row := 0; row < frame.Rows(); row++ {
+		for i, f := range fields {
+			values[i] = ""
+			if v, ok := f.ConcreteAt(row); ok {
+				values[i] = labelValue(v)
+			}
+		}
+		// Values are quoted so that a separator within them can't make
+		// different combinations share a key.
+		key := fmt.Sprintf("%q", values)
+		p, ok := byKey[key]
+		if !ok {
+			if len(partitions) == maxPartitions {
+				return nil, fmt.Errorf("partitioning produced more than %d frames", maxPartitions)
+			}
+			p = &partition{labels: data.Labels{}}
+			pairs := make([]string, len(columns))
+			for i, name := range columns {
+				p.labels[name] = values[i]
+				pairs[i] = name + "=" + values[i]
+			}
+			p.frame = data.NewFrame(strings.Join(pairs, ", "))
+			byKey[key] = p
+			partitions = append(partitions, p)
+		}
+		p.rows = append(p.rows, row)
+	}
+
+	for _, p := range partitions {
+		if frame.Meta != nil {
+			meta := *frame.Meta
+			p.frame.Meta = &meta
+		}
+		for _, f := range frame.Fields {
+			if isPartition[f.Name] {
+				continue
+			}
+			field := data.NewFieldFromFieldType(f.Type(), len(p.rows))
+			field.Name = f.Name
+			field.Labels = f.Labels
+			field.Config = f.Config
+			for i, row := range p.rows {
+				field.Set(i, f.CopyAt(row))
+			}
+			p.frame.Fields = append(p.frame.Fields, field)
+		}
+	}
+	return partitions, nil
+}
+
+// addLabels adds labels to the fields of frame other than its time fields,
+// so that panels can tell the series of different frames apart.
+func addLabels(frame *data.Frame, labels data.Labels) {
+	if len(labels) == 0 {
+		return
+	}
+	for _, f := range frame.Fields {
+		if f.Type() == data.FieldTypeTime || f.Type() == data.FieldTypeNullableTime {
+			continue
+		}
+		merged := make(data.Labels, len(f.Labels)+len(labels))
+		for k, v := range f.Labels {
+			merged[k] = v
+		}
+		for k, v := range labels {
+			merged[k] = v
+		}
+		f.Labels = merged
+	}
+}
@@ -0,0 +1,94 @@
+package flightsql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/apache/arrow/go/v12/arrow"
+	"github.com/apache/arrow/go/v12/arrow/array"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestPartitionFrame(t *testing.T) {
+	frame := data.NewFrame("",
+		data.NewField("host", nil, []*string{ptr("a"), ptr("b"), ptr("a"), nil}),
+		data.NewField("region", nil, []string{"eu", "eu", "eu", "us"}),
+		data.NewField("value", nil, []int64{1, 2, 3, 4}),
+	)
+
+	partitions, err := partitionFrame(frame, []string{"host", "region"})
+	require.NoError(t, err)
+	require.Len(t, partitions, 3)
+
+	require.Equal(t, "host=a, region=eu", partitions[0].frame.Name)
+	require.Equal(t, data.Labels{"host": "a", "region": "eu"}, partitions[0].labels)
+	require.Len(t, partitions[0].frame.Fields, 1)
+	require.Equal(t, "value", partitions[0].frame.Fields[0].Name)
+	require.Equal(t, []int64{1, 3}, extractFieldValues[int64](t, partitions[0].frame.Fields[0]))
+
+	require.Equal(t, "host=b, region=eu", partitions[1].frame.Name)
+	require.Equal(t, []int64{2}, extractFieldValues[int64](t, partitions[1].frame.Fields[0]))
+
+	// Nulls are partitioned as empty values.
+	require.Equal(t, "host=, region=us", partitions[2].frame.Name)
+	require.Equal(t, []int64{4}, extractFieldValues[int64](t, partitions[2].frame.Fields[0]))
+
+	_, err = partitionFrame(frame, []string{"missing"})
+	require.EqualError(t, err, `partition column "missing" not found`)
+}
+
+func TestNewQueryDataResponse_PartitionBy(t *testing.T) {
+	schema := arrow.NewSchema([]arrow.Field{
+		{Name: "time", Type: &arrow.TimestampType{Unit: arrow.Second}},
+		{Name: "host", Type: arrow.BinaryTypes.String},
+		{Name: "value", Type: arrow.PrimitiveTypes.Int64},
+	}, nil)
+	newReader := func() errReader {
+		record := array.NewRecord(schema, []arrow.Array{
+			arrayFromJSON(t, schema.Field(0).Type, `[1, 1, 2, 2]`),
+			arrayFromJSON(t, schema.Field(1).Type, `["a", "b", "a", "b"]`),
+			arrayFromJSON(t, schema.Field(2).Type, `[1, 2, 3, 4]`),
+		}, 4)
+		reader, err := array.NewRecordReader(schema, []arrow.Record{record})
+		require.NoError(t, err)
+		return errReader{RecordReader: reader}
+	}
+
+	resp, _ := newQueryDataResponse(newReader(), sqlQuery{
+		Query:        sqlutil.Query{Format: sqlutil.FormatOptionTable},
+		PartitionBy:  []string{"host"},
+		MaxFrameRows: 1,
+	}, metadata.MD{})
+	require.NoError(t, resp.Error)
+	require.Len(t, resp.Frames, 2)
+	for i, host := range []string{"a", "b"} {
+		frame := resp.Frames[i]
+		require.Equal(t, "host="+host, frame.Name)
+		require.Equal(t, data.FrameTypeTable, frame.Meta.Type)
+		require.Len(t, frame.Fields, 2)
+		require.Nil(t, frame.Fields[0].Labels)
+		require.Equal(t, data.Labels{"host": host}, frame.Fields[1].Labels)
+	}
+	require.Equal(t, []int64{1, 3}, extractFieldValues[int64](t, resp.Frames[0].Fields[1]))
+
+	resp, _ = newQueryDataResponse(newReader(), sqlQuery{
+		Query:       sqlutil.Query{Format: sqlutil.FormatOptionTimeSeries},
+		PartitionBy: []string{"host"},
+	}, metadata.MD{})
+	require.NoError(t, resp.Error)
+	require.Len(t, resp.Frames, 2)
+	require.Equal(t, "host=b", resp.Frames[1].Name)
+	require.Equal(t, data.FrameTypeTimeSeriesWide, resp.Frames[1].Meta.Type)
+	require.Equal(t, []time.Time{time.Unix(1, 0).UTC(), time.Unix(2, 0).UTC()}, extractFieldValues[time.Time](t, resp.Frames[1].Fields[0]))
+	require.Equal(t, data.Labels{"host": "b"}, resp.Frames[1].Fields[1].Labels)
+	require.Equal(t, []int64{2, 4}, extractFieldValues[int64](t, resp.Frames[1].Fields[1]))
+
+	resp, _ = newQueryDataResponse(newReader(), sqlQuery{
+		Query:       sqlutil.Query{Format: sqlutil.FormatOptionTimeSeries},
+		PartitionBy: []string{"time"},
+	}, metadata.MD{})
+	require.EqualError(t, resp.Error, "time series can't be partitioned by their time column")
+}
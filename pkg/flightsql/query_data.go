@@ -2,15 +2,27 @@ package flightsql
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"regexp"
 	"runtime/debug"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
+	"github.com/apache/arrow/go/v12/arrow/flight"
+	"github.com/apache/arrow/go/v12/arrow/flight/flightsql"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
 	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
+	"go.opentelemetry.io/otel/attribute"
+	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
 // QueryData executes batches of ad-hoc queries and returns a batch of results.
@@ -19,6 +31,8 @@ func (d *FlightSQLDatasource) QueryData(ctx context.Context, req *backend.QueryD
 		wg             sync.WaitGroup
 		response       = backend.NewQueryDataResponse()
 		executeResults = make(chan executeResult, len(req.Queries))
+		origin         = newQueryOrigin(req.PluginContext, req.GetHTTPHeader)
+		forwarded      = d.forwardedMetadata(req.PluginContext, req.GetHTTPHeader)
 	)
 
 	for _, dataQuery := range req.Queries {
@@ -27,15 +41,37 @@ func (d *FlightSQLDatasource) QueryData(ctx context.Context, req *backend.QueryD
 			response.Responses[dataQuery.RefID] = backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
 			continue
 		}
+		if err := d.checkQueryMetadata(query.Metadata); err != nil {
+			response.Responses[dataQuery.RefID] = backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+			continue
+		}
+		query.Metadata = withForwarded(query.Metadata, forwarded)
+		query.Origin = origin
+		if err := d.adhocQuery(ctx, query); err != nil {
+			response.Responses[dataQuery.RefID] = backend.ErrDataResponse(backend.StatusBadRequest, err.Error())
+			continue
+		}
+		d.limitQuery(query)
 
 		wg.Add(1)
-		go func() {
+		go func(dataQuery backend.DataQuery) {
 			defer wg.Done()
+			resp := d.cachedQuery(ctx, *query)
+			if query.Stream && resp.Error == nil {
+				if len(resp.Frames) == 0 {
+					resp.Frames = data.Frames{data.NewFrame("")}
+				}
+				frame := resp.Frames[0]
+				if frame.Meta == nil {
+					frame.SetMeta(&data.FrameMeta{})
+				}
+				frame.Meta.Channel = d.registerStream(req.PluginContext, dataQuery, forwarded, origin)
+			}
 			executeResults <- executeResult{
 				refID:        query.RefID,
-				dataResponse: d.query(ctx, *query),
+				dataResponse: resp,
 			}
-		}()
+		}(dataQuery)
 	}
 
 	wg.Wait()
@@ -47,9 +83,89 @@ func (d *FlightSQLDatasource) QueryData(ctx context.Context, req *backend.QueryD
 	return response, nil
 }
 
+// sqlQuery is a [sqlutil.Query] along with the per-query settings that it
+// doesn't model.
+type sqlQuery struct {
+	sqlutil.Query
+
+	// Database overrides the datasource's default database.
+	Database string
+
+	// Metadata is sent along with the datasource's metadata, none of whose
+	// keys it may set.
+	Metadata metadata.MD
+
+	// Stream re-executes the query on an interval over Grafana Live.
+	Stream bool
+
+	// Expansion is the time taken to expand the query's macros.
+	Expansion time.Duration
+
+	// Variable shapes the results into the options of a template variable.
+	Variable bool
+
+	// AdhocFilters are the dashboard's ad hoc filters, applied to RawSQL by
+	// adhocQuery.
+	AdhocFilters []adhocFilter
+
+	// Statement executes the query with ExecuteUpdate, for statements such
+	// as INSERT and CREATE that don't return results.
+	Statement bool
+
+	// MaxFrameRows bounds the number of rows in each frame of a table
+	// result, but not the size of the result as a whole. Zero means a single
+	// frame.
+	MaxFrameRows int64
+
+	// MaxRows is the number of rows after which results are truncated.
+	MaxRows int64
+
+	// MaxBytes is the memory budget for reading the results. Zero means
+	// defaultMemoryBudget.
+	MaxBytes int64
+
+	// DecimalAsString converts decimals to their exact string representation
+	// rather than to float64.
+	DecimalAsString bool
+
+	// BinaryEncoding is the encoding of binary values as strings.
+	BinaryEncoding string
+
+	// Parameters are bound to the query, which is then executed as a
+	// prepared statement.
+	Parameters []json.RawMessage
+
+	// Logs designates the columns of a query in the logs format.
+	Logs logColumns
+
+	// Labels names the columns that label the series of a time series
+	// query. By default every string and bool column does.
+	Labels []string
+
+	// PartitionBy names the columns whose distinct values split the results
+	// of table and time series queries into a frame each.
+	PartitionBy []string
+
+	// ColumnRules configure how the fields of the results are displayed.
+	// The query's rules follow the datasource's, so that they take
+	// precedence.
+	ColumnRules []columnRule
+
+	// FillInterval is the length of the intervals whose gaps are filled as
+	// FillMissing specifies.
+	FillInterval time.Duration
+
+	// Location is the time zone of timestamps stored without one. Nil means
+	// UTC.
+	Location *time.Location
+
+	// Origin identifies who ran the query and from where.
+	Origin queryOrigin
+}
+
 // decodeQueryRequest decodes a [backend.DataQuery] and returns a
-// [*sqlutil.Query] where all macros are expanded.
-func decodeQueryRequest(dataQuery backend.DataQuery) (*sqlutil.Query, error) {
+// [*sqlQuery] where all macros are expanded.
+func decodeQueryRequest(dataQuery backend.DataQuery) (*sqlQuery, error) {
 	var q queryRequest
 	if err := json.Unmarshal(dataQuery.JSON, &q); err != nil {
 		return nil, fmt.Errorf("unmarshal json: %w", err)
@@ -57,33 +173,225 @@ func decodeQueryRequest(dataQuery backend.DataQuery) (*sqlutil.Query, error) {
 
 	var format sqlutil.FormatQueryOption
 	switch q.Format {
-	case "time_series":
+	case "", "time_series":
 		format = sqlutil.FormatOptionTimeSeries
 	case "table":
 		format = sqlutil.FormatOptionTable
+	case "logs":
+		format = sqlutil.FormatOptionLogs
 	default:
-		format = sqlutil.FormatOptionTimeSeries
+		return nil, fmt.Errorf("unsupported format: %s", q.Format)
+	}
+
+	if q.Statement && q.Stream {
+		return nil, fmt.Errorf("statements can't be streamed")
+	}
+
+	// Variable queries are read as tables, whatever format they were saved
+	// with.
+	variable := dataQuery.QueryType == variableQueryType
+	if variable {
+		if q.Statement || q.Stream {
+			return nil, fmt.Errorf("variable queries can't be statements or streamed")
+		}
+		format = sqlutil.FormatOptionTable
+		q.PartitionBy = nil
+	}
+
+	if len(q.PartitionBy) > 0 && format == sqlutil.FormatOptionLogs {
+		return nil, fmt.Errorf("logs can't be partitioned")
+	}
+
+	if err := validateColumnRules(q.ColumnRules); err != nil {
+		return nil, err
+	}
+
+	md := metadata.MD{}
+	for _, m := range q.Metadata {
+		for k, v := range m {
+			if k == "" {
+				continue
+			}
+			if len(md.Get(k)) != 0 {
+				return nil, fmt.Errorf("duplicate metadata key: %s", k)
+			}
+			md.Set(k, v)
+		}
+	}
+
+	// The time taken to generate the SQL is reported as part of the query's
+	// timings.
+	start := time.Now()
+
+	if q.Builder != nil && strings.TrimSpace(q.Text) == "" {
+		text, err := q.Builder.sql()
+		if err != nil {
+			return nil, err
+		}
+		q.Text = text
+	}
+
+	if q.BindVariables && len(q.Variables) > 0 {
+		if len(q.Parameters) > 0 {
+			return nil, errVariablesWithParameters
+		}
+		text, params, err := bindVariables(q.Text, q.Variables)
+		if err != nil {
+			return nil, err
+		}
+		q.Text, q.Parameters = text, params
+	}
+
+	// Time macros expand to literals in the location of the time range.
+	timeRange := backend.TimeRange{From: dataQuery.TimeRange.From.UTC(), To: dataQuery.TimeRange.To.UTC()}
+	var loc *time.Location
+	switch q.Timezone {
+	case "", "utc":
+	case "dashboard", "browser":
+		// The frontend resolves these to a zone, but queries that don't go
+		// through it, such as those of alerts, are run in UTC.
+	default:
+		var err error
+		if loc, err = time.LoadLocation(q.Timezone); err != nil {
+			return nil, fmt.Errorf("unsupported timezone: %s", q.Timezone)
+		}
+		timeRange = backend.TimeRange{From: timeRange.From.In(loc), To: timeRange.To.In(loc)}
+	}
+
+	q.Text = expandSearchFilter(q.Text, q.SearchFilter)
+
+	query := &sqlQuery{
+		Query: sqlutil.Query{
+			RawSQL:        q.Text,
+			RefID:         q.RefID,
+			MaxDataPoints: q.MaxDataPoints,
+			Interval:      time.Duration(q.IntervalMilliseconds) * time.Millisecond,
+			TimeRange:     timeRange,
+			Format:        format,
+		},
+		Database:    q.Database,
+		Metadata:    md,
+		Variable:    variable,
+		Stream:      q.Stream,
+		Statement:   q.Statement,
+		Parameters:  q.Parameters,
+		Logs:        q.Logs,
+		Labels:      q.Labels,
+		PartitionBy: q.PartitionBy,
+		ColumnRules: q.ColumnRules,
+		Location:    loc,
 	}
 
-	query := &sqlutil.Query{
-		RawSQL:        q.Text,
-		RefID:         q.RefID,
-		MaxDataPoints: q.MaxDataPoints,
-		Interval:      time.Duration(q.IntervalMilliseconds) * time.Millisecond,
-		TimeRange:     dataQuery.TimeRange,
-		Format:        format,
+	query.FillMissing, query.FillInterval = macroFillMissing(q.Text)
+	if query.FillInterval == 0 {
+		query.FillInterval = query.Interval
 	}
 
 	// Process macros and execute the query.
-	sql, err := sqlutil.Interpolate(query, macros)
+	sql, err := sqlutil.Interpolate(&query.Query, macros)
 	if err != nil {
 		return nil, fmt.Errorf("macro interpolation: %w", err)
 	}
+	// Ad hoc filters restrict the rows queries return, so they don't apply
+	// to statements or to the options of variables.
+	if !q.Statement && !variable {
+		query.AdhocFilters = q.AdhocFilters
+	}
 	query.RawSQL = sql
+	query.Expansion = time.Since(start)
 
 	return query, nil
 }
 
+// limitPattern matches a LIMIT clause.
+var limitPattern = regexp.MustCompile(`(?i)\blimit\s+\d+`)
+
+// limitQuery limits a query to its max data points when the datasource is
+// configured to. Statements don't return rows to limit, and variable queries
+// return options rather than data points, so they are left alone.
+func (d *FlightSQLDatasource) limitQuery(query *sqlQuery) {
+	if d.cfg.LimitMaxDataPoints && !query.Statement && !query.Variable {
+		query.RawSQL = injectLimit(query.RawSQL, query.MaxDataPoints)
+	}
+}
+
+// injectLimit appends a LIMIT clause to sql when it doesn't already end with
+// one. Limits in subqueries, string literals and comments don't count, and
+// the clause is on its own line so that a trailing comment doesn't comment it
+// out.
+func injectLimit(sql string, limit int64) string {
+	if limit <= 0 {
+		return sql
+	}
+	sql = strings.TrimSpace(sql)
+	top := topLevelSQL(sql)
+	if limitPattern.MatchString(top) {
+		return sql
+	}
+	// Drop trailing semicolons, which may be followed by a comment.
+	for {
+		i := strings.LastIndexFunc(top, func(r rune) bool { return r != ' ' && r != '\t' && r != '\n' && r != '\r' })
+		if i == -1 || top[i] != ';' {
+			break
+		}
+		sql = sql[:i] + sql[i+1:]
+		top = top[:i] + top[i+1:]
+	}
+	return fmt.Sprintf("%s\nLIMIT %d", strings.TrimSpace(sql), limit)
+}
+
+// topLevelSQL returns sql with its string literals, quoted identifiers,
+// comments and parenthesized parts, such as subqueries, blanked out with
+// spaces, leaving the text of the outermost statement at the same offsets.
+func topLevelSQL(sql string) string {
+	out := []byte(sql)
+	depth := 0
+	for i := 0; i < len(sql); i++ {
+		start := i
+		switch {
+		case sql[i] == '\'' || sql[i] == '"':
+			// Quotes are escaped by doubling them, which scans as two
+			// adjacent quoted parts.
+			end := strings.IndexByte(sql[i+1:], sql[i])
+			if end == -1 {
+				i = len(sql) - 1
+			} else {
+				i += end + 1
+			}
+		case strings.HasPrefix(sql[i:], "--"):
+			end := strings.IndexByte(sql[i:], '\n')
+			if end == -1 {
+				i = len(sql) - 1
+			} else {
+				i += end - 1
+			}
+		case strings.HasPrefix(sql[i:], "/*"):
+			end := strings.Index(sql[i+2:], "*/")
+			if end == -1 {
+				i = len(sql) - 1
+			} else {
+				i += end + 3
+			}
+		case sql[i] == '(':
+			depth++
+		case sql[i] == ')':
+			if depth > 0 {
+				depth--
+			}
+		default:
+			if depth == 0 {
+				continue
+			}
+		}
+		for j := start; j <= i; j++ {
+			if out[j] != '\n' {
+				out[j] = ' '
+			}
+		}
+	}
+	return string(out)
+}
+
 // executeResult is an envelope for concurrent query responses.
 type executeResult struct {
 	refID        string
@@ -98,38 +406,569 @@ type queryRequest struct {
 	IntervalMilliseconds int    `json:"intervalMs"`
 	MaxDataPoints        int64  `json:"maxDataPoints"`
 	Format               string `json:"format"`
+	Database             string `json:"database,omitempty"`
+	Stream               bool   `json:"stream,omitempty"`
+	// Statement executes the query as an update, returning the number of
+	// affected rows.
+	Statement bool `json:"statement,omitempty"`
+	// Parameters are bound positionally to placeholders in the query text.
+	Parameters []json.RawMessage `json:"parameters,omitempty"`
+	// BindVariables binds the values of Variables as parameters in place of
+	// their references in the query text.
+	BindVariables bool                       `json:"bindVariables,omitempty"`
+	Variables     map[string]json.RawMessage `json:"variables,omitempty"`
+	// Builder is compiled to SQL when no query text is given.
+	Builder *builderQuery `json:"builder,omitempty"`
+	// Logs designates the columns of a query in the logs format.
+	Logs logColumns `json:"logs,omitempty"`
+	// Labels names the columns that label the series of a time series query.
+	Labels []string `json:"labels,omitempty"`
+	// PartitionBy names the columns whose distinct values split the results
+	// into separate frames.
+	PartitionBy []string `json:"partitionBy,omitempty"`
+	// ColumnRules configure how columns are displayed, in addition to the
+	// datasource's rules.
+	ColumnRules []columnRule `json:"columnRules,omitempty"`
+	// Timezone is the IANA time zone that timestamps without a time zone are
+	// in, such as those of servers that store local times.
+	Timezone string `json:"timezone,omitempty"`
+	// Metadata is sent with this query alone, such as hints for the
+	// server's workload management.
+	Metadata []map[string]string `json:"metadata,omitempty"`
+	// SearchFilter is the search term of a variable query, which
+	// $__searchFilter expands to a pattern matching.
+	SearchFilter string `json:"searchFilter,omitempty"`
+	// AdhocFilters are the dashboard's ad hoc filters for the datasource.
+	AdhocFilters []adhocFilter `json:"adhocFilters,omitempty"`
 }
 
 // query executes a SQL statement by issuing a `CommandStatementQuery` command to Flight SQL.
-func (d *FlightSQLDatasource) query(ctx context.Context, query sqlutil.Query) (resp backend.DataResponse) {
+func (d *FlightSQLDatasource) query(ctx context.Context, query sqlQuery) (resp backend.DataResponse) {
 	defer func() {
 		if r := recover(); r != nil {
-			logErrorf("Panic: %s %s", r, string(debug.Stack()))
+			log.DefaultLogger.Error("Panic", "refId", query.RefID, "error", r, "stack", string(debug.Stack()))
 			resp = backend.ErrDataResponse(backend.StatusInternal, fmt.Sprintf("panic: %s", r))
 		}
 	}()
 
-	if d.md.Len() != 0 {
-		ctx = metadata.NewOutgoingContext(ctx, d.md)
+	queryStart := time.Now()
+	var (
+		code  codes.Code
+		stats executeStats
+	)
+	defer func() {
+		d.logQuery(queryLog{
+			refID:     query.RefID,
+			sql:       query.RawSQL,
+			timeRange: query.TimeRange,
+			duration:  time.Since(queryStart),
+			stats:     stats,
+			rows:      frameRows(resp.Frames),
+			err:       resp.Error,
+			code:      code,
+		})
+		if d.cfg.AuditLog {
+			d.auditQuery(query, resp.Error)
+		}
+		if d.instanceStats != nil {
+			d.instanceStats.RecordQuery(time.Since(queryStart), resp.Error)
+		}
+	}()
+
+	ctx, span := startSpan(ctx, "flightsql.query",
+		attribute.String("grafana.ref_id", query.RefID),
+		attribute.String("db.statement", query.RawSQL),
+	)
+	defer func() { endSpan(span, resp.Error) }()
+
+	done, err := d.startQuery()
+	if err != nil {
+		return backend.ErrDataResponse(backend.StatusInternal, err.Error())
 	}
+	defer done()
 
-	info, err := d.client.Execute(ctx, query.RawSQL)
+	release, err := d.acquireQuerySlot(ctx)
 	if err != nil {
-		return backend.ErrDataResponse(backend.StatusInternal, fmt.Sprintf("flightsql: %s", err))
+		return backend.ErrDataResponse(backend.StatusTooManyRequests, err.Error())
 	}
-	if len(info.Endpoint) != 1 {
-		return backend.ErrDataResponse(backend.StatusInternal, fmt.Sprintf("unsupported endpoint count in response: %d", len(info.Endpoint)))
+	defer release()
+
+	// Cancelling the context releases the stream if reading stops early,
+	// and the query is cancelled if the datasource is disposed.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func(ctx context.Context) {
+		select {
+		case <-d.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}(ctx)
+
+	if md := d.queryMetadata(query); md.Len() != 0 {
+		ctx = metadata.NewOutgoingContext(ctx, md)
+	}
+
+	defer func() { observeQuery(resp.Error, stats, frameRows(resp.Frames)) }()
+	if query.Statement {
+		// Statements are executed with a single DoPut, and prepared
+		// statements are also created and closed.
+		stats.roundTrips = 1
+		if len(query.Parameters) > 0 {
+			stats.roundTrips += 2
+		}
+		start := time.Now()
+		executeCtx, executeSpan := startSpan(ctx, "flightsql.ExecuteUpdate")
+		n, err := d.executeUpdate(executeCtx, query)
+		endSpan(executeSpan, err)
+		stats.execute = time.Since(start)
+		if d.breaker != nil {
+			d.breaker.Record(err)
+		}
+		if err != nil {
+			code = status.Code(err)
+			observeGRPCError(err)
+			return backend.ErrDataResponse(backend.StatusInternal, fmt.Sprintf("flightsql: %s", err))
+		}
+		resp = affectedRowsResponse(n, query)
+		resp.Frames[0].Meta.Stats = stats.queryStats()
+		setTimings(resp.Frames, newQueryTimings(query.Expansion, stats))
+		return resp
+	}
+
+	query.MaxFrameRows = d.cfg.MaxFrameRows
+	query.MaxRows = d.cfg.MaxRows
+	query.MaxBytes = int64(d.cfg.MaxQueryMemoryMB) * 1024 * 1024
+	query.DecimalAsString = d.cfg.DecimalAsString
+	query.BinaryEncoding = d.cfg.BinaryEncoding
+	rules := d.cfg.ColumnRules
+	query.ColumnRules = append(rules[:len(rules):len(rules)], query.ColumnRules...)
+
+	// Reading the results fails when the stream is interrupted, in which
+	// case the query is executed again, since tickets may only be valid
+	// once.
+	for attempt := 0; ; attempt++ {
+		var readErr error
+		resp, readErr, err = d.fetch(ctx, query, &stats)
+		if err == nil {
+			err = readErr
+		}
+		if readErr == nil || attempt == d.cfg.DoGetRetries || !retryable(readErr) || ctx.Err() != nil {
+			break
+		}
+		log.DefaultLogger.Warn("Reading results failed, retrying query", "refId", query.RefID, "attempt", attempt+1, "error", readErr)
 	}
+	if d.breaker != nil {
+		d.breaker.Record(err)
+	}
+	if err != nil {
+		if st, ok := grpcStatus(err); ok {
+			code = st.Code()
+		}
+		observeGRPCError(err)
+	}
+	setTimings(resp.Frames, newQueryTimings(query.Expansion, stats))
+	return resp
+}
+
+// fetch executes a query and reads its results, adding to stats. It returns
+// the error of the call that failed, if any: readErr if it was reading the
+// results, even if some rows were read, and err if it was executing the query.
+func (d *FlightSQLDatasource) fetch(ctx context.Context, query sqlQuery, stats *executeStats) (resp backend.DataResponse, readErr, err error) {
+	// Statements are executed with GetFlightInfo and their results read
+	// with DoGet, and prepared statements are also created and closed.
+	stats.roundTrips += 2
+	if len(query.Parameters) > 0 {
+		stats.roundTrips += 2
+	}
+	start := time.Now()
+	executeCtx, executeSpan := startSpan(ctx, "flightsql.Execute")
+	info, closeStmt, err := d.execute(executeCtx, query)
+	endSpan(executeSpan, err)
+	stats.execute = time.Since(start)
+	if err != nil {
+		return backend.ErrDataResponse(backend.StatusInternal, fmt.Sprintf("flightsql: %s", err)), nil, err
+	}
+	defer closeStmt()
+
+	switch len(info.Endpoint) {
+	case 0:
+		// Statements such as DDL and SET have no results to read.
+		return schemaResponse(info.Schema, query), nil, nil
+	case 1:
+	default:
+		return backend.ErrDataResponse(backend.StatusInternal, fmt.Sprintf("unsupported endpoint count in response: %d", len(info.Endpoint))), nil, nil
+	}
+	start = time.Now()
+	// The span of reading the results includes converting them, which is
+	// interleaved with reading.
+	ctx, readSpan := startSpan(ctx, "flightsql.DoGet")
+	defer func() {
+		readSpan.SetAttributes(attribute.Int("flightsql.rows", frameRows(resp.Frames)))
+		endSpan(readSpan, resp.Error)
+	}()
 	reader, err := d.client.DoGetWithHeaderExtraction(ctx, info.Endpoint[0].Ticket)
 	if err != nil {
-		return backend.ErrDataResponse(backend.StatusInternal, fmt.Sprintf("flightsql: %s", err))
+		return backend.ErrDataResponse(backend.StatusInternal, fmt.Sprintf("flightsql: %s", err)), err, nil
 	}
 	defer reader.Release()
 
 	headers, err := reader.Header()
 	if err != nil {
-		logErrorf("Failed to extract headers: %s", err)
+		log.DefaultLogger.Error("Failed to extract headers", "refId", query.RefID, "error", err)
+	}
+
+	resp, read := newQueryDataResponse(reader, query, headers)
+	stats.fetch = time.Since(start)
+	stats.convert = read.convert
+	for _, frame := range resp.Frames {
+		frame.Meta.Stats = append(frame.Meta.Stats, stats.queryStats()...)
+	}
+	return resp, reader.Err(), nil
+}
+
+// executeUpdate executes a statement, as a prepared statement when it has
+// parameters, returning the number of rows it affected.
+func (d *FlightSQLDatasource) executeUpdate(ctx context.Context, query sqlQuery) (int64, error) {
+	if len(query.Parameters) == 0 {
+		return d.client.ExecuteUpdate(ctx, query.RawSQL)
+	}
+
+	stmt, err := d.client.Prepare(ctx, query.RawSQL)
+	if err != nil {
+		return 0, err
+	}
+	defer closeStatement(ctx, stmt, query.RefID)
+	params, err := parameterRecord(stmt.ParameterSchema(), query.Parameters)
+	if err != nil {
+		return 0, err
+	}
+	defer params.Release()
+	stmt.SetParameters(params)
+	return stmt.ExecuteUpdate(ctx)
+}
+
+// affectedRowsResponse returns a frame with the number of rows affected by a
+// statement.
+func affectedRowsResponse(n int64, query sqlQuery) backend.DataResponse {
+	frame := data.NewFrame("", data.NewField("affected_rows", nil, []int64{n}))
+	frame.SetMeta(&data.FrameMeta{
+		ExecutedQueryString:    query.RawSQL,
+		Type:                   data.FrameTypeTable,
+		PreferredVisualization: data.VisTypeTable,
+	})
+	return backend.DataResponse{Frames: data.Frames{frame}}
+}
+
+// frameRows returns the total number of rows in frames.
+func frameRows(frames data.Frames) int {
+	var rows int
+	for _, frame := range frames {
+		rows += frame.Rows()
+	}
+	return rows
+}
+
+// execute issues a query, as a prepared statement when it has parameters,
+// returning a function that closes the statement once its results are read.
+func (d *FlightSQLDatasource) execute(ctx context.Context, query sqlQuery) (*flight.FlightInfo, func(), error) {
+	if len(query.Parameters) == 0 {
+		info, err := d.client.Execute(ctx, query.RawSQL)
+		return info, func() {}, err
+	}
+
+	stmt, err := d.client.Prepare(ctx, query.RawSQL)
+	if err != nil {
+		return nil, nil, err
+	}
+	closeStmt := func() { closeStatement(ctx, stmt, query.RefID) }
+	params, err := parameterRecord(stmt.ParameterSchema(), query.Parameters)
+	if err != nil {
+		closeStmt()
+		return nil, nil, err
 	}
+	defer params.Release()
+	stmt.SetParameters(params)
+	info, err := stmt.Execute(ctx)
+	if err != nil {
+		closeStmt()
+		return nil, nil, err
+	}
+	return info, closeStmt, nil
+}
+
+// closeStatementTimeout bounds the time taken to close a prepared statement.
+const closeStatementTimeout = 5 * time.Second
 
-	return newQueryDataResponse(reader, query, headers)
+// closeStatement closes a prepared statement. It is closed with a context of
+// its own, carrying the query's metadata, so that the statements of cancelled
+// queries are still closed on the server.
+func closeStatement(ctx context.Context, stmt *flightsql.PreparedStatement, refID string) {
+	md, _ := metadata.FromOutgoingContext(ctx)
+	ctx, cancel := context.WithTimeout(metadata.NewOutgoingContext(context.Background(), md), closeStatementTimeout)
+	defer cancel()
+	if err := stmt.Close(ctx); err != nil {
+		log.DefaultLogger.Error("Failed to close prepared statement", "refId", refID, "error", err)
+	}
+}
+
+// queryCacheSize is the maximum number of results held in the query cache.
+const queryCacheSize = 100
+
+// cachedQuery serves a query from the result cache if it's enabled, otherwise
+// executing the query and caching its result.
+func (d *FlightSQLDatasource) cachedQuery(ctx context.Context, query sqlQuery) backend.DataResponse {
+	// Statements change data, so they are executed every time.
+	if d.queryCache == nil || query.Statement {
+		return d.breakerQuery(ctx, query)
+	}
+	key, err := queryCacheKey(query)
+	if err != nil {
+		log.DefaultLogger.Error("Failed to build query cache key", "refId", query.RefID, "error", err)
+		return d.breakerQuery(ctx, query)
+	}
+	resp, ok := d.queryCache.Get(key)
+	if d.instanceStats != nil {
+		d.instanceStats.RecordCache(ok)
+	}
+	if ok {
+		return copyResponse(resp)
+	}
+	resp = d.breakerQuery(ctx, query)
+	// Results with notices may be incomplete, so only clean results are
+	// cached.
+	if resp.Error == nil && !hasNotices(resp) {
+		d.queryCache.Set(key, copyResponse(resp))
+	}
+	return resp
+}
+
+// copyResponse returns resp with a deep copy of its frames, so that cached
+// results aren't shared between the responses they are served in, which may
+// change them, as streaming queries do by setting their channel.
+func copyResponse(resp backend.DataResponse) backend.DataResponse {
+	frames := make(data.Frames, len(resp.Frames))
+	for i, frame := range resp.Frames {
+		out := frame.EmptyCopy()
+		if frame.Meta != nil {
+			meta := *frame.Meta
+			out.Meta = &meta
+		}
+		for j, f := range frame.Fields {
+			if f.Config != nil {
+				cfg := *f.Config
+				out.Fields[j].Config = &cfg
+			}
+			out.Fields[j].Extend(f.Len())
+			for row := 0; row < f.Len(); row++ {
+				out.Fields[j].Set(row, f.CopyAt(row))
+			}
+		}
+		frames[i] = out
+	}
+	resp.Frames = frames
+	return resp
+}
+
+// breakerQuery executes a query unless the circuit breaker is open.
+func (d *FlightSQLDatasource) breakerQuery(ctx context.Context, query sqlQuery) backend.DataResponse {
+	if d.breaker != nil {
+		if err := d.breaker.Allow(); err != nil {
+			return backend.ErrDataResponse(backend.StatusBadGateway, err.Error())
+		}
+	}
+	return d.query(ctx, query)
+}
+
+// hasNotices reports whether any frame of resp has notices.
+func hasNotices(resp backend.DataResponse) bool {
+	for _, frame := range resp.Frames {
+		if frame.Meta != nil && len(frame.Meta.Notices) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// queryCacheKey identifies the results of a query. The forwarded metadata is
+// part of the key so that results are never shared between identities.
+func queryCacheKey(query sqlQuery) (string, error) {
+	var location string
+	if query.Location != nil {
+		location = query.Location.String()
+	}
+	b, err := json.Marshal(struct {
+		SQL             string
+		Database        string
+		From, To        time.Time
+		Format          sqlutil.FormatQueryOption
+		Variable        bool
+		Stream          bool
+		MaxFrameRows    int64
+		MaxRows         int64
+		DecimalAsString bool
+		BinaryEncoding  string
+		Logs            logColumns
+		Labels          []string
+		PartitionBy     []string
+		ColumnRules     []columnRule
+		FillMissing     *data.FillMissing
+		FillInterval    time.Duration
+		Location        string
+		Metadata        metadata.MD
+		Parameters      []json.RawMessage
+	}{
+		SQL:             query.RawSQL,
+		Database:        query.Database,
+		From:            query.TimeRange.From,
+		To:              query.TimeRange.To,
+		Format:          query.Format,
+		Variable:        query.Variable,
+		Stream:          query.Stream,
+		MaxFrameRows:    query.MaxFrameRows,
+		MaxRows:         query.MaxRows,
+		DecimalAsString: query.DecimalAsString,
+		BinaryEncoding:  query.BinaryEncoding,
+		Logs:            query.Logs,
+		Labels:          query.Labels,
+		PartitionBy:     query.PartitionBy,
+		ColumnRules:     query.ColumnRules,
+		FillMissing:     query.FillMissing,
+		FillInterval:    query.FillInterval,
+		Location:        location,
+		Metadata:        query.Metadata,
+		Parameters:      query.Parameters,
+	})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// acquireQuerySlot blocks until fewer than the configured maximum number of
+// queries are running, returning a function that frees the slot.
+func (d *FlightSQLDatasource) acquireQuerySlot(ctx context.Context) (func(), error) {
+	if d.querySlots == nil {
+		return func() {}, nil
+	}
+	select {
+	case d.querySlots <- struct{}{}:
+		return func() { <-d.querySlots }, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("gave up waiting for one of %d concurrent query slots: %w", cap(d.querySlots), ctx.Err())
+	}
+}
+
+// queryMetadata returns the outgoing metadata for a query, adding its own
+// metadata and database to the datasource's metadata.
+func (d *FlightSQLDatasource) queryMetadata(query sqlQuery) metadata.MD {
+	if query.Database == "" && query.Metadata.Len() == 0 && d.tokens == nil {
+		return d.md
+	}
+	md := d.md.Copy()
+	switch {
+	case d.cfg.OAuthPassThru && len(query.Metadata.Get("authorization")) != 0:
+		// The user's own token is sent instead of the datasource's
+		// credential, so that the server sees a single identity.
+		md.Delete(d.cfg.authHeader())
+	case d.tokens != nil:
+		md.Set(d.cfg.authHeader(), d.cfg.credential(d.tokens.Token()))
+	}
+	for k, v := range query.Metadata {
+		md.Set(k, v...)
+	}
+	if query.Database != "" {
+		md.Set(d.cfg.databaseKey(), query.Database)
+	}
+	return md
+}
+
+// checkQueryMetadata checks that a query's own metadata doesn't set any of
+// the keys set by the datasource's settings or authentication, so that
+// queries can't replace the credentials, secure metadata or defaults the
+// datasource was configured with.
+func (d *FlightSQLDatasource) checkQueryMetadata(md metadata.MD) error {
+	reserved := map[string]bool{
+		d.cfg.authHeader():                   true,
+		strings.ToLower(d.cfg.databaseKey()): true,
+		strings.ToLower(d.cfg.catalogKey()):  true,
+		strings.ToLower(d.cfg.schemaKey()):   true,
+	}
+	if d.cfg.OAuthPassThru {
+		reserved["authorization"] = true
+	}
+	for k := range d.md {
+		reserved[k] = true
+	}
+	for k := range md {
+		if reserved[strings.ToLower(k)] {
+			return fmt.Errorf("reserved metadata key: %s", k)
+		}
+	}
+	return nil
+}
+
+// withForwarded adds the forwarded metadata to a query's own metadata. The
+// forwarded metadata takes precedence so that queries can't impersonate
+// another user.
+func withForwarded(md, forwarded metadata.MD) metadata.MD {
+	if md.Len() == 0 {
+		return forwarded
+	}
+	md = md.Copy()
+	for k, v := range forwarded {
+		md.Set(k, v...)
+	}
+	return md
+}
+
+// forwardedMetadata returns the metadata derived from the plugin context and
+// the HTTP headers Grafana forwards along with a request.
+func (d *FlightSQLDatasource) forwardedMetadata(pCtx backend.PluginContext, header func(string) string) metadata.MD {
+	md := metadata.MD{}
+	if d.cfg.OAuthPassThru {
+		if token := header(backend.OAuthIdentityTokenHeaderName); token != "" {
+			md.Set("authorization", token)
+		}
+	}
+	if d.cfg.ForwardGrafanaUser {
+		origin := newQueryOrigin(pCtx, header)
+		if origin.User != "" {
+			md.Set("x-grafana-user", origin.User)
+		}
+		if origin.OrgID != 0 {
+			md.Set("x-grafana-org-id", strconv.FormatInt(origin.OrgID, 10))
+		}
+		if origin.DashboardUID != "" {
+			md.Set("x-grafana-dashboard-uid", origin.DashboardUID)
+		}
+		if origin.PanelID != "" {
+			md.Set("x-grafana-panel-id", origin.PanelID)
+		}
+	}
+	return md
+}
+
+// queryOrigin identifies the Grafana user, org, dashboard and panel that a
+// query was run by.
+type queryOrigin struct {
+	User         string
+	OrgID        int64
+	DashboardUID string
+	PanelID      string
+}
+
+// newQueryOrigin returns the origin of a request from its plugin context and
+// headers.
+func newQueryOrigin(pCtx backend.PluginContext, header func(string) string) queryOrigin {
+	origin := queryOrigin{
+		OrgID:        pCtx.OrgID,
+		DashboardUID: header("X-Dashboard-Uid"),
+		PanelID:      header("X-Panel-Id"),
+	}
+	if pCtx.User != nil {
+		origin.User = pCtx.User.Login
+	}
+	return origin
 }
@@ -0,0 +1,273 @@
+package flightsql
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestInjectLimit(t *testing.T) {
+	cs := []struct {
+		in    string
+		limit int64
+		out   string
+	}{
+		{
+			in:    `select * from cpu`,
+			limit: 100,
+			out:   "select * from cpu\nLIMIT 100",
+		},
+		{
+			in:    "select * from cpu;\n",
+			limit: 100,
+			out:   "select * from cpu\nLIMIT 100",
+		},
+		{
+			in:    `select * from cpu limit 10`,
+			limit: 100,
+			out:   `select * from cpu limit 10`,
+		},
+		{
+			in:    "select * from cpu\nLIMIT 10;",
+			limit: 100,
+			out:   "select * from cpu\nLIMIT 10;",
+		},
+		{
+			in:    `select * from cpu`,
+			limit: 0,
+			out:   `select * from cpu`,
+		},
+		{
+			in:    `select * from (select * from cpu limit 10) as t`,
+			limit: 100,
+			out:   "select * from (select * from cpu limit 10) as t\nLIMIT 100",
+		},
+		{
+			in:    `select 'limit 10' as s, "limit 5" from cpu`,
+			limit: 100,
+			out:   "select 'limit 10' as s, \"limit 5\" from cpu\nLIMIT 100",
+		},
+		{
+			in:    "select * from cpu /* limit 10 */",
+			limit: 100,
+			out:   "select * from cpu /* limit 10 */\nLIMIT 100",
+		},
+		{
+			in:    "select * from cpu -- limit 10",
+			limit: 100,
+			out:   "select * from cpu -- limit 10\nLIMIT 100",
+		},
+		{
+			in:    "select * from cpu; -- latest",
+			limit: 100,
+			out:   "select * from cpu -- latest\nLIMIT 100",
+		},
+	}
+	for _, c := range cs {
+		t.Run(c.in, func(t *testing.T) {
+			require.Equal(t, c.out, injectLimit(c.in, c.limit))
+		})
+	}
+}
+
+func TestAcquireQuerySlot(t *testing.T) {
+	ds := &FlightSQLDatasource{querySlots: make(chan struct{}, 1)}
+
+	release, err := ds.acquireQuerySlot(context.Background())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = ds.acquireQuerySlot(ctx)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	release()
+	release, err = ds.acquireQuerySlot(context.Background())
+	require.NoError(t, err)
+	release()
+}
+
+func TestDecodeQueryRequest_Format(t *testing.T) {
+	cs := map[string]sqlutil.FormatQueryOption{
+		"":            sqlutil.FormatOptionTimeSeries,
+		"time_series": sqlutil.FormatOptionTimeSeries,
+		"table":       sqlutil.FormatOptionTable,
+		"logs":        sqlutil.FormatOptionLogs,
+	}
+	for format, want := range cs {
+		query, err := decodeQueryRequest(backend.DataQuery{
+			JSON: []byte(`{"queryText": "select 1", "format": "` + format + `"}`),
+		})
+		require.NoError(t, err)
+		require.Equal(t, want, query.Format)
+	}
+
+	_, err := decodeQueryRequest(backend.DataQuery{
+		JSON: []byte(`{"queryText": "select 1", "format": "heatmap"}`),
+	})
+	require.EqualError(t, err, "unsupported format: heatmap")
+}
+
+func TestDecodeQueryRequest_PartitionBy(t *testing.T) {
+	query, err := decodeQueryRequest(backend.DataQuery{
+		JSON: []byte(`{"queryText": "select 1", "format": "table", "partitionBy": ["host"]}`),
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"host"}, query.PartitionBy)
+
+	_, err = decodeQueryRequest(backend.DataQuery{
+		JSON: []byte(`{"queryText": "select 1", "format": "logs", "partitionBy": ["host"]}`),
+	})
+	require.EqualError(t, err, "logs can't be partitioned")
+}
+
+func TestDecodeQueryRequest_ColumnRules(t *testing.T) {
+	query, err := decodeQueryRequest(backend.DataQuery{
+		JSON: []byte(`{"queryText": "select 1", "columnRules": [{"column": "bytes_sent", "unit": "bytes"}]}`),
+	})
+	require.NoError(t, err)
+	require.Equal(t, []columnRule{{Column: "bytes_sent", Unit: "bytes"}}, query.ColumnRules)
+
+	_, err = decodeQueryRequest(backend.DataQuery{
+		JSON: []byte(`{"queryText": "select 1", "columnRules": [{"unit": "bytes"}]}`),
+	})
+	require.EqualError(t, err, "column rule column is required")
+}
+
+func TestDecodeQueryRequest_Timezone(t *testing.T) {
+	from := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	query, err := decodeQueryRequest(backend.DataQuery{
+		JSON:      []byte(`{"queryText": "select $__timeFrom", "timezone": "Asia/Tokyo"}`),
+		TimeRange: backend.TimeRange{From: from, To: from.Add(time.Hour)},
+	})
+	require.NoError(t, err)
+	require.Equal(t, "Asia/Tokyo", query.Location.String())
+	require.Equal(t, "select cast('2023-01-01T09:00:00' as timestamp)", query.RawSQL)
+
+	_, err = decodeQueryRequest(backend.DataQuery{
+		JSON: []byte(`{"queryText": "select 1", "timezone": "Mars/Olympus"}`),
+	})
+	require.EqualError(t, err, "unsupported timezone: Mars/Olympus")
+
+	// Zones the frontend resolves are UTC for queries that bypass it.
+	for _, tz := range []string{"dashboard", "browser", "utc"} {
+		query, err = decodeQueryRequest(backend.DataQuery{
+			JSON:      []byte(`{"queryText": "select $__timeFrom", "timezone": "` + tz + `"}`),
+			TimeRange: backend.TimeRange{From: from, To: from.Add(time.Hour)},
+		})
+		require.NoError(t, err, tz)
+		require.Nil(t, query.Location)
+		require.Equal(t, "select cast('2023-01-01T00:00:00Z' as timestamp)", query.RawSQL)
+	}
+}
+
+func TestDecodeQueryRequest_Statement(t *testing.T) {
+	query, err := decodeQueryRequest(backend.DataQuery{
+		JSON: []byte(`{"queryText": "delete from t", "statement": true}`),
+	})
+	require.NoError(t, err)
+	require.True(t, query.Statement)
+
+	_, err = decodeQueryRequest(backend.DataQuery{
+		JSON: []byte(`{"queryText": "delete from t", "statement": true, "stream": true}`),
+	})
+	require.EqualError(t, err, "statements can't be streamed")
+}
+
+func TestDecodeQueryRequest_Metadata(t *testing.T) {
+	query, err := decodeQueryRequest(backend.DataQuery{
+		JSON: []byte(`{"queryText": "select 1", "metadata": [{"x-priority": "low"}, {"x-workload": "reports"}]}`),
+	})
+	require.NoError(t, err)
+	require.Equal(t, metadata.Pairs("x-priority", "low", "x-workload", "reports"), query.Metadata)
+
+	_, err = decodeQueryRequest(backend.DataQuery{
+		JSON: []byte(`{"queryText": "select 1", "metadata": [{"x-priority": "low"}, {"X-Priority": "high"}]}`),
+	})
+	require.EqualError(t, err, "duplicate metadata key: X-Priority")
+}
+
+func TestWithForwarded(t *testing.T) {
+	forwarded := metadata.Pairs("x-grafana-user", "jane")
+	require.Equal(t, forwarded, withForwarded(metadata.MD{}, forwarded))
+
+	md := metadata.Pairs("x-priority", "low", "x-grafana-user", "admin")
+	require.Equal(t, metadata.Pairs("x-priority", "low", "x-grafana-user", "jane"), withForwarded(md, forwarded))
+	require.Equal(t, []string{"admin"}, md.Get("x-grafana-user"))
+}
+
+func TestQueryCacheKey(t *testing.T) {
+	base := sqlQuery{Query: sqlutil.Query{RawSQL: "select 1", Format: sqlutil.FormatOptionTimeSeries}}
+	key, err := queryCacheKey(base)
+	require.NoError(t, err)
+
+	for name, change := range map[string]func(*sqlQuery){
+		"stream":       func(q *sqlQuery) { q.Stream = true },
+		"labels":       func(q *sqlQuery) { q.Labels = []string{"host"} },
+		"logs":         func(q *sqlQuery) { q.Logs = logColumns{Body: "line"} },
+		"location":     func(q *sqlQuery) { q.Location = time.FixedZone("X", 3600) },
+		"fillMissing":  func(q *sqlQuery) { q.FillMissing = &data.FillMissing{Mode: data.FillModeNull} },
+		"fillInterval": func(q *sqlQuery) { q.FillInterval = time.Minute },
+		"maxFrameRows": func(q *sqlQuery) { q.MaxFrameRows = 10 },
+	} {
+		q := base
+		change(&q)
+		other, err := queryCacheKey(q)
+		require.NoError(t, err)
+		require.NotEqual(t, key, other, name)
+	}
+}
+
+func TestIntegration_QueryData_CachedStream(t *testing.T) {
+	ds := newIntegrationDatasource(t)
+	ds.queryCache = newTTLCache[backend.DataResponse](time.Minute, queryCacheSize)
+
+	req := &backend.QueryDataRequest{
+		PluginContext: backend.PluginContext{
+			DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{UID: "abc"},
+		},
+		Queries: []backend.DataQuery{{
+			RefID: "A",
+			JSON:  []byte(`{"refId": "A", "format": "table", "stream": true, "queryText": "select * from intTable"}`),
+		}},
+	}
+	for i := 0; i < 2; i++ {
+		resp, err := ds.QueryData(context.Background(), req)
+		require.NoError(t, err)
+		require.NoError(t, resp.Responses["A"].Error)
+		require.NotEmpty(t, resp.Responses["A"].Frames[0].Meta.Channel)
+	}
+
+	// The cached frames aren't changed by the responses served from them.
+	ds.queryCache.mu.Lock()
+	defer ds.queryCache.mu.Unlock()
+	require.Len(t, ds.queryCache.entries, 1)
+	for _, e := range ds.queryCache.entries {
+		for _, frame := range e.value.Frames {
+			if frame.Meta != nil {
+				require.Empty(t, frame.Meta.Channel)
+			}
+		}
+	}
+}
+
+func TestCheckQueryMetadata(t *testing.T) {
+	ds := &FlightSQLDatasource{
+		md:  metadata.Pairs("x-tenant", "a", "x-api-key", "secret"),
+		cfg: config{AuthHeader: "X-Token"},
+	}
+	require.NoError(t, ds.checkQueryMetadata(metadata.Pairs("x-priority", "low")))
+
+	for _, key := range []string{"x-tenant", "x-api-key", "x-token", "bucket-name", "catalog", "schema"} {
+		require.EqualError(t, ds.checkQueryMetadata(metadata.Pairs(key, "other")), "reserved metadata key: "+key)
+	}
+	require.NoError(t, ds.checkQueryMetadata(metadata.Pairs("authorization", "Bearer other")))
+	ds.cfg.OAuthPassThru = true
+	require.Error(t, ds.checkQueryMetadata(metadata.Pairs("authorization", "Bearer other")))
+}
@@ -1,43 +1,89 @@
 package flightsql
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"regexp"
 	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/apache/arrow/go/v12/arrow"
 	"github.com/apache/arrow/go/v12/arrow/array"
 	"github.com/apache/arrow/go/v12/arrow/flight"
 	"github.com/apache/arrow/go/v12/arrow/flight/flightsql"
 	"github.com/apache/arrow/go/v12/arrow/memory"
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
 	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
 	"google.golang.org/grpc/metadata"
 )
 
+// macroDetail describes a macro along with an example of its expansion.
+type macroDetail struct {
+	Name        string   `json:"name"`
+	Args        []string `json:"args"`
+	Signature   string   `json:"signature"`
+	Description string   `json:"description"`
+	Example     string   `json:"example"`
+	Expansion   string   `json:"expansion"`
+}
+
 func (d *FlightSQLDatasource) getMacros(w http.ResponseWriter, r *http.Request) {
-	size := len(sqlutil.DefaultMacros) + len(macros)
-	names := make([]string, 0, size)
+	seen := make(map[string]bool, len(sqlutil.DefaultMacros)+len(macros))
 	for k := range sqlutil.DefaultMacros {
-		if k == "table" || k == "column" {
-			// We don't have the information available for these to function
-			// propperly so omit them from advertisement.
-			continue
-		}
-		names = append(names, k)
+		seen[k] = true
 	}
+	// sqlutil.Interpolate adds the default macros to ours, so they must be
+	// excluded after both have been collected.
 	for k := range macros {
+		seen[k] = true
+	}
+	// We don't have the information available for these to function
+	// propperly so omit them from advertisement.
+	delete(seen, "table")
+	delete(seen, "column")
+	names := make([]string, 0, len(seen))
+	for k := range seen {
 		names = append(names, k)
 	}
 	sort.Strings(names)
 
+	details := make([]macroDetail, 0, len(names))
+	for _, name := range names {
+		doc := macroDocs[name]
+		detail := macroDetail{
+			Name:        name,
+			Args:        doc.Args,
+			Signature:   doc.signature(name),
+			Description: doc.Description,
+			Example:     doc.example(name),
+		}
+		if detail.Args == nil {
+			detail.Args = []string{}
+		}
+		expansion, err := sqlutil.Interpolate(macroExampleQuery.WithSQL(detail.Example), macros)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		detail.Expansion = expansion
+		details = append(details, detail)
+	}
+
 	err := json.NewEncoder(w).Encode(struct {
-		Macros []string `json:"macros"`
+		Macros  []string      `json:"macros"`
+		Details []macroDetail `json:"details"`
 	}{
-		Macros: names,
+		Macros:  names,
+		Details: details,
 	})
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -48,29 +94,19 @@ func (d *FlightSQLDatasource) getMacros(w http.ResponseWriter, r *http.Request)
 func (d *FlightSQLDatasource) getSQLInfo(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
 	defer cancel()
-	ctx = metadata.NewOutgoingContext(ctx, d.md)
+	ctx = metadata.NewOutgoingContext(ctx, d.resourceMetadata(r))
 	info, err := d.client.GetSqlInfo(ctx, []flightsql.SqlInfo{})
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	reader, err := d.client.DoGet(ctx, info.Endpoint[0].Ticket)
-	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-	defer reader.Release()
-
-	if err := writeDataResponse(w, newDataResponse(reader)); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
+	d.writeFlightInfo(ctx, w, info)
 }
 
 func (d *FlightSQLDatasource) getTables(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
 	defer cancel()
-	ctx = metadata.NewOutgoingContext(ctx, d.md)
+	ctx = metadata.NewOutgoingContext(ctx, d.resourceMetadata(r))
 
 	info, err := d.client.GetTables(ctx, &flightsql.GetTablesOpts{
 		TableTypes: []string{"BASE TABLE", "table"},
@@ -79,17 +115,38 @@ func (d *FlightSQLDatasource) getTables(w http.ResponseWriter, r *http.Request)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	reader, err := d.client.DoGet(ctx, info.Endpoint[0].Ticket)
+	d.writeFlightInfo(ctx, w, info)
+}
+
+func (d *FlightSQLDatasource) getCatalogs(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	ctx = metadata.NewOutgoingContext(ctx, d.resourceMetadata(r))
+
+	info, err := d.client.GetCatalogs(ctx)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
-	defer reader.Release()
+	d.writeFlightInfo(ctx, w, info)
+}
 
-	if err := writeDataResponse(w, newDataResponse(reader)); err != nil {
+func (d *FlightSQLDatasource) getSchemas(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	ctx = metadata.NewOutgoingContext(ctx, d.resourceMetadata(r))
+
+	var opts flightsql.GetDBSchemasOpts
+	if r.URL.Query().Has("catalog") {
+		catalog := r.URL.Query().Get("catalog")
+		opts.Catalog = &catalog
+	}
+	info, err := d.client.GetDBSchemas(ctx, &opts)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	d.writeFlightInfo(ctx, w, info)
 }
 
 func (d *FlightSQLDatasource) getColumns(w http.ResponseWriter, r *http.Request) {
@@ -101,67 +158,621 @@ func (d *FlightSQLDatasource) getColumns(w http.ResponseWriter, r *http.Request)
 
 	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
 	defer cancel()
-	ctx = metadata.NewOutgoingContext(ctx, d.md)
+	ctx = metadata.NewOutgoingContext(ctx, d.resourceMetadata(r))
+	schema, err := d.tableSchema(ctx, tableName)
+	if errors.Is(err, errTableNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var resp backend.DataResponse
+	resp.Frames = append(resp.Frames, newFrame(schema, convertOptions{}))
+	if err := writeDataResponse(w, resp); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// errTableNotFound is returned by [FlightSQLDatasource.tableSchema] when the
+// server has no table with the name.
+var errTableNotFound = errors.New("table not found")
+
+// tableSchema returns the schema of the table with the given name.
+func (d *FlightSQLDatasource) tableSchema(ctx context.Context, tableName string) (*arrow.Schema, error) {
 	info, err := d.client.GetTables(ctx, &flightsql.GetTablesOpts{
 		TableNameFilterPattern: &tableName,
 		IncludeSchema:          true,
 	})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return nil, err
 	}
 	reader, err := d.client.DoGet(ctx, info.Endpoint[0].Ticket)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return nil, err
 	}
 	defer reader.Release()
 
 	if !reader.Next() {
-		http.Error(w, "table not found", http.StatusNotFound)
-		return
+		return nil, errTableNotFound
 	}
 	rec := reader.Record()
 	rec.Retain()
 	defer rec.Release()
 	reader.Next()
 	if err := reader.Err(); err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
+		return nil, err
 	}
 
 	indices := rec.Schema().FieldIndices("table_schema")
 	if len(indices) == 0 {
-		http.Error(w, "table_schema field not found", http.StatusInternalServerError)
-		return
+		return nil, errors.New("table_schema field not found")
 	}
 	col := rec.Column(indices[0])
-	serializedSchema := array.NewStringData(col.Data()).Value(0)
-	schema, err := flight.DeserializeSchema([]byte(serializedSchema), memory.DefaultAllocator)
+	schemas := array.NewStringData(col.Data())
+	defer schemas.Release()
+	serializedSchema := schemas.Value(0)
+	return flight.DeserializeSchema([]byte(serializedSchema), memory.DefaultAllocator)
+}
+
+// tagValue is a tag key or value in the form Grafana's ad hoc filters
+// expect.
+type tagValue struct {
+	Text string `json:"text"`
+}
+
+// adhocTable returns the table ad hoc filters are offered for, from the
+// "table" query parameter or else the datasource's configured table. If
+// there is neither an error is written and false is returned.
+func (d *FlightSQLDatasource) adhocTable(w http.ResponseWriter, r *http.Request) (string, bool) {
+	table := r.URL.Query().Get("table")
+	if table == "" {
+		table = d.cfg.AdhocFilterTable
+	}
+	if table == "" {
+		http.Error(w, `query parameter "table" is required when no ad hoc filter table is configured`, http.StatusBadRequest)
+		return "", false
+	}
+	return table, true
+}
+
+// getTagKeys returns the columns of the ad hoc filter table as the keys ad
+// hoc filters can be applied to.
+func (d *FlightSQLDatasource) getTagKeys(w http.ResponseWriter, r *http.Request) {
+	table, ok := d.adhocTable(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	ctx = metadata.NewOutgoingContext(ctx, d.resourceMetadata(r))
+	schema, err := d.tableSchema(ctx, table)
+	if errors.Is(err, errTableNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	var resp backend.DataResponse
-	resp.Frames = append(resp.Frames, newFrame(schema))
-	if err := writeDataResponse(w, resp); err != nil {
+	keys := make([]tagValue, len(schema.Fields()))
+	for i, f := range schema.Fields() {
+		keys[i] = tagValue{Text: f.Name}
+	}
+	writeJSON(w, keys)
+}
+
+const (
+	defaultTagValuesLimit = 1000
+	maxTagValuesLimit     = 10000
+)
+
+// getTagValues returns the distinct values of a column of the ad hoc filter
+// table, up to a limit.
+func (d *FlightSQLDatasource) getTagValues(w http.ResponseWriter, r *http.Request) {
+	table, ok := d.adhocTable(w, r)
+	if !ok {
+		return
+	}
+	params := r.URL.Query()
+	key := params.Get("key")
+	if key == "" {
+		http.Error(w, `query parameter "key" is required`, http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultTagValuesLimit
+	if v := params.Get("limit"); v != "" {
+		var err error
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > maxTagValuesLimit {
+			http.Error(w, fmt.Sprintf(`query parameter "limit" must be between 1 and %d`, maxTagValuesLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	ctx = metadata.NewOutgoingContext(ctx, d.resourceMetadata(r))
+
+	col := quoteIdentifier(key)
+	info, err := d.client.Execute(ctx, fmt.Sprintf("SELECT DISTINCT %s FROM %s WHERE %s IS NOT NULL LIMIT %d",
+		col, quoteTableName(table), col, limit))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	column, err := d.readFirstColumn(ctx, info)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	values := make([]tagValue, len(column))
+	for i, v := range column {
+		values[i] = tagValue{Text: v}
+	}
+	writeJSON(w, values)
+}
+
+// getDatabases returns the names of the databases on the server, from the
+// configured databases query or else the server's catalogs, for the
+// database to be picked from a list.
+func (d *FlightSQLDatasource) getDatabases(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	ctx = metadata.NewOutgoingContext(ctx, d.resourceMetadata(r))
+
+	var (
+		info *flight.FlightInfo
+		err  error
+	)
+	if d.cfg.DatabasesQuery != "" {
+		info, err = d.client.Execute(ctx, d.cfg.DatabasesQuery)
+	} else {
+		info, err = d.client.GetCatalogs(ctx)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	databases, err := d.readFirstColumn(ctx, info)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	writeJSON(w, databases)
+}
+
+// readFirstColumn reads the results of the first endpoint of info and returns
+// the non-null values of their first column as strings.
+func (d *FlightSQLDatasource) readFirstColumn(ctx context.Context, info *flight.FlightInfo) ([]string, error) {
+	values := []string{}
+	if len(info.Endpoint) == 0 {
+		return values, nil
+	}
+	reader, err := d.client.DoGet(ctx, info.Endpoint[0].Ticket)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Release()
+
+	resp := newDataResponse(reader)
+	if resp.Error != nil {
+		return nil, resp.Error
+	}
+	if len(resp.Frames[0].Fields) == 0 {
+		return values, nil
+	}
+	field := resp.Frames[0].Fields[0]
+	for i := 0; i < field.Len(); i++ {
+		if v, ok := field.ConcreteAt(i); ok {
+			values = append(values, labelValue(v))
+		}
+	}
+	return values, nil
+}
+
+// metadataCacheTTL is how long responses to metadata resource requests are
+// cached for.
+const metadataCacheTTL = time.Minute
+
+// metadataCacheSize is the maximum number of cached metadata responses.
+const metadataCacheSize = 1000
+
+// cacheMetadata is middleware that serves successful responses from the
+// metadata cache. Requests with "refresh=true" bypass the cache.
+func (d *FlightSQLDatasource) cacheMetadata(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if d.metadataCache == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		params := r.URL.Query()
+		refresh := params.Get("refresh") == "true"
+		params.Del("refresh")
+		md, err := json.Marshal(d.resourceMetadata(r))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		key := r.URL.Path + "?" + params.Encode() + "#" + string(md)
+
+		if body, ok := d.metadataCache.Get(key); ok && !refresh {
+			_, _ = w.Write(body)
+			return
+		}
+
+		rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(rec, r)
+		if rec.status == http.StatusOK {
+			d.metadataCache.Set(key, rec.body.Bytes())
+		}
+	})
+}
+
+// responseRecorder captures the status and body written to a
+// [http.ResponseWriter].
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
 }
 
+// resourceMetadata returns the outgoing metadata for a resource request.
+func (d *FlightSQLDatasource) resourceMetadata(r *http.Request) metadata.MD {
+	pCtx := httpadapter.PluginConfigFromContext(r.Context())
+	return d.queryMetadata(sqlQuery{Metadata: d.forwardedMetadata(pCtx, r.Header.Get)})
+}
+
+func (d *FlightSQLDatasource) getTableTypes(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	ctx = metadata.NewOutgoingContext(ctx, d.resourceMetadata(r))
+
+	info, err := d.client.GetTableTypes(ctx)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	d.writeFlightInfo(ctx, w, info)
+}
+
+// getTypeInfo returns the data types the server supports and their
+// characteristics, optionally only those of the XDBC data type given by the
+// "dataType" query parameter.
+func (d *FlightSQLDatasource) getTypeInfo(w http.ResponseWriter, r *http.Request) {
+	var dataType *int32
+	if v := r.URL.Query().Get("dataType"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 32)
+		if err != nil {
+			http.Error(w, `query parameter "dataType" must be an integer`, http.StatusBadRequest)
+			return
+		}
+		t := int32(n)
+		dataType = &t
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	ctx = metadata.NewOutgoingContext(ctx, d.resourceMetadata(r))
+
+	info, err := d.client.GetXdbcTypeInfo(ctx, dataType)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	d.writeFlightInfo(ctx, w, info)
+}
+
+func (d *FlightSQLDatasource) getPrimaryKeys(w http.ResponseWriter, r *http.Request) {
+	ref, ok := tableRef(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	ctx = metadata.NewOutgoingContext(ctx, d.resourceMetadata(r))
+
+	info, err := d.client.GetPrimaryKeys(ctx, ref)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	d.writeFlightInfo(ctx, w, info)
+}
+
+// getForeignKeys returns the foreign keys of a table, i.e. the primary keys
+// of other tables that it references.
+func (d *FlightSQLDatasource) getForeignKeys(w http.ResponseWriter, r *http.Request) {
+	ref, ok := tableRef(w, r)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	ctx = metadata.NewOutgoingContext(ctx, d.resourceMetadata(r))
+
+	info, err := d.client.GetImportedKeys(ctx, ref)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	d.writeFlightInfo(ctx, w, info)
+}
+
+const (
+	defaultPreviewLimit = 10
+	maxPreviewLimit     = 1000
+)
+
+// getPreview returns a sample of the rows in a table.
+func (d *FlightSQLDatasource) getPreview(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	table := params.Get("table")
+	if table == "" {
+		http.Error(w, `query parameter "table" is required`, http.StatusBadRequest)
+		return
+	}
+	if schema := params.Get("schema"); schema != "" {
+		table = quoteIdentifier(schema) + "." + quoteIdentifier(table)
+	} else {
+		table = quoteTableName(table)
+	}
+
+	limit := defaultPreviewLimit
+	if v := params.Get("limit"); v != "" {
+		var err error
+		limit, err = strconv.Atoi(v)
+		if err != nil || limit <= 0 || limit > maxPreviewLimit {
+			http.Error(w, fmt.Sprintf(`query parameter "limit" must be between 1 and %d`, maxPreviewLimit), http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	ctx = metadata.NewOutgoingContext(ctx, d.resourceMetadata(r))
+
+	info, err := d.client.Execute(ctx, fmt.Sprintf("SELECT * FROM %s LIMIT %d", table, limit))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	d.writeFlightInfo(ctx, w, info)
+}
+
+// sqlDialect describes the SQL dialect of the server.
+type sqlDialect struct {
+	Keywords             []string            `json:"keywords"`
+	IdentifierQuoteChar  string              `json:"identifierQuoteChar"`
+	IdentifierCase       string              `json:"identifierCase"`
+	QuotedIdentifierCase string              `json:"quotedIdentifierCase"`
+	Functions            map[string][]string `json:"functions"`
+}
+
+// caseSensitivity names the values of [flightsql.SqlSupportedCaseSensitivity].
+var caseSensitivity = map[int64]string{
+	int64(flightsql.SqlCaseSensitivityUnknown):         "unknown",
+	int64(flightsql.SqlCaseSensitivityCaseInsensitive): "case_insensitive",
+	int64(flightsql.SqlCaseSensitivityUpperCase):       "uppercase",
+	int64(flightsql.SqlCaseSensitivityLowerCase):       "lowercase",
+}
+
+// sqlDialectFunctions maps the categories of functions reported in the SQL
+// dialect to the SqlInfo they are retrieved from.
+var sqlDialectFunctions = map[string]flightsql.SqlInfo{
+	"numeric":  flightsql.SqlInfoNumericFunctions,
+	"string":   flightsql.SqlInfoStringFunctions,
+	"datetime": flightsql.SqlInfoDateTimeFunctions,
+	"system":   flightsql.SqlInfoSystemFunctions,
+}
+
+func (d *FlightSQLDatasource) getSQLDialect(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	ctx = metadata.NewOutgoingContext(ctx, d.resourceMetadata(r))
+
+	// Some servers reject requests for info they don't provide, so request
+	// everything and pick out what's needed.
+	values, err := d.sqlInfo(ctx, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	dialect := sqlDialect{
+		Keywords:             []string{},
+		IdentifierCase:       caseSensitivity[0],
+		QuotedIdentifierCase: caseSensitivity[0],
+		Functions:            make(map[string][]string, len(sqlDialectFunctions)),
+	}
+	if v, ok := values[flightsql.SqlInfoKeywords].([]string); ok {
+		dialect.Keywords = v
+	}
+	if v, ok := values[flightsql.SqlInfoIdentifierQuoteChar].(string); ok {
+		dialect.IdentifierQuoteChar = v
+	}
+	if v, ok := values[flightsql.SqlInfoIdentifierCase].(int64); ok {
+		dialect.IdentifierCase = caseSensitivity[v]
+	}
+	if v, ok := values[flightsql.SqlInfoQuotedIdentifierCase].(int64); ok {
+		dialect.QuotedIdentifierCase = caseSensitivity[v]
+	}
+	for name, info := range sqlDialectFunctions {
+		funcs, _ := values[info].([]string)
+		if funcs == nil {
+			funcs = []string{}
+		}
+		dialect.Functions[name] = funcs
+	}
+	writeJSON(w, dialect)
+}
+
+// validateSQLRequest is the body of a request to validate a SQL statement.
+type validateSQLRequest struct {
+	Text string `json:"queryText"`
+}
+
+// validateSQLResponse reports whether a SQL statement is valid along with its
+// result schema, or the error and its position when it isn't.
+type validateSQLResponse struct {
+	Valid  bool          `json:"valid"`
+	Schema []schemaField `json:"schema,omitempty"`
+	Error  string        `json:"error,omitempty"`
+	Line   int           `json:"line,omitempty"`
+	Column int           `json:"column,omitempty"`
+}
+
+// errorPositionPattern matches the position commonly reported by SQL parser
+// errors, e.g. "at Line: 1, Column 8".
+var errorPositionPattern = regexp.MustCompile(`(?i)line:?\s*(\d+),\s*column:?\s*(\d+)`)
+
+// validateSQL prepares, but doesn't execute, a SQL statement and reports
+// its result schema or the error preparing it. Macros are expanded using the
+// last hour as the time range.
+func (d *FlightSQLDatasource) validateSQL(w http.ResponseWriter, r *http.Request) {
+	var req validateSQLRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.Text == "" {
+		http.Error(w, `"queryText" is required`, http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now().UTC()
+	query := &sqlutil.Query{
+		RawSQL:    req.Text,
+		TimeRange: backend.TimeRange{From: now.Add(-time.Hour), To: now},
+		Interval:  time.Minute,
+	}
+	var resp validateSQLResponse
+	sql, err := sqlutil.Interpolate(query, macros)
+	if err != nil {
+		resp.Error = fmt.Sprintf("macro interpolation: %s", err)
+		writeJSON(w, resp)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+	ctx = metadata.NewOutgoingContext(ctx, d.resourceMetadata(r))
+
+	stmt, err := d.client.Prepare(ctx, sql)
+	if err != nil {
+		resp.Error = err.Error()
+		if m := errorPositionPattern.FindStringSubmatch(resp.Error); m != nil {
+			resp.Line, _ = strconv.Atoi(m[1])
+			resp.Column, _ = strconv.Atoi(m[2])
+		}
+		writeJSON(w, resp)
+		return
+	}
+	defer func() {
+		if err := stmt.Close(ctx); err != nil {
+			log.DefaultLogger.Error("Failed to close prepared statement", "error", err)
+		}
+	}()
+
+	resp.Valid = true
+	if schema := stmt.DatasetSchema(); schema != nil {
+		resp.Schema = schemaFields(schema)
+	}
+	writeJSON(w, resp)
+}
+
+// writeJSON writes v as JSON.
+func writeJSON(w http.ResponseWriter, v any) {
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// quoteIdentifier quotes a SQL identifier, escaping any embedded quotes.
+func quoteIdentifier(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// quoteTableName quotes a table name that may be qualified by its schema and
+// catalog, such as "schema.table", quoting each of its parts.
+func quoteTableName(s string) string {
+	parts := strings.Split(s, ".")
+	for i, p := range parts {
+		parts[i] = quoteIdentifier(p)
+	}
+	return strings.Join(parts, ".")
+}
+
+// tableRef builds a [flightsql.TableRef] from the "table", "schema" and
+// "catalog" query parameters. If the table is missing an error is written
+// and false is returned.
+func tableRef(w http.ResponseWriter, r *http.Request) (flightsql.TableRef, bool) {
+	params := r.URL.Query()
+	ref := flightsql.TableRef{Table: params.Get("table")}
+	if ref.Table == "" {
+		http.Error(w, `query parameter "table" is required`, http.StatusBadRequest)
+		return ref, false
+	}
+	if params.Has("catalog") {
+		catalog := params.Get("catalog")
+		ref.Catalog = &catalog
+	}
+	if params.Has("schema") {
+		schema := params.Get("schema")
+		ref.DBSchema = &schema
+	}
+	return ref, true
+}
+
+// writeFlightInfo reads the results of the first endpoint of info and writes
+// them as a [backend.DataResponse].
+func (d *FlightSQLDatasource) writeFlightInfo(ctx context.Context, w http.ResponseWriter, info *flight.FlightInfo) {
+	if len(info.Endpoint) == 0 {
+		http.Error(w, "no endpoints in response", http.StatusInternalServerError)
+		return
+	}
+	reader, err := d.client.DoGet(ctx, info.Endpoint[0].Ticket)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer reader.Release()
+
+	if err := writeDataResponse(w, newDataResponse(reader)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// newDataResponse reads the records of reader into a single frame, whose
+// fields are made nullable when their columns have nulls.
 func newDataResponse(reader recordReader) backend.DataResponse {
 	var resp backend.DataResponse
-	frame := newFrame(reader.Schema())
-READER:
+	frame := newFrame(reader.Schema(), convertOptions{})
 	for reader.Next() {
 		record := reader.Record()
-		for i, col := range record.Columns() {
-			if err := copyData(frame.Fields[i], col); err != nil {
-				resp.Error = err
-				break READER
-			}
+		if err := copyRecord(frame, record, 0, record.NumRows(), convertOptions{}); err != nil {
+			resp.Error = err
+			break
 		}
 		if err := reader.Err(); err != nil && !errors.Is(err, io.EOF) {
 			resp.Error = err
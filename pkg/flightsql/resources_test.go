@@ -0,0 +1,387 @@
+package flightsql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/apache/arrow/go/v12/arrow"
+	"github.com/apache/arrow/go/v12/arrow/array"
+	"github.com/apache/arrow/go/v12/arrow/flight"
+	"github.com/apache/arrow/go/v12/arrow/memory"
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestCacheMetadata(t *testing.T) {
+	ds := &FlightSQLDatasource{
+		md:            metadata.MD{},
+		metadataCache: newTTLCache[[]byte](metadataCacheTTL, metadataCacheSize),
+	}
+
+	var calls int
+	handler := ds.cacheMetadata(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		fmt.Fprintf(w, "%d", calls)
+	}))
+	get := func(url string) string {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+		return rec.Body.String()
+	}
+
+	require.Equal(t, "1", get("/flightsql/columns?table=cpu"))
+	require.Equal(t, "1", get("/flightsql/columns?table=cpu"))
+	require.Equal(t, "2", get("/flightsql/columns?table=mem"))
+	require.Equal(t, "3", get("/flightsql/columns?table=cpu&refresh=true"))
+	require.Equal(t, "3", get("/flightsql/columns?table=cpu"))
+}
+
+func TestIntegration_GetMetadata(t *testing.T) {
+	ds := newIntegrationDatasource(t)
+
+	for _, path := range []string{
+		"flightsql/catalogs",
+		"flightsql/schemas",
+		"flightsql/schemas?catalog=main",
+		"flightsql/table-types",
+		"flightsql/primary-keys?table=intTable",
+		"flightsql/foreign-keys?table=intTable",
+		"flightsql/type-info",
+		"flightsql/type-info?dataType=4",
+	} {
+		status, body := callResource(t, ds, path)
+		require.Equal(t, http.StatusOK, status, path)
+		frames := decodeFrames(t, body)
+		require.Len(t, frames, 1, path)
+	}
+}
+
+func TestIntegration_GetPrimaryKeys_MissingTable(t *testing.T) {
+	ds := newIntegrationDatasource(t)
+
+	status, _ := callResource(t, ds, "flightsql/primary-keys")
+	require.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestIntegration_GetDatabases(t *testing.T) {
+	ds := newIntegrationDatasource(t)
+
+	status, body := callResource(t, ds, "flightsql/databases")
+	require.Equal(t, http.StatusOK, status, string(body))
+	var databases []string
+	require.NoError(t, json.Unmarshal(body, &databases))
+	require.Equal(t, []string{"main"}, databases)
+
+	ds.cfg.DatabasesQuery = "select 'a' union all select 'b'"
+	status, body = callResource(t, ds, "flightsql/databases?refresh=true")
+	require.Equal(t, http.StatusOK, status, string(body))
+	require.NoError(t, json.Unmarshal(body, &databases))
+	require.Equal(t, []string{"a", "b"}, databases)
+
+	// Nulls aren't read as empty names.
+	ds.cfg.DatabasesQuery = "select 'a' union all select null"
+	status, body = callResource(t, ds, "flightsql/databases?refresh=true")
+	require.Equal(t, http.StatusOK, status, string(body))
+	require.NoError(t, json.Unmarshal(body, &databases))
+	require.Equal(t, []string{"a"}, databases)
+}
+
+func TestNewDataResponse_Nulls(t *testing.T) {
+	// The column isn't marked nullable, but has a null.
+	schema := arrow.NewSchema([]arrow.Field{{Name: "s", Type: arrow.BinaryTypes.String}}, nil)
+	strs, _, err := array.FromJSON(memory.DefaultAllocator, arrow.BinaryTypes.String, strings.NewReader(`["a", null]`))
+	require.NoError(t, err)
+	record := array.NewRecord(schema, []arrow.Array{strs}, -1)
+	reader, err := array.NewRecordReader(schema, []arrow.Record{record})
+	require.NoError(t, err)
+
+	resp := newDataResponse(reader)
+	require.NoError(t, resp.Error)
+	require.Equal(t, []*string{ptr("a"), nil}, extractFieldValues[*string](t, resp.Frames[0].Fields[0]))
+}
+
+func TestIntegration_GetTypeInfo_InvalidDataType(t *testing.T) {
+	ds := newIntegrationDatasource(t)
+
+	status, _ := callResource(t, ds, "flightsql/type-info?dataType=int")
+	require.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestIntegration_GetPreview(t *testing.T) {
+	ds := newIntegrationDatasource(t)
+
+	status, body := callResource(t, ds, "flightsql/preview?table=intTable&limit=2")
+	require.Equal(t, http.StatusOK, status, string(body))
+	frames := decodeFrames(t, body)
+	require.Len(t, frames, 1)
+	require.Equal(t, 2, frames[0].Rows())
+
+	// Tables qualified by their schema are quoted part by part.
+	status, body = callResource(t, ds, "flightsql/preview?table=main.intTable")
+	require.Equal(t, http.StatusOK, status, string(body))
+
+	status, _ = callResource(t, ds, "flightsql/preview?table=intTable&limit=0")
+	require.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestIntegration_GetTags(t *testing.T) {
+	ds := newIntegrationDatasource(t)
+
+	status, body := callResource(t, ds, "flightsql/tag-keys?table=intTable")
+	require.Equal(t, http.StatusOK, status, string(body))
+	var keys []tagValue
+	require.NoError(t, json.Unmarshal(body, &keys))
+	require.Contains(t, keys, tagValue{Text: "keyName"})
+
+	status, body = callResource(t, ds, "flightsql/tag-values?table=intTable&key=keyName&limit=2")
+	require.Equal(t, http.StatusOK, status, string(body))
+	var values []tagValue
+	require.NoError(t, json.Unmarshal(body, &values))
+	require.Len(t, values, 2)
+
+	// Tables qualified by their schema are quoted part by part.
+	status, body = callResource(t, ds, "flightsql/tag-values?table=main.intTable&key=keyName")
+	require.Equal(t, http.StatusOK, status, string(body))
+
+	status, _ = callResource(t, ds, "flightsql/tag-values?key=keyName")
+	require.Equal(t, http.StatusBadRequest, status)
+	status, _ = callResource(t, ds, "flightsql/tag-values?table=intTable")
+	require.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestQuoteTableName(t *testing.T) {
+	require.Equal(t, `"cpu"`, quoteTableName("cpu"))
+	require.Equal(t, `"public"."cpu"`, quoteTableName("public.cpu"))
+	require.Equal(t, `"iox"."public"."cpu ""a"""`, quoteTableName(`iox.public.cpu "a"`))
+}
+
+func TestGetMacros(t *testing.T) {
+	ds := newIntegrationDatasource(t)
+
+	status, body := callResource(t, ds, "plugin/macros")
+	require.Equal(t, http.StatusOK, status, string(body))
+
+	var resp struct {
+		Macros  []string      `json:"macros"`
+		Details []macroDetail `json:"details"`
+	}
+	require.NoError(t, json.Unmarshal(body, &resp))
+	require.Len(t, resp.Details, len(resp.Macros))
+	require.NotContains(t, resp.Macros, "table")
+	for i, detail := range resp.Details {
+		require.Equal(t, resp.Macros[i], detail.Name)
+		require.NotEmpty(t, detail.Description, detail.Name)
+		require.NotContains(t, detail.Expansion, "$__", detail.Name)
+	}
+
+	var timeGroup macroDetail
+	for _, detail := range resp.Details {
+		if detail.Name == "timeGroup" {
+			timeGroup = detail
+		}
+	}
+	require.Equal(t, "$__timeGroup(column, unit, [fill])", timeGroup.Signature)
+	require.Equal(t, "$__timeGroup(time, hour)", timeGroup.Example)
+	require.Equal(t, "datepart('hour', time),datepart('day', time),datepart('month', time),datepart('year', time)", timeGroup.Expansion)
+}
+
+func TestIntegration_GetSQLDialect(t *testing.T) {
+	ds := newIntegrationDatasource(t)
+
+	status, body := callResource(t, ds, "flightsql/sql-dialect")
+	require.Equal(t, http.StatusOK, status, string(body))
+
+	var dialect sqlDialect
+	require.NoError(t, json.Unmarshal(body, &dialect))
+	require.Contains(t, dialect.Keywords, "SELECT")
+	require.Equal(t, `"`, dialect.IdentifierQuoteChar)
+	require.Equal(t, "case_insensitive", dialect.IdentifierCase)
+	require.Equal(t, "case_insensitive", dialect.QuotedIdentifierCase)
+	require.Contains(t, dialect.Functions, "numeric")
+}
+
+func TestIntegration_ValidateSQL(t *testing.T) {
+	ds := newIntegrationDatasource(t)
+
+	status, body := sendResource(t, ds, http.MethodPost, "flightsql/validate-sql",
+		[]byte(`{"queryText": "select id, name from intTable where $__timeFilter(ts) or 1 = 1"}`))
+	require.Equal(t, http.StatusOK, status, string(body))
+	var resp validateSQLResponse
+	require.NoError(t, json.Unmarshal(body, &resp))
+	require.True(t, resp.Valid, resp.Error)
+
+	status, _ = sendResource(t, ds, http.MethodPost, "flightsql/validate-sql", []byte(`{}`))
+	require.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestIntegration_ValidateConfig(t *testing.T) {
+	ds := newIntegrationDatasource(t)
+
+	validate := func(jsonData string) []configCheck {
+		t.Helper()
+		status, body := sendResource(t, ds, http.MethodPost, "flightsql/validate-config",
+			[]byte(fmt.Sprintf(`{"jsonData": %s}`, jsonData)))
+		require.Equal(t, http.StatusOK, status, string(body))
+		var resp struct {
+			OK     bool          `json:"ok"`
+			Checks []configCheck `json:"checks"`
+		}
+		require.NoError(t, json.Unmarshal(body, &resp))
+		return resp.Checks
+	}
+
+	checks := validate(fmt.Sprintf(`{"host": %q}`, ds.cfg.Addr))
+	require.Equal(t, []configCheck{
+		{Step: "settings", OK: true},
+		{Step: "connect", OK: true, Message: "connected"},
+		{Step: "authenticate", OK: true, Message: "token"},
+	}, checks)
+
+	checks = validate(`{"host": "localhost"}`)
+	require.Len(t, checks, 1)
+	require.Equal(t, "settings", checks[0].Step)
+	require.False(t, checks[0].OK)
+
+	checks = validate(`{"host": "localhost:1"}`)
+	require.Len(t, checks, 2)
+	require.Equal(t, "connect", checks[1].Step)
+	require.False(t, checks[1].OK)
+	require.NotEmpty(t, checks[1].Message)
+
+	status, _ := sendResource(t, ds, http.MethodPost, "flightsql/validate-config", []byte(`not json`))
+	require.Equal(t, http.StatusBadRequest, status)
+}
+
+// credentialServer records the authorization metadata of calls to
+// GetFlightInfo.
+type credentialServer struct {
+	flight.FlightServer
+	mu          sync.Mutex
+	credentials []string
+}
+
+func (s *credentialServer) GetFlightInfo(ctx context.Context, desc *flight.FlightDescriptor) (*flight.FlightInfo, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	s.mu.Lock()
+	s.credentials = append(s.credentials, md.Get("authorization")...)
+	s.mu.Unlock()
+	return s.FlightServer.GetFlightInfo(ctx, desc)
+}
+
+func TestIntegration_ValidateConfig_ChangedHost(t *testing.T) {
+	server := &credentialServer{}
+	ds := newWrappedIntegrationDatasource(t, func(s flight.FlightServer) flight.FlightServer {
+		server.FlightServer = s
+		return server
+	})
+	// The same server under another name stands in for one chosen by the
+	// caller.
+	_, port, err := net.SplitHostPort(ds.cfg.Addr)
+	require.NoError(t, err)
+	addr := net.JoinHostPort("localhost", port)
+	// Forget the datasource's own calls.
+	server.mu.Lock()
+	server.credentials = nil
+	server.mu.Unlock()
+
+	status, body := sendResource(t, ds, http.MethodPost, "flightsql/validate-config",
+		[]byte(fmt.Sprintf(`{"jsonData": {"host": %q}}`, addr)))
+	require.Equal(t, http.StatusOK, status, string(body))
+	server.mu.Lock()
+	require.Empty(t, server.credentials, string(body))
+	server.mu.Unlock()
+
+	status, body = sendResource(t, ds, http.MethodPost, "flightsql/validate-config",
+		[]byte(fmt.Sprintf(`{"jsonData": {"host": %q}, "secureJsonData": {"token": "posted"}}`, addr)))
+	require.Equal(t, http.StatusOK, status, string(body))
+	server.mu.Lock()
+	require.Equal(t, []string{"Bearer posted"}, server.credentials)
+	server.mu.Unlock()
+}
+
+func TestCandidateSecrets(t *testing.T) {
+	ds := &FlightSQLDatasource{cfg: config{Addr: "localhost:1234", Token: "secret"}}
+
+	secure, err := ds.candidateSecrets([]byte(`{"host": "localhost:1234"}`), nil)
+	require.NoError(t, err)
+	require.Equal(t, "secret", secure["token"])
+
+	for _, jsonData := range []string{
+		`{"host": "evil:1234"}`,
+		`{"host": "localhost:1234", "failoverHosts": ["evil:1234"]}`,
+		`{"host": "localhost:1234", "proxyUrl": "http://evil:3128"}`,
+		`{"host": "localhost:1234", "secure": true, "insecureSkipVerify": true}`,
+	} {
+		secure, err = ds.candidateSecrets([]byte(jsonData), nil)
+		require.NoError(t, err)
+		require.Empty(t, secure, jsonData)
+	}
+
+	_, err = ds.candidateSecrets([]byte(`{"host": "localhost:1234", "tokenFile": "/etc/passwd"}`), nil)
+	require.Error(t, err)
+
+	ds.cfg = config{Addr: "localhost:1234", TokenEnvVar: "FLIGHTSQL_TOKEN"}
+	_, err = ds.candidateSecrets([]byte(`{"host": "localhost:1234", "tokenEnvVar": "FLIGHTSQL_TOKEN"}`), nil)
+	require.NoError(t, err)
+	_, err = ds.candidateSecrets([]byte(`{"host": "evil:1234", "tokenEnvVar": "FLIGHTSQL_TOKEN"}`), nil)
+	require.Error(t, err)
+}
+
+func TestErrorPositionPattern(t *testing.T) {
+	m := errorPositionPattern.FindStringSubmatch("sql parser error: Expected an expression, found: where at Line: 1, Column 13")
+	require.Equal(t, []string{"Line: 1, Column 13", "1", "13"}, m)
+}
+
+// callResource sends a GET request for path to the datasource's resource
+// handler and returns the response status and body.
+func callResource(t *testing.T, ds *FlightSQLDatasource, path string) (int, []byte) {
+	t.Helper()
+	return sendResource(t, ds, http.MethodGet, path, nil)
+}
+
+func sendResource(t *testing.T, ds *FlightSQLDatasource, method, path string, body []byte) (int, []byte) {
+	t.Helper()
+
+	var sender resourceSender
+	resourcePath, _, _ := strings.Cut(path, "?")
+	err := ds.CallResource(context.Background(), &backend.CallResourceRequest{
+		Method: method,
+		Path:   resourcePath,
+		URL:    path,
+		Body:   body,
+	}, &sender)
+	require.NoError(t, err)
+	require.NotNil(t, sender.resp)
+	return sender.resp.Status, sender.resp.Body
+}
+
+type resourceSender struct {
+	resp *backend.CallResourceResponse
+}
+
+func (s *resourceSender) Send(resp *backend.CallResourceResponse) error {
+	s.resp = resp
+	return nil
+}
+
+// decodeFrames decodes the frames of a [backend.DataResponse] written by a
+// resource handler.
+func decodeFrames(t *testing.T, body []byte) data.Frames {
+	t.Helper()
+
+	var resp struct {
+		Frames data.Frames `json:"frames"`
+	}
+	require.NoError(t, json.Unmarshal(body, &resp), string(body))
+	return resp.Frames
+}
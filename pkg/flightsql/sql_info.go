@@ -0,0 +1,88 @@
+package flightsql
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/apache/arrow/go/v12/arrow/array"
+	"github.com/apache/arrow/go/v12/arrow/flight"
+	"github.com/apache/arrow/go/v12/arrow/flight/flightsql"
+	"google.golang.org/grpc"
+)
+
+// sqlInfo retrieves the values of the requested SqlInfo from the server, or
+// of all the SqlInfo it provides if none are requested.
+// Values are decoded to string, bool, int64, int32 or []string according to
+// the member of the dense union they are stored in; other values are omitted.
+// The call options apply to the GetSqlInfo call.
+func (d *FlightSQLDatasource) sqlInfo(ctx context.Context, infos []flightsql.SqlInfo, opts ...grpc.CallOption) (map[flightsql.SqlInfo]any, error) {
+	info, err := d.client.GetSqlInfo(ctx, infos, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[flightsql.SqlInfo]any)
+	for _, endpoint := range info.Endpoint {
+		if err := d.readSQLInfo(ctx, endpoint.Ticket, values); err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// readSQLInfo reads the SqlInfo values at a single endpoint into values.
+func (d *FlightSQLDatasource) readSQLInfo(ctx context.Context, ticket *flight.Ticket, values map[flightsql.SqlInfo]any) error {
+	reader, err := d.client.DoGet(ctx, ticket)
+	if err != nil {
+		return err
+	}
+	defer reader.Release()
+
+	for reader.Next() {
+		rec := reader.Record()
+		names, ok := rec.Column(0).(*array.Uint32)
+		if !ok {
+			return fmt.Errorf("unexpected info_name type: %s", rec.Column(0).DataType())
+		}
+		union, ok := rec.Column(1).(*array.DenseUnion)
+		if !ok {
+			return fmt.Errorf("unexpected value type: %s", rec.Column(1).DataType())
+		}
+		for i := 0; i < int(rec.NumRows()); i++ {
+			if v := sqlInfoValue(union, i); v != nil {
+				values[flightsql.SqlInfo(names.Value(i))] = v
+			}
+		}
+	}
+	return reader.Err()
+}
+
+// sqlInfoValue returns the i-th value of a SqlInfo dense union. Strings are
+// copied, since the reader's buffers are reused once it is released.
+func sqlInfoValue(union *array.DenseUnion, i int) any {
+	offset := int(union.ValueOffset(i))
+	switch field := union.Field(union.ChildID(i)).(type) {
+	case *array.String:
+		return strings.Clone(field.Value(offset))
+	case *array.Boolean:
+		return field.Value(offset)
+	case *array.Int64:
+		return field.Value(offset)
+	case *array.Int32:
+		return field.Value(offset)
+	case *array.List:
+		strs, ok := field.ListValues().(*array.String)
+		if !ok {
+			return nil
+		}
+		start, end := field.ValueOffsets(offset)
+		list := make([]string, 0, end-start)
+		for j := start; j < end; j++ {
+			list = append(list, strings.Clone(strs.Value(int(j))))
+		}
+		return list
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,92 @@
+package flightsql
+
+import (
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// readStats are statistics about reading the results of a query.
+type readStats struct {
+	rows    int64
+	batches int64
+	// bytes is the estimated size of the rows read into frames.
+	bytes int64
+	// convert is the time spent converting records to frames.
+	convert time.Duration
+}
+
+func (s readStats) queryStats() []data.QueryStat {
+	return []data.QueryStat{
+		queryStat("Rows", "", float64(s.rows)),
+		queryStat("Record batches", "", float64(s.batches)),
+		queryStat("Result size", "decbytes", float64(s.bytes)),
+		queryStat("Conversion time", "ms", durationMilliseconds(s.convert)),
+	}
+}
+
+// executeStats are statistics about executing a query.
+type executeStats struct {
+	// roundTrips is the number of calls made to the server.
+	roundTrips int
+	// execute is the time taken for the server to plan the query.
+	execute time.Duration
+	// fetch is the time taken to read the results, including converting
+	// them.
+	fetch time.Duration
+	// convert is the part of fetch spent converting the results to frames.
+	convert time.Duration
+}
+
+func (s executeStats) queryStats() []data.QueryStat {
+	return []data.QueryStat{
+		queryStat("Server round trips", "", float64(s.roundTrips)),
+		queryStat("Execution time", "ms", durationMilliseconds(s.execute)),
+		queryStat("Fetch time", "ms", durationMilliseconds(s.fetch)),
+	}
+}
+
+// queryTimings break down the time taken by a query, in milliseconds, so
+// that the Query Inspector shows whether it was spent by the server or by the
+// plugin.
+type queryTimings struct {
+	MacroExpansion float64 `json:"macroExpansionMs"`
+	Execute        float64 `json:"executeMs"`
+	DoGet          float64 `json:"doGetMs"`
+	Conversion     float64 `json:"conversionMs"`
+}
+
+func newQueryTimings(expansion time.Duration, s executeStats) queryTimings {
+	return queryTimings{
+		MacroExpansion: durationMilliseconds(expansion),
+		Execute:        durationMilliseconds(s.execute),
+		DoGet:          durationMilliseconds(s.fetch - s.convert),
+		Conversion:     durationMilliseconds(s.convert),
+	}
+}
+
+// setTimings adds timings to the custom metadata of frames.
+func setTimings(frames data.Frames, timings queryTimings) {
+	for _, frame := range frames {
+		if frame.Meta == nil {
+			frame.SetMeta(&data.FrameMeta{})
+		}
+		custom, ok := frame.Meta.Custom.(map[string]any)
+		if !ok {
+			custom = map[string]any{}
+			frame.Meta.Custom = custom
+		}
+		custom["timings"] = timings
+	}
+}
+
+func queryStat(name, unit string, value float64) data.QueryStat {
+	return data.QueryStat{
+		FieldConfig: data.FieldConfig{DisplayName: name, Unit: unit},
+		Value:       value,
+	}
+}
+
+func durationMilliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
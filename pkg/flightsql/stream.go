@@ -0,0 +1,163 @@
+package flightsql
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/live"
+	"google.golang.org/grpc/metadata"
+)
+
+var _ backend.StreamHandler = (*FlightSQLDatasource)(nil)
+
+// minStreamInterval is the shortest interval at which a streaming query is
+// re-executed.
+const minStreamInterval = time.Second
+
+// streamTTL is how long a registered streaming query, and the credentials
+// it was registered with, are kept for a client to subscribe to it.
+const streamTTL = 5 * time.Minute
+
+// maxStreams is the maximum number of registered streaming queries.
+const maxStreams = 10000
+
+// streamQuery is a query registered by QueryData to be re-executed by
+// RunStream.
+type streamQuery struct {
+	// user is the login of the user the query was registered for, who alone
+	// may subscribe to it, since its results are fetched with their
+	// forwarded credentials.
+	user      string
+	dataQuery backend.DataQuery
+	forwarded metadata.MD
+	origin    queryOrigin
+}
+
+// registerStream registers a streaming query and returns the Grafana Live
+// channel that clients subscribe to for updates. Channels are per user, so
+// that users don't receive results fetched with another user's credentials.
+func (d *FlightSQLDatasource) registerStream(pCtx backend.PluginContext, dataQuery backend.DataQuery, forwarded metadata.MD, origin queryOrigin) string {
+	user := streamUser(pCtx)
+	h := sha256.New()
+	h.Write([]byte(user))
+	h.Write([]byte{0})
+	h.Write([]byte(dataQuery.RefID))
+	h.Write([]byte{0})
+	h.Write(dataQuery.JSON)
+	path := "stream/" + hex.EncodeToString(h.Sum(nil))
+	d.streams.Set(path, streamQuery{
+		user:      user,
+		dataQuery: dataQuery,
+		forwarded: forwarded,
+		origin:    origin,
+	})
+
+	var uid string
+	if pCtx.DataSourceInstanceSettings != nil {
+		uid = pCtx.DataSourceInstanceSettings.UID
+	}
+	return live.Channel{
+		Scope:     live.ScopeDatasource,
+		Namespace: uid,
+		Path:      path,
+	}.String()
+}
+
+// streamUser returns the login of the user of pCtx, if any.
+func streamUser(pCtx backend.PluginContext) string {
+	if pCtx.User == nil {
+		return ""
+	}
+	return pCtx.User.Login
+}
+
+// SubscribeStream allows the user a query was registered for by QueryData to
+// subscribe to its channel.
+func (d *FlightSQLDatasource) SubscribeStream(_ context.Context, req *backend.SubscribeStreamRequest) (*backend.SubscribeStreamResponse, error) {
+	sq, ok := d.streams.Get(req.Path)
+	if !ok {
+		return &backend.SubscribeStreamResponse{
+			Status: backend.SubscribeStreamStatusNotFound,
+		}, nil
+	}
+	if sq.user != streamUser(req.PluginContext) {
+		return &backend.SubscribeStreamResponse{
+			Status: backend.SubscribeStreamStatusPermissionDenied,
+		}, nil
+	}
+	return &backend.SubscribeStreamResponse{
+		Status: backend.SubscribeStreamStatusOK,
+	}, nil
+}
+
+// PublishStream rejects all publications; streams are only written to by the
+// datasource.
+func (d *FlightSQLDatasource) PublishStream(context.Context, *backend.PublishStreamRequest) (*backend.PublishStreamResponse, error) {
+	return &backend.PublishStreamResponse{
+		Status: backend.PublishStreamStatusPermissionDenied,
+	}, nil
+}
+
+// RunStream re-executes a streaming query on its interval over a time range
+// that slides with the current time, sending the results to subscribers. The
+// query is forgotten when the stream ends, along with the credentials it was
+// registered with, until QueryData registers it again. Queries that are never
+// subscribed to are forgotten after streamTTL.
+func (d *FlightSQLDatasource) RunStream(ctx context.Context, req *backend.RunStreamRequest, sender *backend.StreamSender) error {
+	sq, ok := d.streams.Get(req.Path)
+	if !ok {
+		return fmt.Errorf("stream not found: %s", req.Path)
+	}
+	if sq.user != streamUser(req.PluginContext) {
+		return fmt.Errorf("stream not found: %s", req.Path)
+	}
+	defer d.streams.Delete(req.Path)
+
+	window := sq.dataQuery.TimeRange.To.Sub(sq.dataQuery.TimeRange.From)
+	interval := sq.dataQuery.Interval
+	if interval < minStreamInterval {
+		interval = minStreamInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-d.done:
+			return nil
+		case t := <-ticker.C:
+			dataQuery := sq.dataQuery
+			dataQuery.TimeRange = backend.TimeRange{From: t.Add(-window), To: t}
+
+			query, err := decodeQueryRequest(dataQuery)
+			if err != nil {
+				return err
+			}
+			query.Metadata = withForwarded(query.Metadata, sq.forwarded)
+			query.Origin = sq.origin
+			if err := d.adhocQuery(ctx, query); err != nil {
+				return err
+			}
+			d.limitQuery(query)
+
+			resp := d.breakerQuery(ctx, *query)
+			if resp.Error != nil {
+				log.DefaultLogger.Error("Stream query failed", "path", req.Path, "error", resp.Error)
+				continue
+			}
+			for _, frame := range resp.Frames {
+				if err := sender.SendFrame(frame, data.IncludeAll); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package flightsql
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/live"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestSubscribeStream(t *testing.T) {
+	now := time.Unix(0, 0)
+	ds := &FlightSQLDatasource{streams: newTTLCache[streamQuery](streamTTL, maxStreams)}
+	ds.streams.now = func() time.Time { return now }
+	pCtx := backend.PluginContext{
+		DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{UID: "abc"},
+		User:                       &backend.User{Login: "alice"},
+	}
+
+	channel := ds.registerStream(pCtx, backend.DataQuery{
+		RefID: "A",
+		JSON:  mustQueryJSON(t, "A", "select 1"),
+	}, metadata.MD{}, queryOrigin{})
+	ch, err := live.ParseChannel(channel)
+	require.NoError(t, err)
+	require.Equal(t, live.ScopeDatasource, ch.Scope)
+	require.Equal(t, "abc", ch.Namespace)
+
+	resp, err := ds.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{
+		PluginContext: pCtx,
+		Path:          ch.Path,
+	})
+	require.NoError(t, err)
+	require.Equal(t, backend.SubscribeStreamStatusOK, resp.Status)
+
+	// Other users can't subscribe to the channel.
+	resp, err = ds.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{
+		PluginContext: backend.PluginContext{User: &backend.User{Login: "bob"}},
+		Path:          ch.Path,
+	})
+	require.NoError(t, err)
+	require.Equal(t, backend.SubscribeStreamStatusPermissionDenied, resp.Status)
+
+	// The same query gets a channel of its own for each user.
+	other := ds.registerStream(backend.PluginContext{User: &backend.User{Login: "bob"}}, backend.DataQuery{
+		RefID: "A",
+		JSON:  mustQueryJSON(t, "A", "select 1"),
+	}, metadata.MD{}, queryOrigin{})
+	require.NotEqual(t, channel, other)
+
+	resp, err = ds.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{Path: "stream/unknown"})
+	require.NoError(t, err)
+	require.Equal(t, backend.SubscribeStreamStatusNotFound, resp.Status)
+
+	// Queries that aren't subscribed to expire.
+	now = now.Add(streamTTL)
+	resp, err = ds.SubscribeStream(context.Background(), &backend.SubscribeStreamRequest{
+		PluginContext: pCtx,
+		Path:          ch.Path,
+	})
+	require.NoError(t, err)
+	require.Equal(t, backend.SubscribeStreamStatusNotFound, resp.Status)
+}
+
+// packetRecorder is a [backend.StreamPacketSender] that records the packets
+// sent to it.
+type packetRecorder struct {
+	packets chan *backend.StreamPacket
+}
+
+func (r *packetRecorder) Send(p *backend.StreamPacket) error {
+	r.packets <- p
+	return nil
+}
+
+func TestIntegration_RunStream(t *testing.T) {
+	ds := newIntegrationDatasource(t)
+	ds.cfg.LimitMaxDataPoints = true
+	pCtx := backend.PluginContext{
+		DataSourceInstanceSettings: &backend.DataSourceInstanceSettings{UID: "abc"},
+		User:                       &backend.User{Login: "alice"},
+	}
+	now := time.Now()
+	channel := ds.registerStream(pCtx, backend.DataQuery{
+		RefID:     "A",
+		JSON:      []byte(`{"refId": "A", "format": "table", "maxDataPoints": 2, "queryText": "select * from intTable"}`),
+		Interval:  time.Second,
+		TimeRange: backend.TimeRange{From: now.Add(-time.Hour), To: now},
+	}, metadata.MD{}, queryOrigin{})
+	ch, err := live.ParseChannel(channel)
+	require.NoError(t, err)
+
+	// Other users can't run the stream.
+	err = ds.RunStream(context.Background(), &backend.RunStreamRequest{
+		PluginContext: backend.PluginContext{User: &backend.User{Login: "bob"}},
+		Path:          ch.Path,
+	}, backend.NewStreamSender(&packetRecorder{}))
+	require.Error(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	recorder := &packetRecorder{packets: make(chan *backend.StreamPacket, 1)}
+	errs := make(chan error, 1)
+	go func() {
+		errs <- ds.RunStream(ctx, &backend.RunStreamRequest{
+			PluginContext: pCtx,
+			Path:          ch.Path,
+		}, backend.NewStreamSender(recorder))
+	}()
+
+	select {
+	case p := <-recorder.packets:
+		var frame data.Frame
+		require.NoError(t, json.Unmarshal(p.Data, &frame))
+		// The stream's queries are limited like the first.
+		require.Equal(t, 2, frame.Rows())
+	case err := <-errs:
+		t.Fatalf("stream ended: %v", err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("no frame sent")
+	}
+	cancel()
+	// Drain a frame sent while cancelling.
+	go func() {
+		for range recorder.packets {
+		}
+	}()
+	require.NoError(t, <-errs)
+
+	// The query is forgotten once its stream ends.
+	_, ok := ds.streams.Get(ch.Path)
+	require.False(t, ok)
+}
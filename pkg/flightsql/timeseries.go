@@ -0,0 +1,243 @@
+package flightsql
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// longToWide pivots a long time series frame, with string or bool columns
+// labelling the series of each row, into a wide frame with a field per
+// series as alerting requires. Rows don't have to be ordered by time.
+// Frames that aren't long are returned as they are.
+//
+// If labels is not empty only the columns it names label the series, and
+// other string and bool columns are dropped.
+//
+// Missing values of series are filled as fill specifies, if it's not nil.
+func longToWide(frame *data.Frame, labels []string, fill *data.FillMissing) (*data.Frame, error) {
+	if len(labels) > 0 {
+		var err error
+		frame, err = labelColumns(frame, labels)
+		if err != nil {
+			return nil, err
+		}
+	}
+	schema := frame.TimeSeriesSchema()
+	if schema.Type != data.TimeSeriesTypeLong {
+		return frame, nil
+	}
+	return data.LongToWide(sortByTime(frame, schema.TimeIndex), fill)
+}
+
+// timeFieldNames are the names of columns preferred as the time field when a
+// frame has several time columns.
+var timeFieldNames = []string{"time", "_time", "timestamp", "ts"}
+
+// findTimeField returns the index of the time field of frame: the first time
+// column with one of timeFieldNames, or else the first time column. It
+// returns -1 if frame has no time column.
+func findTimeField(frame *data.Frame) int {
+	indices := frame.TypeIndices(data.FieldTypeTime, data.FieldTypeNullableTime)
+	if len(indices) == 0 {
+		return -1
+	}
+	for _, name := range timeFieldNames {
+		for _, i := range indices {
+			if strings.EqualFold(frame.Fields[i].Name, name) {
+				return i
+			}
+		}
+	}
+	return indices[0]
+}
+
+// promoteTimeField returns frame with the time field at index i moved to the
+// front, where panels look for it, and its rows sorted by time.
+func promoteTimeField(frame *data.Frame, i int) *data.Frame {
+	if i != 0 {
+		fields := make([]*data.Field, 0, len(frame.Fields))
+		fields = append(fields, frame.Fields[i])
+		fields = append(fields, frame.Fields[:i]...)
+		fields = append(fields, frame.Fields[i+1:]...)
+		promoted := data.NewFrame(frame.Name, fields...)
+		promoted.Meta = frame.Meta
+		frame = promoted
+	}
+	return sortByTime(frame, 0)
+}
+
+// sortByTime returns frame with its rows stably sorted by the time field at
+// index i. Null times sort first.
+func sortByTime(frame *data.Frame, i int) *data.Frame {
+	field := frame.Fields[i]
+	timeAt := func(row int) time.Time {
+		t, _ := field.ConcreteAt(row)
+		v, _ := t.(time.Time)
+		return v
+	}
+
+	rows := make([]int, field.Len())
+	sorted := true
+	for row := range rows {
+		rows[row] = row
+		if row > 0 && timeAt(row).Before(timeAt(row-1)) {
+			sorted = false
+		}
+	}
+	if sorted {
+		return frame
+	}
+	sort.SliceStable(rows, func(a, b int) bool {
+		return timeAt(rows[a]).Before(timeAt(rows[b]))
+	})
+
+	out := frame.EmptyCopy()
+	for j, f := range frame.Fields {
+		out.Fields[j].Extend(len(rows))
+		for row, from := range rows {
+			out.Fields[j].Set(row, f.CopyAt(from))
+		}
+	}
+	return out
+}
+
+// labelColumns returns frame with the columns named by labels converted to
+// strings, so that they label series, and its other string and bool columns
+// removed.
+func labelColumns(frame *data.Frame, labels []string) (*data.Frame, error) {
+	isLabel := make(map[string]bool, len(labels))
+	for _, name := range labels {
+		if _, i := frame.FieldByName(name); i == -1 {
+			return nil, fmt.Errorf("label column %q not found", name)
+		}
+		isLabel[name] = true
+	}
+
+	out := data.NewFrame(frame.Name)
+	out.Meta = frame.Meta
+	for _, f := range frame.Fields {
+		switch {
+		case isLabel[f.Name] && !isStringField(f):
+			values := make([]*string, f.Len())
+			for row := range values {
+				if v, ok := f.ConcreteAt(row); ok {
+					s := labelValue(v)
+					values[row] = &s
+				}
+			}
+			out.Fields = append(out.Fields, data.NewField(f.Name, f.Labels, values))
+		case isLabel[f.Name]:
+			out.Fields = append(out.Fields, f)
+		case isStringField(f), f.Type() == data.FieldTypeBool, f.Type() == data.FieldTypeNullableBool:
+			// Dropped so that they don't split the series.
+		default:
+			out.Fields = append(out.Fields, f)
+		}
+	}
+	return out, nil
+}
+
+// fillGaps inserts a row, filled as fill specifies, for each interval of tr
+// that a wide frame sorted by its time field, the first, has no row for. The
+// intervals are aligned to the Unix epoch like those of date_bin. Queries
+// with a time zone bin wall clock times, so the intervals are aligned in the
+// wall clock time of tr's location.
+func fillGaps(frame *data.Frame, tr backend.TimeRange, interval time.Duration, fill *data.FillMissing) *data.Frame {
+	if interval <= 0 || len(frame.Fields) == 0 {
+		return frame
+	}
+	loc := tr.From.Location()
+	step := interval.Nanoseconds()
+	from := wallNano(tr.From)
+	offset := from % step
+	if offset < 0 {
+		offset += step
+	}
+	start := from - offset
+	if n := (wallNano(tr.To) - start) / step; n > defaultRowLimit {
+		frame.AppendNotices(data.Notice{
+			Severity: data.NoticeSeverityWarning,
+			Text:     fmt.Sprintf("Gaps have not been filled because the time range spans %d intervals", n),
+		})
+		return frame
+	}
+
+	// Fields are made nullable unless gaps are filled with a value, since
+	// there may be no previous value to fill them with.
+	if fill.Mode != data.FillModeValue {
+		for i, f := range frame.Fields[1:] {
+			if !f.Nullable() {
+				frame.Fields[i+1] = nullableField(f)
+			}
+		}
+	}
+
+	times := frame.Fields[0]
+	timeAt := func(row int) time.Time {
+		t, _ := times.ConcreteAt(row)
+		v, _ := t.(time.Time)
+		return v
+	}
+	out := frame.EmptyCopy()
+	appendRow := func(row int) {
+		for i, f := range frame.Fields {
+			out.Fields[i].Append(f.At(row))
+		}
+	}
+	appendGap := func(t time.Time) {
+		if times.Nullable() {
+			out.Fields[0].Append(&t)
+		} else {
+			out.Fields[0].Append(t)
+		}
+		for _, f := range out.Fields[1:] {
+			v, err := data.GetMissing(fill, f, f.Len()-1)
+			if err != nil {
+				f.Extend(1)
+				continue
+			}
+			f.Append(v)
+		}
+	}
+
+	row, rows := 0, times.Len()
+	for wall := start; ; wall += step {
+		t := wallTime(wall, loc)
+		if !t.Before(tr.To) {
+			break
+		}
+		for row < rows && timeAt(row).Before(t) {
+			appendRow(row)
+			row++
+		}
+		if row < rows && timeAt(row).Equal(t) {
+			appendRow(row)
+			row++
+			continue
+		}
+		appendGap(t)
+	}
+	for ; row < rows; row++ {
+		appendRow(row)
+	}
+	return out
+}
+
+// wallNano returns the wall clock time of t in its location as nanoseconds
+// since the Unix epoch, as if that wall clock time were in UTC.
+func wallNano(t time.Time) int64 {
+	_, offset := t.Zone()
+	return t.UnixNano() + int64(offset)*int64(time.Second)
+}
+
+// wallTime returns the time in loc whose wall clock time is wall nanoseconds
+// since the Unix epoch, the inverse of wallNano.
+func wallTime(wall int64, loc *time.Location) time.Time {
+	u := time.Unix(0, wall).UTC()
+	return time.Date(u.Year(), u.Month(), u.Day(), u.Hour(), u.Minute(), u.Second(), u.Nanosecond(), loc)
+}
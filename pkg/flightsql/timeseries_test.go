@@ -0,0 +1,134 @@
+package flightsql
+
+import (
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLongToWide(t *testing.T) {
+	t0 := time.Unix(0, 0).UTC()
+	t1 := t0.Add(time.Minute)
+	frame := data.NewFrame("",
+		data.NewField("time", nil, []time.Time{t1, t0, t0, t1}),
+		data.NewField("usage", nil, []float64{2, 1, 3, 4}),
+		data.NewField("host", nil, []string{"a", "a", "b", "b"}),
+	)
+
+	wide, err := longToWide(frame, nil, nil)
+	require.NoError(t, err)
+	require.Equal(t, data.FrameTypeTimeSeriesWide, wide.Meta.Type)
+	require.Len(t, wide.Fields, 3)
+	require.Equal(t, []time.Time{t0, t1}, extractFieldValues[time.Time](t, wide.Fields[0]))
+	require.Equal(t, data.Labels{"host": "a"}, wide.Fields[1].Labels)
+	require.Equal(t, []float64{1, 2}, extractFieldValues[float64](t, wide.Fields[1]))
+	require.Equal(t, data.Labels{"host": "b"}, wide.Fields[2].Labels)
+	require.Equal(t, []float64{3, 4}, extractFieldValues[float64](t, wide.Fields[2]))
+}
+
+func TestLongToWide_Wide(t *testing.T) {
+	frame := data.NewFrame("",
+		data.NewField("time", nil, []time.Time{time.Unix(0, 0)}),
+		data.NewField("usage", nil, []float64{1}),
+	)
+	wide, err := longToWide(frame, nil, nil)
+	require.NoError(t, err)
+	require.Same(t, frame, wide)
+}
+
+func TestLongToWide_Labels(t *testing.T) {
+	t0 := time.Unix(0, 0).UTC()
+	frame := data.NewFrame("",
+		data.NewField("time", nil, []time.Time{t0, t0}),
+		data.NewField("usage", nil, []float64{1, 2}),
+		data.NewField("cpu", nil, []int64{0, 1}),
+		data.NewField("message", nil, []string{"ok", "busy"}),
+	)
+
+	wide, err := longToWide(frame, []string{"cpu"}, nil)
+	require.NoError(t, err)
+	require.Len(t, wide.Fields, 3)
+	require.Equal(t, data.Labels{"cpu": "0"}, wide.Fields[1].Labels)
+	require.Equal(t, data.Labels{"cpu": "1"}, wide.Fields[2].Labels)
+
+	_, err = longToWide(frame, []string{"host"}, nil)
+	require.EqualError(t, err, `label column "host" not found`)
+}
+
+func TestFindTimeField(t *testing.T) {
+	cs := []struct {
+		names []string
+		want  int
+	}{
+		{[]string{"created", "_time"}, 1},
+		{[]string{"created", "updated"}, 0},
+		{[]string{"Time", "ts"}, 0},
+	}
+	for _, c := range cs {
+		frame := data.NewFrame("",
+			data.NewField("value", nil, []float64{1}),
+			data.NewField(c.names[0], nil, []time.Time{time.Unix(0, 0)}),
+			data.NewField(c.names[1], nil, []*time.Time{nil}),
+		)
+		require.Equal(t, c.want+1, findTimeField(frame), c.names)
+	}
+
+	frame := data.NewFrame("", data.NewField("value", nil, []float64{1}))
+	require.Equal(t, -1, findTimeField(frame))
+}
+
+func TestPromoteTimeField(t *testing.T) {
+	t0 := time.Unix(0, 0).UTC()
+	t1 := t0.Add(time.Minute)
+	frame := data.NewFrame("",
+		data.NewField("value", nil, []float64{2, 1}),
+		data.NewField("_time", nil, []time.Time{t1, t0}),
+	)
+
+	promoted := promoteTimeField(frame, 1)
+	require.Equal(t, "_time", promoted.Fields[0].Name)
+	require.Equal(t, []time.Time{t0, t1}, extractFieldValues[time.Time](t, promoted.Fields[0]))
+	require.Equal(t, []float64{1, 2}, extractFieldValues[float64](t, promoted.Fields[1]))
+}
+
+func TestFillGaps(t *testing.T) {
+	t0 := time.Unix(600, 0).UTC()
+	tr := backend.TimeRange{From: t0.Add(30 * time.Second), To: t0.Add(4 * time.Minute)}
+	newFrame := func() *data.Frame {
+		return data.NewFrame("",
+			data.NewField("time", nil, []time.Time{t0, t0.Add(2 * time.Minute)}),
+			data.NewField("value", nil, []float64{1, 3}),
+		)
+	}
+	times := []time.Time{t0, t0.Add(time.Minute), t0.Add(2 * time.Minute), t0.Add(3 * time.Minute)}
+
+	filled := fillGaps(newFrame(), tr, time.Minute, &data.FillMissing{Mode: data.FillModeNull})
+	require.Equal(t, times, extractFieldValues[time.Time](t, filled.Fields[0]))
+	require.Equal(t, []*float64{ptr(1.0), nil, ptr(3.0), nil}, extractFieldValues[*float64](t, filled.Fields[1]))
+
+	filled = fillGaps(newFrame(), tr, time.Minute, &data.FillMissing{Mode: data.FillModePrevious})
+	require.Equal(t, []*float64{ptr(1.0), ptr(1.0), ptr(3.0), ptr(3.0)}, extractFieldValues[*float64](t, filled.Fields[1]))
+
+	filled = fillGaps(newFrame(), tr, time.Minute, &data.FillMissing{Mode: data.FillModeValue, Value: 0})
+	require.Equal(t, []float64{1, 0, 3, 0}, extractFieldValues[float64](t, filled.Fields[1]))
+}
+
+func TestFillGaps_Location(t *testing.T) {
+	// India is 5:30 ahead of UTC, so days in its wall clock time don't
+	// start on a multiple of a day since the epoch.
+	loc, err := time.LoadLocation("Asia/Kolkata")
+	require.NoError(t, err)
+	day := func(d int) time.Time { return time.Date(2023, 1, d, 0, 0, 0, 0, loc) }
+	tr := backend.TimeRange{From: day(1).Add(time.Hour), To: day(4).Add(time.Hour)}
+	frame := data.NewFrame("",
+		data.NewField("time", nil, []time.Time{day(1), day(3)}),
+		data.NewField("value", nil, []float64{1, 3}),
+	)
+
+	filled := fillGaps(frame, tr, 24*time.Hour, &data.FillMissing{Mode: data.FillModeNull})
+	require.Equal(t, []time.Time{day(1), day(2), day(3), day(4)}, extractFieldValues[time.Time](t, filled.Fields[0]))
+	require.Equal(t, []*float64{ptr(1.0), nil, ptr(3.0), nil}, extractFieldValues[*float64](t, filled.Fields[1]))
+}
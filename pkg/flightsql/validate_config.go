@@ -0,0 +1,187 @@
+package flightsql
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/apache/arrow/go/v12/arrow/flight"
+	"github.com/apache/arrow/go/v12/arrow/flight/flightsql"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+)
+
+// validateConfigTimeout bounds the time taken by each step of validating a
+// configuration that contacts the server.
+const validateConfigTimeout = 5 * time.Second
+
+// configCheck is the outcome of a step of validating a configuration.
+type configCheck struct {
+	Step    string `json:"step"`
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+// validateConfig checks candidate settings for the datasource before they are
+// saved, reporting the outcome of each step: the settings themselves, a
+// connection to the server, including the TLS handshake, and authenticating
+// with it. Steps after one that fails aren't run.
+func (d *FlightSQLDatasource) validateConfig(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		JSONData       json.RawMessage   `json:"jsonData"`
+		SecureJSONData map[string]string `json:"secureJsonData"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request: %s", err), http.StatusBadRequest)
+		return
+	}
+	var checks []configCheck
+	secure, err := d.candidateSecrets(req.JSONData, req.SecureJSONData)
+	if err != nil {
+		checks = []configCheck{{Step: "settings", Message: err.Error()}}
+	} else {
+		checks = checkConfig(r.Context(), req.JSONData, secure)
+	}
+	ok := true
+	for _, c := range checks {
+		ok = ok && c.OK
+	}
+	writeJSON(w, struct {
+		OK     bool          `json:"ok"`
+		Checks []configCheck `json:"checks"`
+	}{
+		OK:     ok,
+		Checks: checks,
+	})
+}
+
+// candidateSecrets returns the secrets to validate candidate settings with.
+// The config page only has the secrets that have been changed, so those
+// missing are taken from the datasource's saved settings, but only if the
+// candidate connects to the same server in the same way, so that the saved
+// secrets can't be sent to a server of the caller's choosing. For the same
+// reason, a token file or environment variable can only be validated once
+// saved, and not with a different server.
+func (d *FlightSQLDatasource) candidateSecrets(jsonData json.RawMessage, secureJSONData map[string]string) (map[string]string, error) {
+	candidate, err := loadConfig(jsonData, secureJSONData)
+	if err != nil {
+		return nil, err
+	}
+	if candidate.TokenFile != d.cfg.TokenFile || candidate.TokenEnvVar != d.cfg.TokenEnvVar {
+		return nil, fmt.Errorf("a changed token file or environment variable can't be validated until it is saved")
+	}
+	secure := map[string]string{}
+	if d.cfg.sameServer(candidate) {
+		secure = d.cfg.secureJSONData()
+	} else if candidate.TokenFile != "" || candidate.TokenEnvVar != "" {
+		return nil, fmt.Errorf("a token file or environment variable can't be validated with a different server until it is saved")
+	}
+	for k, v := range secureJSONData {
+		secure[k] = v
+	}
+	return secure, nil
+}
+
+// sameServer reports whether other connects to the same server as cfg, in a
+// way that is as secure.
+func (cfg config) sameServer(other config) bool {
+	if len(cfg.FailoverHosts) != len(other.FailoverHosts) {
+		return false
+	}
+	for i, host := range cfg.FailoverHosts {
+		if other.FailoverHosts[i] != host {
+			return false
+		}
+	}
+	// A CA certificate that wasn't posted is the saved one.
+	sameCA := other.TLSCACert == "" || other.TLSCACert == cfg.TLSCACert
+	return other.Addr == cfg.Addr &&
+		other.ProxyURL == cfg.ProxyURL &&
+		other.SecureSocksProxy == cfg.SecureSocksProxy &&
+		other.Secure == cfg.Secure &&
+		other.InsecureSkipVerify == cfg.InsecureSkipVerify &&
+		other.TLSServerName == cfg.TLSServerName &&
+		sameCA
+}
+
+// checkConfig runs the steps of validating a configuration.
+func checkConfig(ctx context.Context, jsonData json.RawMessage, secureJSONData map[string]string) []configCheck {
+	fail := func(checks []configCheck, step string, err error) []configCheck {
+		return append(checks, configCheck{Step: step, Message: err.Error()})
+	}
+
+	var checks []configCheck
+	cfg, err := loadConfig(jsonData, secureJSONData)
+	if err == nil {
+		err = cfg.validate()
+	}
+	var tokens *tokenSource
+	if err == nil {
+		tokens, err = newTokenSource(cfg)
+	}
+	var md metadata.MD
+	if err == nil {
+		md, err = newMetadata(cfg)
+	}
+	if err != nil {
+		return fail(checks, "settings", err)
+	}
+	checks = append(checks, configCheck{Step: "settings", OK: true})
+
+	dialOptions, err := grpcDialOptions(cfg)
+	if err != nil {
+		return fail(checks, "connect", err)
+	}
+	dialCtx, cancel := context.WithTimeout(ctx, validateConfigTimeout)
+	defer cancel()
+	// Dial errors that won't go away by retrying, such as a refused
+	// connection or a failed TLS handshake, are reported without waiting for
+	// the timeout.
+	conn, err := grpc.DialContext(dialCtx, dialTarget(cfg), append(dialOptions,
+		grpc.WithBlock(), grpc.WithReturnConnectionError(), grpc.FailOnNonTempDialError(true))...)
+	if err != nil {
+		return fail(checks, "connect", err)
+	}
+	defer conn.Close()
+	message := "connected"
+	if cfg.Secure {
+		message = "connected with TLS"
+	}
+	checks = append(checks, configCheck{Step: "connect", OK: true, Message: message})
+
+	if cfg.OAuthPassThru {
+		// The user's credentials are only forwarded with their queries.
+		return append(checks, configCheck{Step: "authenticate", OK: true, Message: "skipped for oauth"})
+	}
+	client := &flightsql.Client{
+		Client: flight.NewClientFromConn(conn, nil),
+		Alloc:  allocator,
+	}
+	authCtx, cancel := context.WithTimeout(ctx, validateConfigTimeout)
+	defer cancel()
+	if len(cfg.Username) > 0 || len(cfg.Password) > 0 {
+		authCtx, err = client.Client.AuthenticateBasicToken(authCtx, cfg.Username, cfg.Password)
+		if err != nil {
+			return fail(checks, "authenticate", err)
+		}
+		authMD, _ := metadata.FromOutgoingContext(authCtx)
+		md = metadata.Join(md, authMD)
+	}
+	switch {
+	case cfg.Token != "":
+		md.Set(cfg.authHeader(), cfg.credential(cfg.Token))
+	case tokens != nil:
+		md.Set(cfg.authHeader(), cfg.credential(tokens.Token()))
+	}
+	authCtx = metadata.NewOutgoingContext(authCtx, md)
+	// Servers authenticate calls before handling them, so one that doesn't
+	// implement GetSqlInfo has still accepted the credentials.
+	_, err = client.GetSqlInfo(authCtx, []flightsql.SqlInfo{flightsql.SqlInfoFlightSqlServerName})
+	if st, ok := grpcStatus(err); err != nil && (!ok || st.Code() != codes.Unimplemented) {
+		return fail(checks, "authenticate", err)
+	}
+	return append(checks, configCheck{Step: "authenticate", OK: true, Message: cfg.authMode()})
+}
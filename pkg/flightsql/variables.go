@@ -0,0 +1,126 @@
+package flightsql
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// variableQueryType is the query type of queries that populate the options
+// of a template variable.
+const variableQueryType = "variable"
+
+// variablePattern matches the $name, ${name}, ${name:format} and [[name]]
+// forms of template variable references.
+var variablePattern = regexp.MustCompile(`\$(\w+)|\$\{(\w+)(?::\w+)?\}|\[\[(\w+)\]\]`)
+
+// errVariablesWithParameters is returned when a query both binds variables
+// and has explicit parameters, whose relative order would be ambiguous.
+var errVariablesWithParameters = errors.New("variables cannot be bound in a query with parameters")
+
+// bindVariables replaces references to the template variables in vars with
+// parameter placeholders, returning the parameters to bind to them in order.
+// Multi-value variables expand to a placeholder per value so they can be
+// used in IN lists. Strings that are numbers are bound as numbers since
+// Grafana represents all variable values as strings. References to variables
+// not in vars, including macros, are left untouched.
+func bindVariables(sql string, vars map[string]json.RawMessage) (string, []json.RawMessage, error) {
+	var (
+		params []json.RawMessage
+		err    error
+	)
+	sql = variablePattern.ReplaceAllStringFunc(sql, func(ref string) string {
+		m := variablePattern.FindStringSubmatch(ref)
+		name := m[1] + m[2] + m[3]
+		raw, ok := vars[name]
+		if !ok || err != nil {
+			return ref
+		}
+
+		var values []json.RawMessage
+		if json.Unmarshal(raw, &values) != nil {
+			values = []json.RawMessage{raw}
+		}
+		if len(values) == 0 {
+			err = fmt.Errorf("variable %q has no values", name)
+			return ref
+		}
+		placeholders := make([]string, len(values))
+		for i, v := range values {
+			placeholders[i] = "?"
+			params = append(params, variableParameter(v))
+		}
+		return strings.Join(placeholders, ", ")
+	})
+	if err != nil {
+		return "", nil, err
+	}
+	return sql, params, nil
+}
+
+// variableParameter types a template variable value, binding strings that
+// are numbers as numbers.
+func variableParameter(v json.RawMessage) json.RawMessage {
+	var s string
+	if json.Unmarshal(v, &s) != nil {
+		return v
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil && json.Valid([]byte(s)) {
+		return json.RawMessage(s)
+	}
+	return v
+}
+
+// variableFrame maps the results of a variable query to a frame of the text
+// and value of each option, the form in which Grafana reads template variable
+// options. Columns named __text and __value are used if present; otherwise
+// the first column is the text and the second, if any, the value. Rows with
+// a null text or value are skipped.
+func variableFrame(frames data.Frames) (*data.Frame, error) {
+	first := frames[0]
+	if len(first.Fields) == 0 {
+		return nil, fmt.Errorf("variable query returned no columns")
+	}
+	_, textIdx := first.FieldByName("__text")
+	_, valueIdx := first.FieldByName("__value")
+	switch {
+	case textIdx == -1 && valueIdx == -1:
+		textIdx, valueIdx = 0, 0
+		if len(first.Fields) > 1 {
+			valueIdx = 1
+		}
+	case textIdx == -1:
+		textIdx = valueIdx
+	case valueIdx == -1:
+		valueIdx = textIdx
+	}
+
+	var texts, values []string
+	for _, frame := range frames {
+		text, value := frame.Fields[textIdx], frame.Fields[valueIdx]
+		for row := 0; row < frame.Rows(); row++ {
+			t, ok := text.ConcreteAt(row)
+			if !ok {
+				continue
+			}
+			v, ok := value.ConcreteAt(row)
+			if !ok {
+				continue
+			}
+			texts = append(texts, labelValue(t))
+			values = append(values, labelValue(v))
+		}
+	}
+
+	out := data.NewFrame(first.Name,
+		data.NewField("text", nil, texts),
+		data.NewField("value", nil, values),
+	)
+	out.Meta = first.Meta
+	return out, nil
+}
@@ -0,0 +1,98 @@
+package flightsql
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/data/sqlutil"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBindVariables(t *testing.T) {
+	vars := map[string]json.RawMessage{
+		"host":  json.RawMessage(`"a'; drop table cpu; --"`),
+		"hosts": json.RawMessage(`["a", "b"]`),
+		"n":     json.RawMessage(`"10"`),
+		"id":    json.RawMessage(`"001x"`),
+	}
+
+	sql, params, err := bindVariables(
+		`select * from cpu where host = $host and host in (${hosts:csv}) and n > [[n]] and id = $id and $__timeFilter(time) and x = $unknown`,
+		vars,
+	)
+	require.NoError(t, err)
+	require.Equal(t, `select * from cpu where host = ? and host in (?, ?) and n > ? and id = ? and $__timeFilter(time) and x = $unknown`, sql)
+	require.Equal(t, rawParams(`"a'; drop table cpu; --"`, `"a"`, `"b"`, `10`, `"001x"`), params)
+
+	_, _, err = bindVariables(`select $empty`, map[string]json.RawMessage{"empty": json.RawMessage(`[]`)})
+	require.Error(t, err)
+}
+
+func TestDecodeQueryRequest_BindVariables(t *testing.T) {
+	query, err := decodeQueryRequest(backend.DataQuery{
+		JSON: []byte(`{"queryText": "select * from cpu where host = $host", "bindVariables": true, "variables": {"host": "a"}}`),
+	})
+	require.NoError(t, err)
+	require.Equal(t, "select * from cpu where host = ?", query.RawSQL)
+	require.Equal(t, rawParams(`"a"`), query.Parameters)
+
+	_, err = decodeQueryRequest(backend.DataQuery{
+		JSON: []byte(`{"queryText": "select $host, ?", "bindVariables": true, "variables": {"host": "a"}, "parameters": [1]}`),
+	})
+	require.ErrorIs(t, err, errVariablesWithParameters)
+}
+
+func TestVariableFrame(t *testing.T) {
+	options := func(f *data.Frame) []string {
+		texts := make([]string, f.Rows())
+		for i := range texts {
+			texts[i] = f.Fields[0].At(i).(string) + "=" + f.Fields[1].At(i).(string)
+		}
+		return texts
+	}
+
+	host := "b"
+	out, err := variableFrame(data.Frames{
+		data.NewFrame("", data.NewField("host", nil, []*string{nil, &host})),
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"b=b"}, options(out))
+
+	out, err = variableFrame(data.Frames{
+		data.NewFrame("", data.NewField("name", nil, []string{"a"}), data.NewField("id", nil, []int64{1})),
+		data.NewFrame("", data.NewField("name", nil, []string{"b"}), data.NewField("id", nil, []int64{2})),
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"a=1", "b=2"}, options(out))
+
+	out, err = variableFrame(data.Frames{
+		data.NewFrame("",
+			data.NewField("region", nil, []string{"eu"}),
+			data.NewField("__value", nil, []int64{7}),
+			data.NewField("__text", nil, []string{"seven"}),
+		),
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"seven=7"}, options(out))
+
+	_, err = variableFrame(data.Frames{data.NewFrame("")})
+	require.Error(t, err)
+}
+
+func TestDecodeQueryRequest_Variable(t *testing.T) {
+	query, err := decodeQueryRequest(backend.DataQuery{
+		QueryType: variableQueryType,
+		JSON:      []byte(`{"queryText": "select host from cpu", "format": "time_series"}`),
+	})
+	require.NoError(t, err)
+	require.True(t, query.Variable)
+	require.Equal(t, sqlutil.FormatOptionTable, query.Format)
+
+	_, err = decodeQueryRequest(backend.DataQuery{
+		QueryType: variableQueryType,
+		JSON:      []byte(`{"queryText": "select host from cpu", "stream": true}`),
+	})
+	require.Error(t, err)
+}
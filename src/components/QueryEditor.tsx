@@ -37,8 +37,10 @@ export function QueryEditor(props: QueryEditorProps<FlightSQLDataSource, SQLQuer
     ;(async () => {
       const res = await datasource.getMacros()
       const prefix = `$__`
-      const macroArr = res?.macros.map((m: any) => prefix.concat(m))
-      const macros = macroArr.map((m: any) => ({text: m, name: m, id: m, type: MacroType.Value, args: []}))
+      const macros = res?.details.map((m: any) => {
+        const name = prefix.concat(m.name)
+        return {text: name, name: name, id: name, type: MacroType.Value, args: m.args}
+      })
       setMacros(macros)
     })()
   }, [datasource])
@@ -1,5 +1,6 @@
-import {ScopedVars} from '@grafana/data'
+import {FieldType, ScopedVars, toDataFrame} from '@grafana/data'
 import * as runtime from '@grafana/runtime'
+import {of} from 'rxjs'
 
 import {mockDatasource, mockQuery} from './mock-datasource'
 
@@ -19,6 +20,7 @@ describe('DataSource', () => {
     beforeEach(() => {
       jest.spyOn(runtime, 'getTemplateSrv').mockImplementation(() => ({
         getVariables: jest.fn(),
+        getAdhocFilters: jest.fn(() => []),
         replace: replace,
         containsTemplate: jest.fn(),
         updateTimeRange: jest.fn(),
@@ -37,5 +39,67 @@ describe('DataSource', () => {
       )
       expect(res.queryText).toEqual(`select * from org where var in ('host','orgID')`)
     })
+
+    it('should send the values of the variables referenced when binding', () => {
+      const values: Record<string, string> = {'${host:json}': '"a"', '${org:json}': '["1","2"]'}
+      jest.spyOn(runtime, 'getTemplateSrv').mockImplementation(() => ({
+        getVariables: jest.fn(() => [{name: 'host'}, {name: 'org'}, {name: 'region'}, {name: 'ho'}] as any),
+        getAdhocFilters: jest.fn(() => []),
+        replace: jest.fn((target?: string) => values[target ?? ''] ?? ''),
+        containsTemplate: jest.fn(),
+        updateTimeRange: jest.fn(),
+      }))
+      const res = mockDatasource.applyTemplateVariables(
+        {...mockQuery, bindVariables: true, queryText: 'select * from cpu where host = $host and org in (${org:csv})'},
+        scopedVars
+      )
+      expect(res.variables).toEqual({host: 'a', org: ['1', '2']})
+    })
+  })
+
+  describe('metricFindQuery', () => {
+    beforeEach(() => {
+      jest.spyOn(runtime, 'getTemplateSrv').mockImplementation(() => ({
+        getVariables: jest.fn(() => []),
+        getAdhocFilters: jest.fn(() => []),
+        replace: jest.fn((target?: string) => target ?? ''),
+        containsTemplate: jest.fn(),
+        updateTimeRange: jest.fn(),
+      }))
+    })
+    afterEach(() => {
+      jest.restoreAllMocks()
+    })
+
+    it('should run a variable query and return its options', async () => {
+      const frame = toDataFrame({
+        fields: [
+          {name: 'text', type: FieldType.string, values: ['a', 'b']},
+          {name: 'value', type: FieldType.string, values: ['1', '2']},
+        ],
+      })
+      const query = jest.spyOn(runtime.DataSourceWithBackend.prototype, 'query').mockReturnValue(of({data: [frame]}))
+      const res = await mockDatasource.metricFindQuery('select host from hosts')
+      expect(query.mock.calls[0][0].targets[0]).toMatchObject({
+        queryText: 'select host from hosts',
+        queryType: 'variable',
+        format: 'table',
+      })
+      expect(res).toEqual([
+        {text: 'a', value: '1'},
+        {text: 'b', value: '2'},
+      ])
+    })
+
+    it('should send the search filter', async () => {
+      const query = jest
+        .spyOn(runtime.DataSourceWithBackend.prototype, 'query')
+        .mockReturnValue(of({data: [toDataFrame({fields: []})]}))
+      const res = await mockDatasource.metricFindQuery('select host from hosts where host like $__searchFilter', {
+        searchFilter: 'web',
+      })
+      expect(query.mock.calls[0][0].targets[0].searchFilter).toEqual('web')
+      expect(res).toEqual([])
+    })
   })
 })
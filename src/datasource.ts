@@ -1,5 +1,15 @@
-import {DataSourceInstanceSettings, CoreApp, ScopedVars, VariableWithMultiSupport} from '@grafana/data'
+import {
+  DataQueryRequest,
+  DataSourceInstanceSettings,
+  CoreApp,
+  getDefaultTimeRange,
+  LegacyMetricFindQueryOptions,
+  MetricFindValue,
+  ScopedVars,
+  VariableWithMultiSupport,
+} from '@grafana/data'
 import {DataSourceWithBackend, getTemplateSrv} from '@grafana/runtime'
+import {lastValueFrom} from 'rxjs'
 import {SQLQuery, FlightSQLDataSourceOptions, DEFAULT_QUERY} from './types'
 
 export class FlightSQLDataSource extends DataSourceWithBackend<SQLQuery, FlightSQLDataSourceOptions> {
@@ -11,6 +21,39 @@ export class FlightSQLDataSource extends DataSourceWithBackend<SQLQuery, FlightS
     return DEFAULT_QUERY
   }
 
+  query(request: DataQueryRequest<SQLQuery>) {
+    // Queries in the dashboard's time zone are sent the zone it resolves to.
+    const timezone = resolveTimezone(request.timezone)
+    const targets = request.targets.map((t) => (t.timezone === 'dashboard' ? {...t, timezone} : t))
+    return super.query({...request, targets})
+  }
+
+  // metricFindQuery runs the query of a template variable with the variable
+  // query type, for which the backend returns its options as text and value
+  // columns. The term typed into the variable's search box is sent as the
+  // query's searchFilter.
+  async metricFindQuery(query: SQLQuery | string, options?: LegacyMetricFindQueryOptions): Promise<MetricFindValue[]> {
+    const target: SQLQuery = {
+      ...(typeof query === 'string' ? {queryText: query} : query),
+      refId: 'variable',
+      queryType: 'variable',
+      format: 'table',
+      searchFilter: options?.searchFilter,
+    }
+    const request = {
+      targets: [target],
+      range: options?.range ?? getDefaultTimeRange(),
+      scopedVars: options?.scopedVars ?? {},
+    } as DataQueryRequest<SQLQuery>
+    const response = await lastValueFrom(this.query(request))
+    const frame = response.data[0]
+    if (!frame || frame.fields.length < 2) {
+      return []
+    }
+    const [text, value] = frame.fields
+    return text.values.toArray().map((t: string, i: number) => ({text: t, value: value.values.get(i)}))
+  }
+
   quoteLiteral(value: string) {
     return "'" + value.replace(/'/g, "''") + "'"
   }
@@ -37,6 +80,26 @@ export class FlightSQLDataSource extends DataSourceWithBackend<SQLQuery, FlightS
   }
 
   applyTemplateVariables(query: SQLQuery, scopedVars: ScopedVars): Record<string, any> {
+    // The backend applies the dashboard's ad hoc filters to the query.
+    const adhocFilters = getTemplateSrv().getAdhocFilters(this.name)
+    if (adhocFilters.length > 0) {
+      query = {...query, adhocFilters}
+    }
+
+    if (query.bindVariables) {
+      // Leave the references in place and send the values for the backend to
+      // bind as parameters.
+      const templateSrv = getTemplateSrv()
+      const referenced = variableReferences(query.queryText)
+      const variables: Record<string, any> = {}
+      for (const {name} of templateSrv.getVariables()) {
+        if (referenced.has(name)) {
+          variables[name] = JSON.parse(templateSrv.replace(`\${${name}:json}`, scopedVars))
+        }
+      }
+      return {...query, variables}
+    }
+
     const interpolatedQuery: SQLQuery = {
       ...query,
       queryText: getTemplateSrv().replace(query.queryText, scopedVars, this.interpolateVariable),
@@ -56,7 +119,88 @@ export class FlightSQLDataSource extends DataSourceWithBackend<SQLQuery, FlightS
     return this.getResource(`/flightsql/columns?table=${table}`)
   }
 
+  getTagKeys(): Promise<any> {
+    return this.getResource('/flightsql/tag-keys')
+  }
+
+  getTagValues(options: {key: string}): Promise<any> {
+    return this.getResource('/flightsql/tag-values', {key: options.key})
+  }
+
+  getCatalogs(): Promise<any> {
+    return this.getResource('/flightsql/catalogs')
+  }
+
+  getDatabases(): Promise<string[]> {
+    return this.getResource('/flightsql/databases')
+  }
+
+  getSchemas(catalog?: string): Promise<any> {
+    return this.getResource('/flightsql/schemas', catalog !== undefined ? {catalog} : undefined)
+  }
+
+  getTableTypes(): Promise<any> {
+    return this.getResource('/flightsql/table-types')
+  }
+
+  getTypeInfo(dataType?: number): Promise<any> {
+    return this.getResource('/flightsql/type-info', dataType !== undefined ? {dataType} : undefined)
+  }
+
+  getPrimaryKeys(table: string): Promise<any> {
+    return this.getResource('/flightsql/primary-keys', {table})
+  }
+
+  getForeignKeys(table: string): Promise<any> {
+    return this.getResource('/flightsql/foreign-keys', {table})
+  }
+
+  getPreview(table: string, limit?: number): Promise<any> {
+    return this.getResource('/flightsql/preview', {table, limit})
+  }
+
+  getSQLDialect(): Promise<any> {
+    return this.getResource('/flightsql/sql-dialect')
+  }
+
+  validateSQL(queryText: string): Promise<any> {
+    return this.postResource('/flightsql/validate-sql', {queryText})
+  }
+
+  validateConfig(jsonData: any, secureJsonData?: any): Promise<any> {
+    return this.postResource('/flightsql/validate-config', {jsonData, secureJsonData})
+  }
+
   getMacros(): Promise<any> {
     return this.getResource('/plugin/macros')
   }
+
+  getStats(): Promise<any> {
+    return this.getResource('/plugin/stats')
+  }
+}
+
+function resolveTimezone(timezone?: string): string {
+  if (!timezone || timezone === 'browser') {
+    return Intl.DateTimeFormat().resolvedOptions().timeZone
+  }
+  if (timezone === 'utc') {
+    return 'UTC'
+  }
+  return timezone
+}
+
+// variablePattern matches the $name, ${name}, ${name:format} and [[name]]
+// forms of template variable references, as the backend does.
+const variablePattern = /\$(\w+)|\$\{(\w+)(?::\w+)?\}|\[\[(\w+)\]\]/g
+
+// variableReferences returns the names of the variables referenced by text.
+function variableReferences(text?: string): Set<string> {
+  const names = new Set<string>()
+  const pattern = new RegExp(variablePattern)
+  let m: RegExpExecArray | null
+  while ((m = pattern.exec(text ?? '')) !== null) {
+    names.add(m[1] ?? m[2] ?? m[3])
+  }
+  return names
 }
@@ -11,6 +11,50 @@ export interface SQLQuery extends DataQuery {
   orderBy?: string
   groupBy?: string
   limit?: string
+  database?: string
+  stream?: boolean
+  statement?: boolean
+  parameters?: Array<string | number | boolean | null>
+  bindVariables?: boolean
+  variables?: Record<string, string | string[]>
+  builder?: BuilderQuery
+  logs?: {body?: string; severity?: string}
+  labels?: string[]
+  partitionBy?: string[]
+  /** Display rules applied after the datasource's. */
+  columnRules?: ColumnRule[]
+  /** An IANA time zone, or 'dashboard' for the dashboard's time zone. */
+  timezone?: string
+  /** Metadata sent with this query alone. */
+  metadata?: Array<Record<string, string>>
+  /** The search term $__searchFilter matches, for variable queries. */
+  searchFilter?: string
+  adhocFilters?: Array<{key: string; operator: string; value: string}>
+}
+
+/**
+ * A structured query compiled to SQL by the backend when `queryText` is empty.
+ */
+export interface BuilderQuery {
+  table: string
+  schema?: string
+  timeColumn?: string
+  columns?: Array<{name: string; aggregation?: string; alias?: string}>
+  filters?: Array<{column: string; operator: string; value?: string | number | boolean | Array<string | number>}>
+  groupBy?: string[]
+  orderBy?: Array<{column: string; direction?: 'asc' | 'desc'}>
+  limit?: number
+}
+
+/**
+ * Configures how the fields of the columns matching `column`, which may be a
+ * pattern such as `*_bytes`, are displayed.
+ */
+export interface ColumnRule {
+  column: string
+  displayName?: string
+  unit?: string
+  decimals?: number
 }
 
 export const DEFAULT_QUERY: Partial<SQLQuery> = {}
@@ -21,7 +65,47 @@ export const DEFAULT_QUERY: Partial<SQLQuery> = {}
 export interface FlightSQLDataSourceOptions extends DataSourceJsonData {
   host?: string
   token?: string
+  tokenFile?: string
+  tokenEnvVar?: string
+  authHeader?: string
+  authScheme?: string
   secure?: boolean
+  insecureSkipVerify?: boolean
+  tlsServerName?: string
+  database?: string
+  databaseKey?: string
+  databasesQuery?: string
+  catalog?: string
+  catalogKey?: string
+  schema?: string
+  schemaKey?: string
+  oauthPassThru?: boolean
+  forwardGrafanaUser?: boolean
+  enableSecureSocksProxy?: boolean
+  proxyUrl?: string
+  compression?: string
+  maxRecvMsgSizeMB?: number
+  roundRobin?: boolean
+  failoverHosts?: string[]
+  adhocFilterTable?: string
+  failoverProbeSeconds?: number
+  connectionPoolSize?: number
+  maxFrameRows?: number
+  maxRows?: number
+  limitMaxDataPoints?: boolean
+  maxQueryMemoryMB?: number
+  maxConcurrentQueries?: number
+  queryCacheTTLSeconds?: number
+  decimalAsString?: boolean
+  binaryEncoding?: 'base64' | 'hex'
+  columnRules?: ColumnRule[]
+  healthCheckQuery?: string
+  queryLogLevel?: 'debug' | 'info' | 'warn' | 'error' | 'off'
+  slowQueryThresholdMs?: number
+  auditLog?: boolean
+  doGetRetries?: number
+  circuitBreakerFailures?: number
+  circuitBreakerCooldownSeconds?: number
   username?: string
   password?: string
   selectedAuthType?: string
@@ -31,6 +115,9 @@ export interface FlightSQLDataSourceOptions extends DataSourceJsonData {
 export interface SecureJsonData {
   password?: string
   token?: string
+  tlsCACert?: string
+  // Secure metadata values are stored as `metadata.<key>`.
+  [key: `metadata.${string}`]: string | undefined
 }
 
 export type TablesResponse = {
@@ -55,9 +142,11 @@ export const sqlLanguageDefinition = {
 export enum QueryFormat {
   Timeseries = 'time_series',
   Table = 'table',
+  Logs = 'logs',
 }
 
 export const QUERY_FORMAT_OPTIONS = [
   {label: 'Time series', value: QueryFormat.Timeseries},
   {label: 'Table', value: QueryFormat.Table},
+  {label: 'Logs', value: QueryFormat.Logs},
 ]